@@ -1,13 +1,16 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"time"
 
 	"workspace/FictionGeminiRewritten/internal/handlers"
+	"workspace/FictionGeminiRewritten/internal/prompts"
 	"workspace/FictionGeminiRewritten/internal/services"
 
 	// Assuming genai and option are correctly vendored or in GOPATH
@@ -35,7 +38,38 @@ func enableCORS(next http.Handler) http.Handler {
 	})
 }
 
-const defaultPort = "8080"
+const (
+	defaultPort         = "8080"
+	defaultJobWorkers   = 2
+	defaultJobQueueSize = 100
+	defaultJobRetention = 24 * time.Hour
+
+	defaultPromptsReloadInterval = 2 * time.Second
+)
+
+// envInt reads a positive integer from the environment, falling back to def.
+func envInt(name string, def int) int {
+	if v := os.Getenv(name); v != "" {
+		n, err := strconv.Atoi(v)
+		if err == nil && n > 0 {
+			return n
+		}
+		log.Printf("Ignoring invalid %s=%q, using %d", name, v, def)
+	}
+	return def
+}
+
+// envDuration reads a positive duration (e.g. "12h") from the environment, falling back to def.
+func envDuration(name string, def time.Duration) time.Duration {
+	if v := os.Getenv(name); v != "" {
+		d, err := time.ParseDuration(v)
+		if err == nil && d > 0 {
+			return d
+		}
+		log.Printf("Ignoring invalid %s=%q, using %s", name, v, def)
+	}
+	return def
+}
 
 func main() {
 	port := os.Getenv("PORT")
@@ -45,14 +79,65 @@ func main() {
 
 
 	// Initialize Services
-	orchestratorSvc := services.NewOrchestratorService()
+	var routing services.ModelRouting
+	if path := os.Getenv("MODEL_ROUTES_FILE"); path != "" {
+		var err error
+		if routing, err = services.LoadModelRouting(path); err != nil {
+			log.Fatalf("Invalid MODEL_ROUTES_FILE: %v", err)
+		}
+		log.Printf("Loaded model routes from %s", path)
+	}
+	prices := services.DefaultPriceTable
+	if path := os.Getenv("MODEL_PRICES_FILE"); path != "" {
+		var err error
+		if prices, err = services.LoadPriceTable(path); err != nil {
+			log.Fatalf("Invalid MODEL_PRICES_FILE: %v", err)
+		}
+		log.Printf("Loaded model prices from %s", path)
+	}
+	// A template referencing data the orchestrator doesn't supply would fail mid-generation.
+	promptsDir := os.Getenv("PROMPTS_DIR")
+	templates, err := prompts.NewRegistry(promptsDir)
+	if err != nil {
+		log.Fatalf("Invalid prompt templates:\n%v", err)
+	}
+	if promptsDir != "" {
+		log.Printf("Loaded prompt template overrides from %s", promptsDir)
+		go templates.Watch(context.Background(), envDuration("PROMPTS_RELOAD_INTERVAL", defaultPromptsReloadInterval))
+	}
+
+	orchestratorSvc := services.NewOrchestratorService(routing, prices, templates)
+	jobSvc := services.NewJobService(orchestratorSvc,
+		envInt("JOB_WORKERS", defaultJobWorkers),
+		envInt("JOB_QUEUE_SIZE", defaultJobQueueSize),
+		envDuration("JOB_RETENTION", defaultJobRetention))
 
 	// Initialize Handlers
-	generateHandler := handlers.NewGenerateHandler(orchestratorSvc)
+	generateHandler := handlers.NewGenerateHandler(orchestratorSvc, jobSvc)
+	streamHandler := handlers.NewStreamHandler(orchestratorSvc)
+	jobsHandler := handlers.NewJobsHandler(jobSvc)
+	estimateHandler := handlers.NewEstimateHandler(orchestratorSvc)
+	previewHandler := handlers.NewPreviewHandler(orchestratorSvc)
+	promptsHandler := handlers.NewPromptsHandler(templates)
+	experimentsHandler := handlers.NewExperimentsHandler(orchestratorSvc)
+	cardsHandler := handlers.NewCardsHandler()
+	importHandler := handlers.NewImportHandler()
+	simulateHandler := handlers.NewSimulateHandler()
 
 	// Setup Router
 	mux := http.NewServeMux()
 	mux.Handle("/generate", enableCORS(generateHandler)) // THIS LINE IS MODIFIED
+	mux.Handle("/generate/stream", enableCORS(streamHandler))
+	mux.Handle("/jobs", enableCORS(jobsHandler))
+	mux.Handle("/jobs/{id}", enableCORS(jobsHandler))
+	mux.Handle("/estimate", enableCORS(estimateHandler))
+	mux.Handle("/preview", enableCORS(previewHandler))
+	mux.Handle("/prompts", enableCORS(promptsHandler))
+	mux.Handle("/experiments", enableCORS(experimentsHandler))
+	mux.Handle("/cards/{id}", enableCORS(cardsHandler))
+	mux.Handle("/cards/{id}/{file}", enableCORS(cardsHandler))
+	mux.Handle("/import", enableCORS(importHandler))
+	mux.Handle("/simulate", enableCORS(simulateHandler))
 
 	// Root handler for basic check
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
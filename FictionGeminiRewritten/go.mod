@@ -6,6 +6,7 @@ toolchain go1.23.9
 
 require (
 	github.com/google/generative-ai-go v0.14.0
+	github.com/googleapis/gax-go/v2 v2.12.4
 	google.golang.org/api v0.180.0
 )
 
@@ -23,7 +24,6 @@ require (
 	github.com/google/s2a-go v0.1.9 // indirect
 	github.com/google/uuid v1.6.0 // indirect
 	github.com/googleapis/enterprise-certificate-proxy v0.3.6 // indirect
-	github.com/googleapis/gax-go/v2 v2.12.4 // indirect
 	go.opencensus.io v0.24.0 // indirect
 	go.opentelemetry.io/auto/sdk v1.1.0 // indirect
 	go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc v0.60.0 // indirect
@@ -0,0 +1,403 @@
+// Package activation simulates which entries of a lorebook a frontend such as SillyTavern would
+// insert into the prompt after a given chat, and why.
+//
+// A pass works like SillyTavern's World Info scan: enabled entries are matched against the last
+// ScanDepth messages, constant entries always trigger, and with recursive scanning the content
+// of triggered entries is scanned again for further keys. Triggered entries then roll for their
+// probability and compete in their inclusion groups, both drawn from the seed so a run can be
+// repeated, and are inserted by insertion order, highest first, until the token budget runs out.
+package activation
+
+import (
+	"cmp"
+	"fmt"
+	"hash/fnv"
+	"math/rand/v2"
+	"regexp"
+	"slices"
+	"strings"
+
+	"workspace/FictionGeminiRewritten/internal/ai"
+	"workspace/FictionGeminiRewritten/internal/models"
+	"workspace/FictionGeminiRewritten/internal/worldinfo"
+)
+
+// Defaults used when neither the request nor the lorebook sets a value, as in SillyTavern.
+const (
+	DefaultScanDepth   = 2
+	defaultGroupWeight = 100
+)
+
+// Settings tune a simulation. Zero ScanDepth and TokenBudget use the lorebook's.
+type Settings struct {
+	ScanDepth   int
+	TokenBudget int
+	Seed        int64
+}
+
+// entry is a lorebook entry prepared for matching.
+type entry struct {
+	index     int
+	source    models.LorebookEntry
+	keys      []key
+	secondary []key
+}
+
+// key is one compiled key of an entry.
+type key struct {
+	text    string
+	pattern *regexp.Regexp
+}
+
+// simulation holds the state of one run.
+type simulation struct {
+	book      models.Lorebook
+	settings  Settings
+	entries   []*entry
+	chat      string // The scanned messages
+	messages  int    // Messages in the whole chat, for delays
+	decided   map[int]bool
+	groups    map[string]int // Winner of each inclusion group, by entry index
+	activated []models.ActivatedEntry
+	result    models.ActivationResult
+}
+
+// Simulate runs book against chat, its messages oldest first.
+func Simulate(book models.Lorebook, chat []string, settings Settings) models.ActivationResult {
+	var warnings []string
+	if book.ScanDepth < 0 || book.TokenBudget < 0 {
+		warnings = append(warnings, fmt.Sprintf("the lorebook's negative scan depth (%d) or token budget (%d) was ignored", book.ScanDepth, book.TokenBudget))
+	}
+	settings.ScanDepth = cmp.Or(max(settings.ScanDepth, 0), max(book.ScanDepth, 0), DefaultScanDepth)
+	settings.TokenBudget = cmp.Or(max(settings.TokenBudget, 0), max(book.TokenBudget, 0))
+	s := &simulation{
+		book:     book,
+		settings: settings,
+		chat:     strings.Join(chat[max(len(chat)-settings.ScanDepth, 0):], "\n"),
+		messages: len(chat),
+		decided:  map[int]bool{},
+		groups:   map[string]int{},
+		result: models.ActivationResult{
+			Seed:        settings.Seed,
+			ScanDepth:   settings.ScanDepth,
+			TokenBudget: settings.TokenBudget,
+			Triggered:   []models.ActivatedEntry{},
+			CutByBudget: []models.ActivatedEntry{},
+			Skipped:     []models.ActivatedEntry{},
+			Warnings:    warnings,
+		},
+	}
+	for i, source := range book.Entries {
+		if source.Enabled {
+			s.entries = append(s.entries, s.prepare(i, source))
+		}
+	}
+
+	s.scan(0, s.chat, nil)
+	if book.RecursiveScanning {
+		for level, scanned := 1, 0; scanned < len(s.activated); level++ {
+			var sources []models.ActivatedEntry
+			for _, activated := range s.activated[scanned:] {
+				if !book.Entries[activated.Index].PreventRecursion {
+					sources = append(sources, activated)
+				}
+			}
+			scanned = len(s.activated)
+			if len(sources) == 0 {
+				break
+			}
+			s.scan(level, s.recursionText(), sources)
+		}
+	}
+	s.applyBudget()
+	return s.result
+}
+
+// prepare compiles the keys of an entry, reporting those that can't be used.
+func (s *simulation) prepare(index int, source models.LorebookEntry) *entry {
+	e := &entry{index: index, source: source}
+	for _, list := range []struct {
+		texts []string
+		keys  *[]key
+	}{{source.Keys, &e.keys}, {source.SecondaryKeys, &e.secondary}} {
+		for _, text := range list.texts {
+			if strings.TrimSpace(text) == "" {
+				continue
+			}
+			pattern, err := compileKey(text, source)
+			if err != nil {
+				s.result.Warnings = append(s.result.Warnings, fmt.Sprintf("entry %d (%s): key %q never matches: %v", index, title(source), text, err))
+				continue
+			}
+			*list.keys = append(*list.keys, key{text: text, pattern: pattern})
+		}
+	}
+	return e
+}
+
+// compileKey turns a key into a regular expression: "/pattern/flags" keys, and all keys of
+// entries that use regular expressions, as they are; other keys as literal text.
+func compileKey(text string, source models.LorebookEntry) (*regexp.Regexp, error) {
+	var pattern, flags string
+	switch {
+	case worldinfo.IsRegexKey(text):
+		end := strings.LastIndex(text, "/")
+		pattern = text[1:end]
+		for _, flag := range text[end+1:] {
+			if strings.ContainsRune("ims", flag) { // Go has no equivalent of the others
+				flags += string(flag)
+			}
+		}
+	case source.UseRegex:
+		pattern = text
+	default:
+		pattern = regexp.QuoteMeta(strings.TrimSpace(text))
+		if source.MatchWholeWords && !strings.Contains(pattern, " ") {
+			pattern = `(?:^|\W)` + pattern + `(?:$|\W)`
+		}
+	}
+	if !worldinfo.IsRegexKey(text) && !source.CaseSensitive {
+		flags = "i"
+	}
+	if flags != "" {
+		pattern = "(?" + flags + ")" + pattern
+	}
+	return regexp.Compile(pattern)
+}
+
+// recursionText is the text scanned at a recursion level: the chat and the content of every
+// entry triggered so far that allows recursion.
+func (s *simulation) recursionText() string {
+	parts := []string{s.chat}
+	for _, activated := range s.activated {
+		if source := s.book.Entries[activated.Index]; !source.PreventRecursion {
+			parts = append(parts, source.Content)
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+// scan triggers the undecided entries whose keys match text. At recursion levels, sources are
+// the entries triggered at the level before, whose content may have matched.
+func (s *simulation) scan(level int, text string, sources []models.ActivatedEntry) {
+	var passed []models.ActivatedEntry
+	for _, e := range s.entries {
+		if s.decided[e.index] || (level > 0 && e.source.ExcludeRecursion) {
+			continue
+		}
+		activated, ok := s.match(e, level, text)
+		if !ok {
+			continue
+		}
+		s.decided[e.index] = true
+		if level > 0 {
+			activated.TriggeredBy = s.triggeredBy(e, sources)
+		}
+		if s.passes(e, &activated) {
+			passed = append(passed, activated)
+		} else {
+			s.result.Skipped = append(s.result.Skipped, activated)
+		}
+	}
+	s.resolveGroups(passed)
+}
+
+// match checks an entry's keys against text.
+func (s *simulation) match(e *entry, level int, text string) (models.ActivatedEntry, bool) {
+	activated := models.ActivatedEntry{
+		Index:          e.index,
+		ID:             e.source.ID,
+		Title:          title(e.source),
+		InsertionOrder: e.source.InsertionOrder,
+		Tokens:         ai.EstimateTokens(e.source.Content),
+		RecursionLevel: level,
+	}
+	if e.source.Constant {
+		activated.Constant = true
+		activated.Reason = "constant entry"
+		return activated, true
+	}
+
+	activated.MatchedKeys = matching(e.keys, text)
+	if len(activated.MatchedKeys) == 0 {
+		return activated, false
+	}
+	where := "the chat"
+	if level > 0 {
+		where = "triggered entries"
+	}
+	activated.Reason = fmt.Sprintf("matched %s in %s", quoteAll(activated.MatchedKeys), where)
+	if len(e.secondary) == 0 {
+		return activated, true
+	}
+
+	activated.MatchedSecondaryKeys = matching(e.secondary, text)
+	matched, total := len(activated.MatchedSecondaryKeys), len(e.secondary)
+	logic := e.source.SelectiveLogic
+	var ok bool
+	switch logic {
+	case models.SelectiveLogicNotAll:
+		ok = matched < total
+	case models.SelectiveLogicNotAny:
+		ok = matched == 0
+	case models.SelectiveLogicAndAll:
+		ok = matched == total
+	default:
+		logic = models.SelectiveLogicAndAny
+		ok = matched > 0
+	}
+	activated.Reason += fmt.Sprintf(", and %d of %d secondary keys matched (%s)", matched, total, logic)
+	return activated, ok
+}
+
+// passes applies an entry's delay and probability to it once it has matched. Like SillyTavern,
+// it doesn't roll for entries whose useProbability extension is false.
+func (s *simulation) passes(e *entry, activated *models.ActivatedEntry) bool {
+	if e.source.Delay > s.messages {
+		activated.Reason += fmt.Sprintf("; delayed until the chat has %d messages", e.source.Delay)
+		return false
+	}
+	if use, ok := e.source.Extensions["useProbability"].(bool); ok && !use {
+		return true
+	}
+	if probability := e.source.Probability; probability > 0 && probability < 100 {
+		rng := rand.New(rand.NewPCG(uint64(s.settings.Seed), uint64(e.index)))
+		roll := rng.IntN(100) + 1
+		activated.Roll = &roll
+		if roll > probability {
+			activated.Reason += fmt.Sprintf("; lost its probability roll (rolled %d, needed %d or less)", roll, probability)
+			return false
+		}
+		activated.Reason += fmt.Sprintf("; won its probability roll (rolled %d, needed %d or less)", roll, probability)
+	}
+	return true
+}
+
+// resolveGroups triggers the entries that passed at one level. Of those in an inclusion group,
+// only one is kept, picked by group weight, unless an earlier level already picked one.
+func (s *simulation) resolveGroups(passed []models.ActivatedEntry) {
+	picked := map[string]bool{}
+	for _, activated := range passed {
+		group := s.book.Entries[activated.Index].Group
+		if group == "" {
+			s.activated = append(s.activated, activated)
+			continue
+		}
+		if !picked[group] {
+			if winner, ok := s.groups[group]; ok {
+				activated.Reason += fmt.Sprintf("; inclusion group %q already has entry %d", group, winner)
+				s.result.Skipped = append(s.result.Skipped, activated)
+				continue
+			}
+			s.groups[group] = s.pick(group, passed)
+			picked[group] = true
+		}
+		if winner := s.groups[group]; winner != activated.Index {
+			activated.Reason += fmt.Sprintf("; inclusion group %q went to entry %d", group, winner)
+			s.result.Skipped = append(s.result.Skipped, activated)
+			continue
+		}
+		activated.Reason += fmt.Sprintf("; picked from inclusion group %q", group)
+		s.activated = append(s.activated, activated)
+	}
+}
+
+// pick draws the entry kept from the members of group among passed, weighted by group weight.
+func (s *simulation) pick(group string, passed []models.ActivatedEntry) int {
+	var members []int
+	total := 0
+	for _, activated := range passed {
+		if source := s.book.Entries[activated.Index]; source.Group == group {
+			members = append(members, activated.Index)
+			total += groupWeight(source)
+		}
+	}
+	hash := fnv.New64a()
+	hash.Write([]byte(group))
+	rng := rand.New(rand.NewPCG(uint64(s.settings.Seed), hash.Sum64()))
+	draw := rng.IntN(total)
+	for _, index := range members {
+		if draw -= groupWeight(s.book.Entries[index]); draw < 0 {
+			return index
+		}
+	}
+	return members[len(members)-1]
+}
+
+// groupWeight is an entry's weight in its inclusion group. Weights below 1, which SillyTavern
+// doesn't allow, count as the default.
+func groupWeight(source models.LorebookEntry) int {
+	if source.GroupWeight < 1 {
+		return defaultGroupWeight
+	}
+	return source.GroupWeight
+}
+
+// applyBudget inserts the triggered entries by insertion order, highest first, until the next
+// one would go over the token budget; it and all after it are cut. This is how SillyTavern does
+// it: it doesn't read the card's priority, so neither does the budget here.
+func (s *simulation) applyBudget() {
+	byOrder := slices.Clone(s.activated)
+	slices.SortStableFunc(byOrder, func(a, b models.ActivatedEntry) int {
+		return cmp.Or(cmp.Compare(b.InsertionOrder, a.InsertionOrder), cmp.Compare(a.Index, b.Index))
+	})
+	over := false
+	for _, activated := range byOrder {
+		if budget := s.settings.TokenBudget; !over && budget > 0 && s.result.TokensUsed+activated.Tokens > budget {
+			over = true
+		}
+		if over {
+			activated.Reason += fmt.Sprintf("; cut: over the token budget of %d with %d tokens already inserted", s.settings.TokenBudget, s.result.TokensUsed)
+			s.result.CutByBudget = append(s.result.CutByBudget, activated)
+			continue
+		}
+		s.result.TokensUsed += activated.Tokens
+		s.result.Triggered = append(s.result.Triggered, activated)
+	}
+	slices.SortStableFunc(s.result.Triggered, func(a, b models.ActivatedEntry) int {
+		return cmp.Or(cmp.Compare(a.InsertionOrder, b.InsertionOrder), cmp.Compare(a.Index, b.Index))
+	})
+}
+
+// triggeredBy returns the sources whose content matches one of the entry's keys.
+func (s *simulation) triggeredBy(e *entry, sources []models.ActivatedEntry) []int {
+	var found []int
+	for _, source := range sources {
+		if len(matching(e.keys, s.book.Entries[source.Index].Content)) > 0 {
+			found = append(found, source.Index)
+		}
+	}
+	return found
+}
+
+// matching returns the text of the keys that match text.
+func matching(keys []key, text string) []string {
+	var found []string
+	for _, k := range keys {
+		if k.pattern.MatchString(text) {
+			found = append(found, k.text)
+		}
+	}
+	return found
+}
+
+// title names an entry in results and warnings.
+func title(source models.LorebookEntry) string {
+	switch {
+	case source.Name != "":
+		return source.Name
+	case source.Comment != "":
+		return source.Comment
+	case len(source.Keys) > 0:
+		return source.Keys[0]
+	}
+	return "untitled"
+}
+
+func quoteAll(texts []string) string {
+	quoted := make([]string, len(texts))
+	for i, text := range texts {
+		quoted[i] = fmt.Sprintf("%q", text)
+	}
+	return strings.Join(quoted, ", ")
+}
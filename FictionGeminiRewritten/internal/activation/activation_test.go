@@ -0,0 +1,163 @@
+package activation
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"workspace/FictionGeminiRewritten/internal/models"
+)
+
+// indexes lists the entries of a result, by index.
+func indexes(entries []models.ActivatedEntry) []int {
+	found := []int{}
+	for _, entry := range entries {
+		found = append(found, entry.Index)
+	}
+	return found
+}
+
+var chat = []string{
+	"Jessica: The Sietch is far from here.",
+	"Paul: Where do the FREMEN keep their water?",
+	"Stilgar: Spice and water are life on Arrakis.",
+}
+
+func TestSimulateMatching(t *testing.T) {
+	book := models.Lorebook{Entries: []models.LorebookEntry{
+		{Keys: []string{"spice"}, Enabled: true},
+		{Keys: []string{"Fremen"}, Enabled: true, CaseSensitive: true},
+		{Keys: []string{"fremen"}, Enabled: true},
+		{Keys: []string{"/ar+akis/i"}, Enabled: true},
+		{Keys: []string{"sietch"}, Enabled: true},                                      // Beyond the scan depth
+		{Keys: []string{"water"}, SecondaryKeys: []string{"Harkonnen"}, Enabled: true}, // AND_ANY fails
+		{Keys: []string{"water"}, SecondaryKeys: []string{"Harkonnen"}, SelectiveLogic: models.SelectiveLogicNotAny, Enabled: true},
+		{Keys: []string{"water"}, SecondaryKeys: []string{"spice", "life"}, SelectiveLogic: models.SelectiveLogicAndAll, Enabled: true},
+		{Keys: []string{"pi"}, Enabled: true, MatchWholeWords: true},
+		{Keys: []string{"^Stil"}, Enabled: true, UseRegex: true},
+		{Content: "Always.", Constant: true, Enabled: true},
+		{Keys: []string{"spice"}, Enabled: false},
+		{Keys: []string{"/(?<=x)y/"}, Enabled: true},
+	}}
+	result := Simulate(book, chat, Settings{})
+
+	want := []int{0, 2, 3, 6, 7, 10}
+	if got := indexes(result.Triggered); !slices.Equal(got, want) {
+		t.Errorf("triggered = %v, want %v\n%+v", got, want, result)
+	}
+	if result.ScanDepth != DefaultScanDepth {
+		t.Errorf("scan depth = %d, want %d", result.ScanDepth, DefaultScanDepth)
+	}
+	if len(result.Warnings) != 1 || !strings.Contains(result.Warnings[0], "entry 12") {
+		t.Errorf("warnings = %q, want one for entry 12's key", result.Warnings)
+	}
+	if reason := result.Triggered[4].Reason; reason != `matched "water" in the chat, and 2 of 2 secondary keys matched (AND_ALL)` {
+		t.Errorf("reason = %q", reason)
+	}
+
+	// ^Stil only matches at the start of the scanned text, which a wider scan moves.
+	if got := indexes(Simulate(book, chat[1:], Settings{ScanDepth: 1}).Triggered); !slices.Contains(got, 9) {
+		t.Errorf("triggered with scan depth 1 = %v, want entry 9", got)
+	}
+}
+
+func TestSimulateRecursion(t *testing.T) {
+	book := models.Lorebook{RecursiveScanning: true, Entries: []models.LorebookEntry{
+		{Keys: []string{"Paul"}, Content: "Son of Leto and Jessica.", Enabled: true},
+		{Keys: []string{"Leto"}, Content: "Duke of House Atreides.", Enabled: true},
+		{Keys: []string{"Atreides"}, Content: "A great house.", Enabled: true, PreventRecursion: true},
+		{Keys: []string{"great house"}, Content: "One of many.", Enabled: true},
+		{Keys: []string{"Jessica"}, Content: "Bene Gesserit.", Enabled: true, ExcludeRecursion: true},
+	}}
+	result := Simulate(book, []string{"Who is Paul?"}, Settings{})
+
+	if got := indexes(result.Triggered); !slices.Equal(got, []int{0, 1, 2}) {
+		t.Fatalf("triggered = %v, want 0, 1 and 2\n%+v", got, result)
+	}
+	duke := result.Triggered[1]
+	if duke.RecursionLevel != 1 || !slices.Equal(duke.TriggeredBy, []int{0}) {
+		t.Errorf("Leto entry = %+v, want level 1, triggered by entry 0", duke)
+	}
+	if house := result.Triggered[2]; house.RecursionLevel != 2 {
+		t.Errorf("Atreides entry = %+v, want level 2", house)
+	}
+}
+
+func TestSimulateProbabilityGroupsAndBudget(t *testing.T) {
+	long := strings.Repeat("sand ", 40) // 50 tokens
+	book := models.Lorebook{TokenBudget: 120, Entries: []models.LorebookEntry{
+		{Keys: []string{"spice"}, Content: long, Enabled: true, InsertionOrder: 3, Priority: 10},
+		{Keys: []string{"spice"}, Content: long, Enabled: true, InsertionOrder: 1, Priority: 90},
+		{Keys: []string{"spice"}, Content: long, Enabled: true, InsertionOrder: 2, Priority: 50},
+		{Keys: []string{"spice"}, Content: "Maybe.", Enabled: true, Probability: 50},
+		{Keys: []string{"spice"}, Content: "Rumour A.", Enabled: true, Group: "rumours", GroupWeight: 1},
+		{Keys: []string{"spice"}, Content: "Rumour B.", Enabled: true, Group: "rumours", GroupWeight: 1000},
+		{Keys: []string{"spice"}, Content: "Later.", Enabled: true, Delay: 5},
+	}}
+	result := Simulate(book, []string{"spice"}, Settings{Seed: 7})
+
+	// Entry 1 has the highest priority but the lowest insertion order, so it is the one cut.
+	if got := indexes(result.CutByBudget); !slices.Contains(got, 1) || slices.Contains(got, 0) || slices.Contains(got, 2) {
+		t.Errorf("cut by budget = %v, want entry 1 but not 0 or 2\n%+v", got, result)
+	}
+	triggered := indexes(result.Triggered)
+	if i, j := slices.Index(triggered, 2), slices.Index(triggered, 0); i < 0 || j < 0 || i > j {
+		t.Errorf("triggered = %v, want entry 2 before entry 0", triggered)
+	}
+	skipped := indexes(result.Skipped)
+	if !slices.Contains(skipped, 6) || !slices.Contains(skipped, 4) {
+		t.Errorf("skipped = %v, want the delayed entry and the light rumour", skipped)
+	}
+	if result.TokensUsed > book.TokenBudget {
+		t.Errorf("tokens used = %d, over the budget of %d", result.TokensUsed, book.TokenBudget)
+	}
+
+	again := Simulate(book, []string{"spice"}, Settings{Seed: 7})
+	if !reflect.DeepEqual(again, result) {
+		t.Error("the same seed gave a different result")
+	}
+}
+
+func TestSimulateSkipsRollWithoutUseProbability(t *testing.T) {
+	book := models.Lorebook{Entries: []models.LorebookEntry{
+		{Keys: []string{"spice"}, Content: "Never rolled.", Enabled: true, Probability: 1, Extensions: models.Extensions{"useProbability": false}},
+		{Keys: []string{"spice"}, Content: "Rolled.", Enabled: true, Probability: 1, Extensions: models.Extensions{"useProbability": true}},
+	}}
+	result := Simulate(book, []string{"spice"}, Settings{Seed: 7})
+	if len(result.Triggered) != 1 || result.Triggered[0].Index != 0 || result.Triggered[0].Roll != nil {
+		t.Errorf("triggered = %v, want entry 0 without a roll\n%+v", indexes(result.Triggered), result)
+	}
+	if len(result.Skipped) != 1 || result.Skipped[0].Index != 1 || result.Skipped[0].Roll == nil {
+		t.Errorf("skipped = %v, want entry 1 after a lost roll\n%+v", indexes(result.Skipped), result)
+	}
+}
+
+func TestSimulateNegativeSettings(t *testing.T) {
+	book := models.Lorebook{ScanDepth: -1, TokenBudget: -5, Entries: []models.LorebookEntry{
+		{Keys: []string{"spice"}, Content: "Melange.", Enabled: true},
+	}}
+	result := Simulate(book, []string{"spice"}, Settings{})
+
+	if result.ScanDepth != DefaultScanDepth || result.TokenBudget != 0 {
+		t.Errorf("scan depth = %d, token budget = %d, want %d and 0", result.ScanDepth, result.TokenBudget, DefaultScanDepth)
+	}
+	if got := indexes(result.Triggered); !slices.Equal(got, []int{0}) {
+		t.Errorf("triggered = %v, want entry 0", got)
+	}
+	if len(result.Warnings) != 1 {
+		t.Errorf("warnings = %q, want one for the negative settings", result.Warnings)
+	}
+}
+
+func TestSimulateNonPositiveGroupWeights(t *testing.T) {
+	book := models.Lorebook{Entries: []models.LorebookEntry{
+		{Keys: []string{"spice"}, Enabled: true, Group: "rumours", GroupWeight: -5},
+		{Keys: []string{"spice"}, Enabled: true, Group: "rumours", GroupWeight: 0},
+	}}
+	result := Simulate(book, []string{"spice"}, Settings{Seed: 3})
+
+	if len(result.Triggered) != 1 || len(result.Skipped) != 1 {
+		t.Errorf("triggered = %v, skipped = %v, want one of each", indexes(result.Triggered), indexes(result.Skipped))
+	}
+}
@@ -0,0 +1,146 @@
+package ai
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+	"google.golang.org/api/option"
+)
+
+const (
+	// defaultMaxGeminiClients bounds how many API keys have a live client at once.
+	defaultMaxGeminiClients = 64
+	// defaultGeminiClientIdleTimeout is how long an unused client (and the key inside it) is kept.
+	defaultGeminiClientIdleTimeout = 5 * time.Minute
+)
+
+// geminiClients is shared by every GeminiProvider, so consecutive calls for the same user reuse
+// one client and its connections.
+var geminiClients = newClientCache(defaultMaxGeminiClients, defaultGeminiClientIdleTimeout)
+
+// clientCache keeps one genai.Client per API key. Entries are keyed by a hash of the key,
+// closed once idle for longer than idleTimeout, and the least recently used idle entry is
+// evicted when more than maxSize keys are cached. Clients in use are never closed. It is safe
+// for concurrent use.
+type clientCache struct {
+	maxSize     int
+	idleTimeout time.Duration
+
+	mu          sync.Mutex
+	entries     map[string]*cachedClient
+	janitorOnce sync.Once
+}
+
+type cachedClient struct {
+	client   *genai.Client
+	inUse    int
+	lastUsed time.Time
+}
+
+func newClientCache(maxSize int, idleTimeout time.Duration) *clientCache {
+	return &clientCache{
+		maxSize:     maxSize,
+		idleTimeout: idleTimeout,
+		entries:     make(map[string]*cachedClient),
+	}
+}
+
+// acquire returns the client for apiKey, creating it if needed. The caller must call release
+// when the call using it has finished.
+func (c *clientCache) acquire(apiKey string) (client *genai.Client, release func(), err error) {
+	c.janitorOnce.Do(func() { go c.janitor() })
+	id := keyID(apiKey)
+
+	c.mu.Lock()
+	entry, ok := c.entries[id]
+	if !ok {
+		c.mu.Unlock()
+		// Created outside the lock, and not bound to any request's context, since it outlives the request.
+		created, err := genai.NewClient(context.Background(), option.WithAPIKey(apiKey))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create Gemini client: %w", err)
+		}
+		c.mu.Lock()
+		if entry, ok = c.entries[id]; ok {
+			// Another call created one concurrently; keep theirs.
+			closeClient(created)
+		} else {
+			entry = &cachedClient{client: created}
+			c.entries[id] = entry
+		}
+	}
+	entry.inUse++
+	entry.lastUsed = time.Now()
+	c.evictOverflowLocked()
+	c.mu.Unlock()
+
+	return entry.client, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		entry.inUse--
+		entry.lastUsed = time.Now()
+		c.evictOverflowLocked()
+	}, nil
+}
+
+// evictOverflowLocked closes least recently used idle clients while the cache is over its size
+// bound. If every client is busy the cache stays over the bound until one is released.
+func (c *clientCache) evictOverflowLocked() {
+	for len(c.entries) > c.maxSize {
+		var oldestID string
+		var oldest *cachedClient
+		for id, entry := range c.entries {
+			if entry.inUse == 0 && (oldest == nil || entry.lastUsed.Before(oldest.lastUsed)) {
+				oldestID, oldest = id, entry
+			}
+		}
+		if oldest == nil {
+			return
+		}
+		delete(c.entries, oldestID)
+		closeClient(oldest.client)
+	}
+}
+
+// evictIdle closes every client unused for longer than the idle timeout.
+func (c *clientCache) evictIdle() {
+	cutoff := time.Now().Add(-c.idleTimeout)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for id, entry := range c.entries {
+		if entry.inUse == 0 && entry.lastUsed.Before(cutoff) {
+			delete(c.entries, id)
+			closeClient(entry.client)
+		}
+	}
+}
+
+func (c *clientCache) janitor() {
+	interval := c.idleTimeout / 2
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		c.evictIdle()
+	}
+}
+
+// keyID identifies an API key in the cache without keeping the key itself as a map key.
+func keyID(apiKey string) string {
+	sum := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(sum[:])
+}
+
+func closeClient(client *genai.Client) {
+	if err := client.Close(); err != nil {
+		log.Printf("Error closing cached Gemini client: %v", err)
+	}
+}
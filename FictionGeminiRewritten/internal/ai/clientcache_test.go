@@ -0,0 +1,72 @@
+package ai
+
+import (
+	"testing"
+	"time"
+)
+
+// cached reports whether apiKey has a client in c, and how many clients c holds.
+func cached(c *clientCache, apiKey string) (bool, int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.entries[keyID(apiKey)]
+	return ok, len(c.entries)
+}
+
+func TestClientCacheReusesClients(t *testing.T) {
+	cache := newClientCache(4, time.Minute)
+	first, release, err := cache.acquire("key-a")
+	if err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	second, releaseAgain, _ := cache.acquire("key-a")
+	other, releaseOther, _ := cache.acquire("key-b")
+	if first != second || first == other {
+		t.Error("want one client per key")
+	}
+	release()
+	releaseAgain()
+	releaseOther()
+	if ok, n := cached(cache, "key-a"); !ok || n != 2 {
+		t.Errorf("cached %d clients, want key-a and key-b kept once released", n)
+	}
+}
+
+func TestClientCacheEvictsLeastRecentlyUsedIdleClient(t *testing.T) {
+	cache := newClientCache(2, time.Minute)
+	_, releaseA, _ := cache.acquire("key-a")
+	_, releaseB, _ := cache.acquire("key-b")
+	releaseA()
+	_, releaseC, _ := cache.acquire("key-c")
+	if ok, n := cached(cache, "key-a"); ok || n != 2 {
+		t.Errorf("cached %d clients, want key-a evicted", n)
+	}
+
+	// Clients in use are never closed, so the cache stays over its bound until one is released.
+	_, releaseD, _ := cache.acquire("key-d")
+	if _, n := cached(cache, "key-d"); n != 3 {
+		t.Errorf("cached %d clients while all are busy, want 3", n)
+	}
+	releaseB()
+	if ok, n := cached(cache, "key-b"); ok || n != 2 {
+		t.Errorf("cached %d clients after a release, want key-b evicted", n)
+	}
+	releaseC()
+	releaseD()
+}
+
+func TestClientCacheEvictsIdleClients(t *testing.T) {
+	cache := newClientCache(4, 0)
+	_, releaseA, _ := cache.acquire("key-a")
+	_, releaseB, _ := cache.acquire("key-b")
+	releaseA()
+	time.Sleep(time.Millisecond)
+	cache.evictIdle()
+	if ok, _ := cached(cache, "key-a"); ok {
+		t.Error("idle client was kept")
+	}
+	if ok, _ := cached(cache, "key-b"); !ok {
+		t.Error("busy client was closed")
+	}
+	releaseB()
+}
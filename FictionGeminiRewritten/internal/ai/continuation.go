@@ -0,0 +1,35 @@
+package ai
+
+import (
+	"strings"
+)
+
+// minStitchOverlap is the shortest repeated text treated as the model restating the end of
+// the previous fragment rather than a coincidence.
+const minStitchOverlap = 12
+
+// StitchContinuation appends a continuation fragment to the output produced so far. Models
+// often wrap the fragment in a code fence or restate the last few characters before carrying
+// on, so fences are dropped and any overlap between the end of prev and the start of next is
+// written only once.
+func StitchContinuation(prev, next string) string {
+	if fenced := strings.TrimLeft(next, " \t\r\n"); strings.HasPrefix(fenced, "```") {
+		// Drop the whole fence line, including its language tag and line break.
+		if _, rest, found := strings.Cut(fenced, "\n"); found {
+			next = rest
+		}
+		next = strings.TrimSuffix(strings.TrimRight(next, " \t\r\n"), "```")
+		next = strings.TrimRight(next, "\r\n")
+	}
+
+	maxOverlap := len(next)
+	if len(prev) < maxOverlap {
+		maxOverlap = len(prev)
+	}
+	for n := maxOverlap; n >= minStitchOverlap; n-- {
+		if strings.HasSuffix(prev, next[:n]) {
+			return prev + next[n:]
+		}
+	}
+	return prev + next
+}
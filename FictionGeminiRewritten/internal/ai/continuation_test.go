@@ -0,0 +1,23 @@
+package ai
+
+import "testing"
+
+func TestStitchContinuation(t *testing.T) {
+	tests := []struct {
+		prev, next, want string
+	}{
+		{`{"name": "Paul", "desc`, `ription": "Heir."}`, `{"name": "Paul", "description": "Heir."}`},
+		// The model restated the end of the previous fragment.
+		{`{"name": "Paul", "description": "Heir of`, `"description": "Heir of Atreides."}`, `{"name": "Paul", "description": "Heir of Atreides."}`},
+		// Overlaps shorter than minStitchOverlap are taken as coincidence.
+		{`{"a": "xy`, `"xyz"}`, `{"a": "xy"xyz"}`},
+		{`{"a": "b`, "```json\n" + `c"}` + "\n```\n", `{"a": "bc"}`},
+		{`{"a": "b`, "  ```\nc\"}```", `{"a": "bc"}`},
+		{`{"a": "b`, "", `{"a": "b`},
+	}
+	for _, tt := range tests {
+		if got := StitchContinuation(tt.prev, tt.next); got != tt.want {
+			t.Errorf("StitchContinuation(%q, %q) = %q, want %q", tt.prev, tt.next, got, tt.want)
+		}
+	}
+}
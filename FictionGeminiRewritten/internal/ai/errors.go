@@ -0,0 +1,133 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"workspace/FictionGeminiRewritten/internal/models"
+)
+
+// ErrorKind classifies a failed model call.
+type ErrorKind string
+
+const (
+	ErrRateLimited    ErrorKind = "rate_limited"    // Too many requests; retry after a short wait
+	ErrQuotaExhausted ErrorKind = "quota_exhausted" // Billing or daily quota used up; retrying won't help soon
+	ErrSafetyBlocked  ErrorKind = "safety_blocked"  // The prompt or the response was blocked by safety filters
+	ErrRecitation     ErrorKind = "recitation"      // The output was stopped for reciting existing text
+	ErrMaxTokens      ErrorKind = "max_tokens"      // The output limit was reached before any usable content
+	ErrEmptyResponse  ErrorKind = "empty_response"  // The model finished without any content, for another reason
+	ErrInvalidAPIKey  ErrorKind = "invalid_api_key" // The key is missing, malformed or not authorized
+	ErrModelNotFound  ErrorKind = "model_not_found" // The backend doesn't know the requested model
+	ErrTransient      ErrorKind = "transient"       // Network failures and 5xx responses
+)
+
+// Error is a classified model call failure. Providers return it for every failure they can
+// identify; anything else is returned unwrapped.
+type Error struct {
+	Kind       ErrorKind
+	Provider   string
+	Model      string
+	Message    string                   // Human-readable description, naming the model
+	RetryAfter time.Duration            // Server-suggested wait before retrying, if any
+	Safety     *models.SafetyDiagnostic // What a safety block was about, for ErrSafetyBlocked
+	Err        error                    // Underlying error, if any
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("%s: %s", e.Kind, e.Message)
+	if categories := blockedCategories(e.Safety); len(categories) > 0 {
+		msg += fmt.Sprintf(" [%s blocked for: %s]", e.Safety.Source, strings.Join(categories, ", "))
+	}
+	return msg
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// Retryable reports whether the same request may succeed if sent again.
+func (e *Error) Retryable() bool {
+	return e.Kind == ErrRateLimited || e.Kind == ErrTransient
+}
+
+// SafetyDiagnosticOf returns the safety diagnostic carried by err, or nil if err isn't a safety block.
+func SafetyDiagnosticOf(err error) *models.SafetyDiagnostic {
+	var aiErr *Error
+	if errors.As(err, &aiErr) {
+		return aiErr.Safety
+	}
+	return nil
+}
+
+// KindOf returns the kind of the first *Error in err's chain, or "" if it has none.
+func KindOf(err error) ErrorKind {
+	var aiErr *Error
+	if errors.As(err, &aiErr) {
+		return aiErr.Kind
+	}
+	return ""
+}
+
+// classifyHTTPStatus maps an HTTP status from a model API to an ErrorKind. Quota exhaustion
+// is told apart from ordinary rate limiting by the caller, which can see the response body.
+func classifyHTTPStatus(code int) (ErrorKind, bool) {
+	switch {
+	case code == http.StatusTooManyRequests:
+		return ErrRateLimited, true
+	case code == http.StatusUnauthorized || code == http.StatusForbidden:
+		return ErrInvalidAPIKey, true
+	case code == http.StatusNotFound:
+		return ErrModelNotFound, true
+	case code == http.StatusRequestTimeout || code >= 500:
+		return ErrTransient, true
+	}
+	return "", false
+}
+
+// parseRetryAfter reads a Retry-After header, given either in seconds or as an HTTP date.
+func parseRetryAfter(value string) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if d := time.Until(at); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// isNetworkError reports whether err is a connection-level failure worth retrying: a timeout,
+// a refused, reset or dropped connection, or a response cut off mid-way. The caller's context
+// being cancelled, and failures that would happen again (bad URLs, certificates, responses
+// that can't be decoded), are not.
+func isNetworkError(ctx context.Context, err error) bool {
+	if ctx.Err() != nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNABORTED) || errors.Is(err, syscall.EPIPE) || errors.Is(err, net.ErrClosed) {
+		return true
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTimeout || dnsErr.IsTemporary
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr)
+}
@@ -0,0 +1,89 @@
+package ai
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestClassifyHTTPStatus(t *testing.T) {
+	for code, want := range map[int]ErrorKind{
+		http.StatusTooManyRequests:     ErrRateLimited,
+		http.StatusUnauthorized:        ErrInvalidAPIKey,
+		http.StatusForbidden:           ErrInvalidAPIKey,
+		http.StatusNotFound:            ErrModelNotFound,
+		http.StatusRequestTimeout:      ErrTransient,
+		http.StatusInternalServerError: ErrTransient,
+		http.StatusServiceUnavailable:  ErrTransient,
+		http.StatusBadRequest:          "",
+		http.StatusOK:                  "",
+	} {
+		if kind, ok := classifyHTTPStatus(code); kind != want || ok != (want != "") {
+			t.Errorf("classifyHTTPStatus(%d) = %q, %v; want %q", code, kind, ok, want)
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	for value, want := range map[string]time.Duration{
+		"":                              0,
+		" 7 ":                           7 * time.Second,
+		"0":                             0,
+		"-3":                            0,
+		"soon":                          0,
+		"Mon, 01 Jan 2001 00:00:00 GMT": 0, // In the past
+	} {
+		if got := parseRetryAfter(value); got != want {
+			t.Errorf("parseRetryAfter(%q) = %v, want %v", value, got, want)
+		}
+	}
+	if got := parseRetryAfter(time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)); got < 59*time.Minute || got > time.Hour {
+		t.Errorf("parseRetryAfter(an hour from now) = %v", got)
+	}
+}
+
+func TestIsNetworkError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{io.ErrUnexpectedEOF, true},
+		{fmt.Errorf("read body: %w", syscall.ECONNRESET), true},
+		{&net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}, true},
+		{&net.DNSError{Err: "timeout", IsTimeout: true}, true},
+		{&net.DNSError{Err: "no such host", IsNotFound: true}, false},
+		{os.ErrDeadlineExceeded, true},
+		{errors.New("invalid character '<' looking for beginning of value"), false},
+		{x509.UnknownAuthorityError{}, false},
+		{context.Canceled, false},
+	}
+	for _, tt := range tests {
+		if got := isNetworkError(context.Background(), tt.err); got != tt.want {
+			t.Errorf("isNetworkError(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if isNetworkError(ctx, io.ErrUnexpectedEOF) {
+		t.Error("a failure after the caller cancelled should not be retried")
+	}
+}
+
+func TestKindOf(t *testing.T) {
+	err := fmt.Errorf("step failed: %w", &Error{Kind: ErrQuotaExhausted})
+	if KindOf(err) != ErrQuotaExhausted || KindOf(errors.New("plain")) != "" {
+		t.Errorf("KindOf = %q, %q", KindOf(err), KindOf(errors.New("plain")))
+	}
+	if (&Error{Kind: ErrQuotaExhausted}).Retryable() || !(&Error{Kind: ErrRateLimited}).Retryable() {
+		t.Error("only rate limits and transient failures are retryable")
+	}
+}
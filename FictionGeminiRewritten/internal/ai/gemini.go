@@ -2,58 +2,281 @@ package ai
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"strings"
 
 	"github.com/google/generative-ai-go/genai"
-	"google.golang.org/api/option"
+	"github.com/googleapis/gax-go/v2/apierror"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
 )
 
-// CallGeminiAPI sends a prompt to the Gemini API using the provided API key and model name.
-// It creates a new client for each call to ensure the correct API key is used.
-func CallGeminiAPI(ctx context.Context, apiKey string, modelName string, prompt string) (string, error) {
-	if apiKey == "" {
-		return "", fmt.Errorf("API key is required for CallGeminiAPI")
+// GeminiProvider talks to Google's Gemini API.
+type GeminiProvider struct {
+	apiKey string
+}
+
+// NewGeminiProvider creates a GeminiProvider that authenticates with the given API key.
+func NewGeminiProvider(apiKey string) *GeminiProvider {
+	return &GeminiProvider{apiKey: apiKey}
+}
+
+// Name implements Provider.
+func (p *GeminiProvider) Name() string { return "gemini" }
+
+// GenerateText implements Provider.
+func (p *GeminiProvider) GenerateText(ctx context.Context, req Request) (*Response, error) {
+	return p.generate(ctx, req, false)
+}
+
+// GenerateJSON implements Provider.
+func (p *GeminiProvider) GenerateJSON(ctx context.Context, req Request) (*Response, error) {
+	return p.generate(ctx, req, true)
+}
+
+// CountTokens implements Provider using Gemini's countTokens API.
+func (p *GeminiProvider) CountTokens(ctx context.Context, modelName string, prompt string) (int, error) {
+	client, release, err := p.client(modelName)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+
+	resp, err := client.GenerativeModel(modelName).CountTokens(ctx, genai.Text(prompt))
+	if err != nil {
+		return 0, classifyGeminiError(ctx, err, modelName, "failed to count tokens")
+	}
+	return int(resp.TotalTokens), nil
+}
+
+// client returns a client for the provider's API key from the shared cache, along with the
+// function that hands it back once the call is done.
+func (p *GeminiProvider) client(modelName string) (*genai.Client, func(), error) {
+	if p.apiKey == "" {
+		return nil, nil, fmt.Errorf("API key is required for the Gemini provider")
 	}
 	if modelName == "" {
-		return "", fmt.Errorf("model name is required for CallGeminiAPI")
+		return nil, nil, fmt.Errorf("model name is required for the Gemini provider")
 	}
+	return geminiClients.acquire(p.apiKey)
+}
 
-	client, err := genai.NewClient(ctx, option.WithAPIKey(apiKey))
+func (p *GeminiProvider) generate(ctx context.Context, req Request, jsonOutput bool) (*Response, error) {
+	modelName := req.Model
+	client, release, err := p.client(modelName)
 	if err != nil {
-		return "", fmt.Errorf("failed to create Gemini client: %w", err)
+		return nil, err
 	}
-	defer func() {
-		if err := client.Close(); err != nil {
-			log.Printf("Error closing temporary Gemini client: %v", err)
-		}
-	}()
+	defer release()
 
 	model := client.GenerativeModel(modelName)
-	// model.GenerationConfig.ResponseMIMEType = "application/json" // If needed globally
+	cfg := req.Config
+	model.GenerationConfig.Temperature = cfg.Temperature
+	model.GenerationConfig.TopP = cfg.TopP
+	model.GenerationConfig.TopK = cfg.TopK
+	model.GenerationConfig.MaxOutputTokens = cfg.MaxOutputTokens
+	model.GenerationConfig.StopSequences = cfg.StopSequences
+	model.GenerationConfig.CandidateCount = cfg.CandidateCount
+	// The Gemini SDK in use has no seed parameter, so cfg.Seed is not sent.
+	model.SafetySettings = geminiSafetySettings(req.Safety)
+	if jsonOutput {
+		model.GenerationConfig.ResponseMIMEType = "application/json"
+		if req.Schema != nil {
+			model.GenerationConfig.ResponseSchema = toGenaiSchema(req.Schema)
+		}
+	}
 
-	resp, err := model.GenerateContent(ctx, genai.Text(prompt))
+	var resp *genai.GenerateContentResponse
+	if req.OnToken != nil {
+		resp, err = streamContent(ctx, model, req)
+	} else {
+		resp, err = model.GenerateContent(ctx, genai.Text(req.Prompt))
+	}
 	if err != nil {
-		return "", fmt.Errorf("failed to generate content using model %s: %w", modelName, err)
+		return nil, classifyGeminiError(ctx, err, modelName, "failed to generate content")
 	}
 
 	if resp == nil || len(resp.Candidates) == 0 || resp.Candidates[0].Content == nil || len(resp.Candidates[0].Content.Parts) == 0 {
-		// Check for safety ratings / finish reason if content is empty
-		if resp != nil && len(resp.Candidates) > 0 && resp.Candidates[0].FinishReason != genai.FinishReasonStop {
-			return "", fmt.Errorf("AI content generation stopped due to %s. Safety ratings: %v", resp.Candidates[0].FinishReason, resp.Candidates[0].SafetyRatings)
+		return nil, emptyResponseError(resp, modelName)
+	}
+
+	var text strings.Builder
+	for _, part := range resp.Candidates[0].Content.Parts {
+		responseText, ok := part.(genai.Text)
+		if !ok {
+			return nil, fmt.Errorf("unexpected response part type: %T for model %s", part, modelName)
 		}
-		// It's possible to get an empty Parts array with FinishReasonStop if the prompt itself was empty or invalid.
-		finishReason := genai.FinishReasonUnspecified
-		if resp != nil && len(resp.Candidates) > 0 {
-			finishReason = resp.Candidates[0].FinishReason
+		text.WriteString(string(responseText))
+	}
+	return &Response{
+		Text:      text.String(),
+		Truncated: resp.Candidates[0].FinishReason == genai.FinishReasonMaxTokens,
+		Usage:     geminiUsage(resp.UsageMetadata),
+	}, nil
+}
+
+// emptyResponseError explains a response without content by its finish reason.
+func emptyResponseError(resp *genai.GenerateContentResponse, modelName string) *Error {
+	// An empty response that finished normally usually means the prompt was empty or invalid.
+	finishReason := genai.FinishReasonUnspecified
+	var cand *genai.Candidate
+	if resp != nil && len(resp.Candidates) > 0 {
+		cand = resp.Candidates[0]
+		finishReason = cand.FinishReason
+	}
+	aiErr := &Error{
+		Kind:     ErrEmptyResponse,
+		Provider: "gemini",
+		Model:    modelName,
+		Message:  fmt.Sprintf("no content received from model %s (finish reason: %s)", modelName, finishReason),
+	}
+	switch finishReason {
+	case genai.FinishReasonMaxTokens:
+		aiErr.Kind = ErrMaxTokens
+		aiErr.Message = fmt.Sprintf("model %s reached its output token limit before producing any content", modelName)
+	case genai.FinishReasonSafety:
+		aiErr.Kind = ErrSafetyBlocked
+		aiErr.Message = fmt.Sprintf("AI content generation for model %s stopped due to %s", modelName, finishReason)
+		aiErr.Safety = candidateSafetyDiagnostic(cand)
+	case genai.FinishReasonRecitation:
+		aiErr.Kind = ErrRecitation
+		aiErr.Message = fmt.Sprintf("model %s stopped for reciting existing text too closely; retrying, or a higher temperature, usually helps", modelName)
+	}
+	return aiErr
+}
+
+// streamContent uses GenerateContentStream, passing each text chunk of the first candidate to
+// req.OnToken as it arrives, and returns the merged response.
+func streamContent(ctx context.Context, model *genai.GenerativeModel, req Request) (*genai.GenerateContentResponse, error) {
+	iter := model.GenerateContentStream(ctx, genai.Text(req.Prompt))
+	var usage *genai.UsageMetadata
+	for {
+		chunk, err := iter.Next()
+		if err == iterator.Done {
+			merged := iter.MergedResponse()
+			if merged != nil && usage != nil {
+				// The merged response keeps the first chunk's usage; the last chunk has the final counts.
+				merged.UsageMetadata = usage
+			}
+			return merged, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if chunk.UsageMetadata != nil {
+			usage = chunk.UsageMetadata
+		}
+		for _, cand := range chunk.Candidates {
+			if cand.Index != 0 || cand.Content == nil {
+				continue // Only the first candidate is used
+			}
+			for _, part := range cand.Content.Parts {
+				if t, ok := part.(genai.Text); ok {
+					req.OnToken(string(t))
+				}
+			}
 		}
-		return "", fmt.Errorf("no content received from AI for model %s: empty response or parts. FinishReason: %s", modelName, finishReason)
 	}
-	
-	responsePart := resp.Candidates[0].Content.Parts[0]
-	if responseText, ok := responsePart.(genai.Text); ok {
-		return string(responseText), nil
+}
+
+// geminiUsage converts Gemini's usage metadata.
+func geminiUsage(meta *genai.UsageMetadata) Usage {
+	if meta == nil {
+		return Usage{}
 	}
+	return Usage{
+		PromptTokens:    int(meta.PromptTokenCount),
+		CandidateTokens: int(meta.CandidatesTokenCount),
+		TotalTokens:     int(meta.TotalTokenCount),
+	}
+}
 
-	return "", fmt.Errorf("unexpected response part type: %T for model %s", responsePart, modelName)
+// classifyGeminiError turns an error from the genai client into an *Error when its cause can be
+// identified, and otherwise wraps it with action for context.
+func classifyGeminiError(ctx context.Context, err error, modelName, action string) error {
+	var blocked *genai.BlockedError
+	if errors.As(err, &blocked) {
+		aiErr := &Error{Kind: ErrSafetyBlocked, Provider: "gemini", Model: modelName, Message: fmt.Sprintf("model %s %v", modelName, blocked), Err: err}
+		if blocked.PromptFeedback != nil && blocked.PromptFeedback.BlockReason != genai.BlockReasonUnspecified {
+			aiErr.Safety = promptSafetyDiagnostic(blocked.PromptFeedback)
+		} else {
+			aiErr.Safety = candidateSafetyDiagnostic(blocked.Candidate)
+		}
+		return aiErr
+	}
+
+	apiErr, ok := apierror.FromError(err)
+	if !ok {
+		if isNetworkError(ctx, err) {
+			return &Error{Kind: ErrTransient, Provider: "gemini", Model: modelName, Message: fmt.Sprintf("%s using model %s: %v", action, modelName, err), Err: err}
+		}
+		return fmt.Errorf("%s using model %s: %w", action, modelName, err)
+	}
+
+	code := apiErr.HTTPCode()
+	kind, known := classifyHTTPStatus(code)
+	if apiErr.Reason() == "API_KEY_INVALID" {
+		kind, known = ErrInvalidAPIKey, true
+	}
+	if !known {
+		return fmt.Errorf("%s using model %s: %w", action, modelName, err)
+	}
+
+	aiErr := &Error{Kind: kind, Provider: "gemini", Model: modelName, Message: fmt.Sprintf("%s using model %s: %v", action, modelName, err), Err: err}
+	var gErr *googleapi.Error
+	if errors.As(err, &gErr) {
+		if gErr.Message != "" {
+			aiErr.Message = fmt.Sprintf("%s using model %s: HTTP %d: %s", action, modelName, code, gErr.Message)
+		}
+		aiErr.RetryAfter = parseRetryAfter(gErr.Header.Get("Retry-After"))
+	}
+	details := apiErr.Details()
+	if retry := details.RetryInfo; retry != nil {
+		aiErr.RetryAfter = retry.GetRetryDelay().AsDuration()
+	}
+	if kind == ErrRateLimited && details.QuotaFailure != nil {
+		// Per-minute limits clear quickly; per-day ones (and free tier exhaustion) don't.
+		for _, v := range details.QuotaFailure.GetViolations() {
+			if strings.Contains(v.GetQuotaId()+v.GetSubject()+v.GetDescription(), "PerDay") {
+				aiErr.Kind = ErrQuotaExhausted
+			}
+		}
+	}
+	return aiErr
+}
+
+// toGenaiSchema converts a backend-neutral Schema into Gemini's response schema format.
+func toGenaiSchema(s *Schema) *genai.Schema {
+	if s == nil {
+		return nil
+	}
+	out := &genai.Schema{
+		Description: s.Description,
+		Enum:        s.Enum,
+		Items:       toGenaiSchema(s.Items),
+		Required:    s.Required,
+	}
+	if len(s.Enum) > 0 {
+		out.Format = "enum" // Gemini only applies Enum to strings of this format
+	}
+	switch s.Type {
+	case SchemaString:
+		out.Type = genai.TypeString
+	case SchemaNumber:
+		out.Type = genai.TypeNumber
+	case SchemaInteger:
+		out.Type = genai.TypeInteger
+	case SchemaBoolean:
+		out.Type = genai.TypeBoolean
+	case SchemaArray:
+		out.Type = genai.TypeArray
+	case SchemaObject:
+		out.Type = genai.TypeObject
+		out.Properties = make(map[string]*genai.Schema, len(s.Properties))
+		for name, prop := range s.Properties {
+			out.Properties[name] = toGenaiSchema(prop)
+		}
+	}
+	return out
 }
@@ -0,0 +1,30 @@
+package ai
+
+import (
+	"testing"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+func TestEmptyResponseError(t *testing.T) {
+	rated := []*genai.SafetyRating{{Category: genai.HarmCategoryDangerousContent, Probability: genai.HarmProbabilityHigh, Blocked: true}}
+	tests := []struct {
+		resp   *genai.GenerateContentResponse
+		kind   ErrorKind
+		safety bool
+	}{
+		{nil, ErrEmptyResponse, false},
+		{&genai.GenerateContentResponse{}, ErrEmptyResponse, false},
+		{&genai.GenerateContentResponse{Candidates: []*genai.Candidate{{FinishReason: genai.FinishReasonStop}}}, ErrEmptyResponse, false},
+		{&genai.GenerateContentResponse{Candidates: []*genai.Candidate{{FinishReason: genai.FinishReasonOther}}}, ErrEmptyResponse, false},
+		{&genai.GenerateContentResponse{Candidates: []*genai.Candidate{{FinishReason: genai.FinishReasonMaxTokens}}}, ErrMaxTokens, false},
+		{&genai.GenerateContentResponse{Candidates: []*genai.Candidate{{FinishReason: genai.FinishReasonSafety, SafetyRatings: rated}}}, ErrSafetyBlocked, true},
+		{&genai.GenerateContentResponse{Candidates: []*genai.Candidate{{FinishReason: genai.FinishReasonRecitation}}}, ErrRecitation, false},
+	}
+	for _, tt := range tests {
+		err := emptyResponseError(tt.resp, "gemini-test")
+		if err.Kind != tt.kind || (err.Safety != nil) != tt.safety || err.Model != "gemini-test" {
+			t.Errorf("emptyResponseError(%v) = %+v; want kind %s, safety diagnostic %v", tt.resp, err, tt.kind, tt.safety)
+		}
+	}
+}
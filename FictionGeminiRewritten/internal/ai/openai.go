@@ -0,0 +1,301 @@
+package ai
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"workspace/FictionGeminiRewritten/internal/models"
+)
+
+// OpenAICompatibleProvider talks to any server exposing the OpenAI chat completions API,
+// such as Ollama, vLLM, LM Studio or an OpenAI-compatible gateway.
+type OpenAICompatibleProvider struct {
+	name       string
+	baseURL    string
+	apiKey     string
+	httpClient *http.Client
+}
+
+// NewOpenAICompatibleProvider creates a provider for the chat completions API rooted at baseURL
+// (e.g. "http://localhost:11434/v1"). apiKey may be empty for servers that don't require one.
+func NewOpenAICompatibleProvider(name, baseURL, apiKey string) *OpenAICompatibleProvider {
+	return &OpenAICompatibleProvider{
+		name:       name,
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		apiKey:     apiKey,
+		httpClient: &http.Client{Timeout: 10 * time.Minute}, // Local models can be slow on large prompts
+	}
+}
+
+// Name implements Provider.
+func (p *OpenAICompatibleProvider) Name() string { return p.name }
+
+// GenerateText implements Provider.
+func (p *OpenAICompatibleProvider) GenerateText(ctx context.Context, req Request) (*Response, error) {
+	return p.chatCompletion(ctx, req, false)
+}
+
+// GenerateJSON implements Provider using the "json_schema" response format, or "json_object"
+// when the request carries no schema.
+func (p *OpenAICompatibleProvider) GenerateJSON(ctx context.Context, req Request) (*Response, error) {
+	return p.chatCompletion(ctx, req, true)
+}
+
+// CountTokens implements Provider. The chat completions API has no token counting endpoint,
+// so this falls back to the local estimator.
+func (p *OpenAICompatibleProvider) CountTokens(ctx context.Context, modelName string, prompt string) (int, error) {
+	return EstimateTokens(prompt), nil
+}
+
+type chatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type chatJSONSchema struct {
+	Name   string                 `json:"name"`
+	Schema map[string]interface{} `json:"schema"`
+}
+
+type chatResponseFormat struct {
+	Type       string          `json:"type"`
+	JSONSchema *chatJSONSchema `json:"json_schema,omitempty"`
+}
+
+type chatCompletionRequest struct {
+	Model          string              `json:"model"`
+	Messages       []chatMessage       `json:"messages"`
+	ResponseFormat *chatResponseFormat `json:"response_format,omitempty"`
+	Stream         bool                `json:"stream,omitempty"`
+	Temperature    *float32            `json:"temperature,omitempty"`
+	TopP           *float32            `json:"top_p,omitempty"`
+	TopK           *int32              `json:"top_k,omitempty"` // Not part of the OpenAI API, but honored by Ollama, vLLM and others
+	MaxTokens      *int32              `json:"max_tokens,omitempty"`
+	Stop           []string            `json:"stop,omitempty"`
+	N              *int32              `json:"n,omitempty"`
+	Seed           *int64              `json:"seed,omitempty"`
+	StreamOptions  *chatStreamOptions  `json:"stream_options,omitempty"`
+}
+
+type chatStreamOptions struct {
+	IncludeUsage bool `json:"include_usage"` // Send token usage in a final chunk
+}
+
+type chatUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+func (u *chatUsage) usage() Usage {
+	if u == nil {
+		return Usage{}
+	}
+	return Usage{PromptTokens: u.PromptTokens, CandidateTokens: u.CompletionTokens, TotalTokens: u.TotalTokens}
+}
+
+type chatCompletionResponse struct {
+	Choices []struct {
+		Message      chatMessage `json:"message"`
+		FinishReason string      `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *chatUsage `json:"usage,omitempty"`
+	Error *struct {
+		Message string          `json:"message"`
+		Type    string          `json:"type"`
+		Code    json.RawMessage `json:"code,omitempty"` // A string or a number depending on the server
+	} `json:"error,omitempty"`
+}
+
+type chatCompletionChunk struct {
+	Choices []struct {
+		Index        int         `json:"index"`
+		Delta        chatMessage `json:"delta"`
+		FinishReason string      `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *chatUsage `json:"usage,omitempty"`
+}
+
+func (p *OpenAICompatibleProvider) chatCompletion(ctx context.Context, req Request, jsonOutput bool) (*Response, error) {
+	if req.Model == "" {
+		return nil, fmt.Errorf("model name is required for the %s provider", p.name)
+	}
+
+	body := chatCompletionRequest{
+		Model:    req.Model,
+		Messages: []chatMessage{{Role: "user", Content: req.Prompt}},
+		Stream:   req.OnToken != nil,
+
+		Temperature: req.Config.Temperature,
+		TopP:        req.Config.TopP,
+		MaxTokens:   req.Config.MaxOutputTokens,
+		Stop:        req.Config.StopSequences,
+		N:           req.Config.CandidateCount,
+		Seed:        req.Config.Seed,
+	}
+	if p.name != "openai" { // OpenAI itself rejects unknown parameters
+		body.TopK = req.Config.TopK
+	}
+	if body.Stream {
+		body.StreamOptions = &chatStreamOptions{IncludeUsage: true}
+	}
+	if jsonOutput {
+		body.ResponseFormat = &chatResponseFormat{Type: "json_object"}
+		if req.Schema != nil {
+			body.ResponseFormat = &chatResponseFormat{
+				Type:       "json_schema",
+				JSONSchema: &chatJSONSchema{Name: "response", Schema: req.Schema.JSONSchema()},
+			}
+		}
+	}
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode chat completion request: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, p.baseURL+"/chat/completions", bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create chat completion request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if p.apiKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+p.apiKey)
+	}
+
+	httpResp, err := p.httpClient.Do(httpReq)
+	if err != nil {
+		if isNetworkError(ctx, err) {
+			return nil, &Error{Kind: ErrTransient, Provider: p.name, Model: req.Model, Message: err.Error(), Err: err}
+		}
+		return nil, fmt.Errorf("failed to generate content using %s model %s: %w", p.name, req.Model, err)
+	}
+	defer httpResp.Body.Close()
+
+	if body.Stream && httpResp.StatusCode == http.StatusOK {
+		return p.readStream(ctx, httpResp.Body, req)
+	}
+
+	respBody, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return nil, &Error{Kind: ErrTransient, Provider: p.name, Model: req.Model, Message: fmt.Sprintf("failed to read response: %v", err), Err: err}
+	}
+
+	var parsed chatCompletionResponse
+	parseErr := json.Unmarshal(respBody, &parsed)
+	if httpResp.StatusCode != http.StatusOK || parsed.Error != nil {
+		return nil, p.statusError(httpResp, parsed, req.Model)
+	}
+	if parseErr != nil {
+		return nil, fmt.Errorf("%s returned HTTP %d with an unreadable body: %w", p.name, httpResp.StatusCode, parseErr)
+	}
+
+	if len(parsed.Choices) == 0 || parsed.Choices[0].Message.Content == "" {
+		finishReason := ""
+		if len(parsed.Choices) > 0 {
+			finishReason = parsed.Choices[0].FinishReason
+		}
+		return nil, p.emptyResponseError(req.Model, finishReason)
+	}
+
+	return &Response{
+		Text:      parsed.Choices[0].Message.Content,
+		Truncated: parsed.Choices[0].FinishReason == "length",
+		Usage:     parsed.Usage.usage(),
+	}, nil
+}
+
+// statusError classifies a failed chat completion response.
+func (p *OpenAICompatibleProvider) statusError(httpResp *http.Response, parsed chatCompletionResponse, model string) error {
+	msg := http.StatusText(httpResp.StatusCode)
+	errType := ""
+	if parsed.Error != nil {
+		msg = parsed.Error.Message
+		errType = parsed.Error.Type + string(parsed.Error.Code)
+	}
+	text := fmt.Sprintf("%s returned HTTP %d for model %s: %s", p.name, httpResp.StatusCode, model, msg)
+
+	kind, ok := classifyHTTPStatus(httpResp.StatusCode)
+	if !ok {
+		return fmt.Errorf("%s", text)
+	}
+	if strings.Contains(errType, "insufficient_quota") {
+		kind = ErrQuotaExhausted
+	}
+	return &Error{
+		Kind:       kind,
+		Provider:   p.name,
+		Model:      model,
+		Message:    text,
+		RetryAfter: parseRetryAfter(httpResp.Header.Get("Retry-After")),
+	}
+}
+
+// emptyResponseError explains a response that carried no content.
+func (p *OpenAICompatibleProvider) emptyResponseError(model, finishReason string) error {
+	text := fmt.Sprintf("no content received from %s for model %s. FinishReason: %s", p.name, model, finishReason)
+	switch finishReason {
+	case "length":
+		return &Error{Kind: ErrMaxTokens, Provider: p.name, Model: model, Message: text}
+	case "content_filter":
+		diag := &models.SafetyDiagnostic{Source: models.SafetySourceResponse, FinishReason: finishReason}
+		diag.Hint = safetyHint(diag)
+		return &Error{Kind: ErrSafetyBlocked, Provider: p.name, Model: model, Message: text, Safety: diag}
+	}
+	return fmt.Errorf("%s", text)
+}
+
+// readStream consumes a server-sent event stream of chat completion chunks.
+func (p *OpenAICompatibleProvider) readStream(ctx context.Context, body io.Reader, req Request) (*Response, error) {
+	var text strings.Builder
+	var usage Usage
+	finishReason := ""
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		data, ok := strings.CutPrefix(line, "data:")
+		if !ok {
+			continue
+		}
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			break
+		}
+		var chunk chatCompletionChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return nil, fmt.Errorf("%s sent an unreadable stream chunk: %w", p.name, err)
+		}
+		if chunk.Usage != nil {
+			usage = chunk.Usage.usage()
+		}
+		for _, choice := range chunk.Choices {
+			if choice.Index != 0 {
+				continue // Only the first choice is used, as in chatCompletion
+			}
+			if choice.Delta.Content != "" {
+				text.WriteString(choice.Delta.Content)
+				req.OnToken(choice.Delta.Content)
+			}
+			if choice.FinishReason != "" {
+				finishReason = choice.FinishReason
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		if !isNetworkError(ctx, err) {
+			return nil, fmt.Errorf("failed to read %s stream: %w", p.name, err)
+		}
+		return nil, &Error{Kind: ErrTransient, Provider: p.name, Model: req.Model, Message: fmt.Sprintf("failed to read stream: %v", err), Err: err}
+	}
+	if text.Len() == 0 {
+		return nil, p.emptyResponseError(req.Model, finishReason)
+	}
+	return &Response{Text: text.String(), Truncated: finishReason == "length", Usage: usage}, nil
+}
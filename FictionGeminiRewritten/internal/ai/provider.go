@@ -0,0 +1,138 @@
+package ai
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"workspace/FictionGeminiRewritten/internal/models"
+)
+
+// Request describes a single prompt sent to a Provider.
+type Request struct {
+	Model  string // Backend-specific model name, without the "backend:" prefix
+	Prompt string
+	Schema *Schema // Shape the output must conform to; only used by GenerateJSON
+
+	// Config holds sampling parameters; unset fields use the backend's defaults.
+	Config models.GenerationConfig
+	// Safety maps harm categories to blocking thresholds (see models.SafetyCategories). Only
+	// Gemini supports it; other backends ignore it.
+	Safety map[string]string
+
+	// OnToken, when set, makes the provider stream its output and receive each text chunk as it arrives.
+	// The full text is still returned in the Response.
+	OnToken func(chunk string)
+	// OnRetry, when set, is called before a failed call is retried, so that text already passed
+	// to OnToken by the failed attempt can be discarded.
+	OnRetry func(attempt int, err error)
+}
+
+// Response is the result of a single generation call.
+type Response struct {
+	Text string
+
+	// Truncated is set when the model stopped because it reached its output token limit,
+	// so Text is incomplete and may be continued with a follow-up call.
+	Truncated bool
+
+	// Usage is the token usage the backend reported for the call; zero if it reported none.
+	Usage Usage
+}
+
+// Usage counts the tokens consumed by a call.
+type Usage struct {
+	PromptTokens    int
+	CandidateTokens int // Output tokens, across all candidates
+	TotalTokens     int
+}
+
+// Provider is implemented by every LLM backend the forge can talk to.
+// The orchestrator only depends on this interface, so every option works with any backend.
+type Provider interface {
+	// Name returns the backend identifier, e.g. "gemini" or "ollama".
+	Name() string
+	// GenerateText sends the prompt and returns the model's free-form text output.
+	GenerateText(ctx context.Context, req Request) (*Response, error)
+	// GenerateJSON sends the prompt and asks the backend to constrain its output to JSON,
+	// matching req.Schema when one is given.
+	GenerateJSON(ctx context.Context, req Request) (*Response, error)
+	// CountTokens returns the number of input tokens the prompt would consume.
+	CountTokens(ctx context.Context, model string, prompt string) (int, error)
+}
+
+// DefaultBackend is used when a model spec carries no "backend:" prefix.
+const DefaultBackend = "gemini"
+
+// openAICompatibleBackend describes an OpenAI-compatible HTTP gateway selectable by prefix.
+type openAICompatibleBackend struct {
+	baseURLEnv     string // Environment variable overriding the base URL
+	defaultBaseURL string
+	keyOptional    bool // Local servers such as Ollama don't need an API key
+}
+
+var openAICompatibleBackends = map[string]openAICompatibleBackend{
+	"ollama": {baseURLEnv: "OLLAMA_BASE_URL", defaultBaseURL: "http://localhost:11434/v1", keyOptional: true},
+	"openai": {baseURLEnv: "OPENAI_BASE_URL", defaultBaseURL: "https://api.openai.com/v1"},
+}
+
+// ParseModelSpec splits a model spec such as "ollama:llama3" into its backend and model name.
+// Specs without a known prefix (e.g. "gemini-1.5-pro") are treated as Gemini models.
+func ParseModelSpec(spec string) (backend string, model string) {
+	spec = strings.TrimSpace(spec)
+	if prefix, rest, found := strings.Cut(spec, ":"); found {
+		prefix = strings.ToLower(strings.TrimSpace(prefix))
+		if prefix == DefaultBackend {
+			return prefix, strings.TrimSpace(rest)
+		}
+		if _, ok := openAICompatibleBackends[prefix]; ok {
+			return prefix, strings.TrimSpace(rest)
+		}
+	}
+	return DefaultBackend, spec
+}
+
+// RequiresAPIKey reports whether the backend selected by spec needs a caller-supplied API key.
+func RequiresAPIKey(spec string) bool {
+	backend, _ := ParseModelSpec(spec)
+	if cfg, ok := openAICompatibleBackends[backend]; ok {
+		return !cfg.keyOptional
+	}
+	return true
+}
+
+// ResolveProvider returns the Provider for a model spec along with the bare model name to send to it.
+// Calls through the returned Provider are retried according to DefaultRetryPolicy.
+func ResolveProvider(spec string, apiKey string) (Provider, string, error) {
+	backend, model := ParseModelSpec(spec)
+	if model == "" {
+		return nil, "", fmt.Errorf("model name is missing in model spec '%s'", spec)
+	}
+
+	if backend == DefaultBackend {
+		if apiKey == "" {
+			return nil, "", &Error{Kind: ErrInvalidAPIKey, Provider: backend, Model: model, Message: "API key is required"}
+		}
+		return WithRetry(NewGeminiProvider(apiKey), DefaultRetryPolicy), model, nil
+	}
+
+	cfg := openAICompatibleBackends[backend]
+	if apiKey == "" && !cfg.keyOptional {
+		return nil, "", &Error{Kind: ErrInvalidAPIKey, Provider: backend, Model: model, Message: "API key is required"}
+	}
+	baseURL := os.Getenv(cfg.baseURLEnv)
+	if baseURL == "" {
+		baseURL = cfg.defaultBaseURL
+	}
+	return WithRetry(NewOpenAICompatibleProvider(backend, baseURL, apiKey), DefaultRetryPolicy), model, nil
+}
+
+// EstimateTokens is a local, backend-agnostic approximation of a prompt's token count
+// (roughly four characters per token for English prose).
+func EstimateTokens(text string) int {
+	if text == "" {
+		return 0
+	}
+	return (len([]rune(text)) + 3) / 4
+}
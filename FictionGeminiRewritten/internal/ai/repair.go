@@ -0,0 +1,223 @@
+package ai
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var markdownFence = regexp.MustCompile("(?s)^\\s*```[a-zA-Z]*\\s*\\n?(.*?)\\n?\\s*```\\s*$")
+
+// RepairJSON applies cheap, local fixes for the ways models most often break JSON:
+// markdown code fences, prose around the object, smart quotes used as delimiters,
+// raw line breaks and tabs inside strings, trailing commas, and output truncated before its
+// closing brackets. It returns the repaired text and a description of each fix applied; no
+// fixes means the input was returned unchanged.
+func RepairJSON(raw string) (string, []string) {
+	var fixes []string
+	text := strings.TrimSpace(raw)
+
+	if m := markdownFence.FindStringSubmatch(text); m != nil {
+		text = strings.TrimSpace(m[1])
+		fixes = append(fixes, "stripped markdown code fences")
+	}
+
+	start := jsonStart(text)
+	if start > 0 {
+		fixes = append(fixes, "dropped text before the JSON value")
+	}
+	text, scanFixes := repairScan(text[max(start, 0):])
+	fixes = append(fixes, scanFixes...)
+	return text, fixes
+}
+
+// maxStartCandidates caps the brackets jsonStart tries, since each costs a scan of the rest.
+const maxStartCandidates = 64
+
+// jsonStart returns where the JSON value in text begins: of the brackets that start a value
+// that is valid once repaired, the one whose value is longest, so a bracket in the prose
+// before the object ("see [1]: {...}") isn't taken for it. If no bracket starts a valid value
+// it returns the first, and -1 if there is none.
+func jsonStart(text string) int {
+	first, best, bestLen := -1, -1, 0
+	candidates := 0
+	for i, r := range text {
+		if r != '{' && r != '[' {
+			continue
+		}
+		if first < 0 {
+			first = i
+		}
+		if candidates++; candidates > maxStartCandidates {
+			break
+		}
+		value, _ := repairScan(text[i:])
+		if json.Valid([]byte(value)) && len(value) > bestLen {
+			best, bestLen = i, len(value)
+		}
+	}
+	if best < 0 {
+		return first
+	}
+	return best
+}
+
+func isSmartQuote(r rune) bool {
+	return r == '“' || r == '”' || r == '„' || r == '‟'
+}
+
+// repairScan walks the text once, tracking string and bracket state, and rewrites the
+// problems that can only be detected with that state.
+func repairScan(text string) (string, []string) {
+	var out strings.Builder
+	var stack []rune // Expected closing brackets
+	inString, smartString, escaped := false, false, false
+	smartQuotes, rawNewlines, rawTabs, trailingCommas := 0, 0, 0, 0
+	closed, trailingText := false, false
+	// An object key is expected next; the current string is a key; a key was read and its colon
+	// wasn't. keyStart is where the last key began in out, to drop it if the output stops there.
+	expectKey, inKey, awaitingColon := false, false, false
+	keyStart := 0
+
+	runes := []rune(text)
+	for i := 0; i < len(runes) && !closed; i++ {
+		r := runes[i]
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+				out.WriteRune(r)
+			case r == '\\':
+				escaped = true
+				out.WriteRune(r)
+			case smartString && r == '"':
+				out.WriteString(`\"`) // Strings opened with a smart quote only close on one
+			case r == '"' || (smartString && isSmartQuote(r)):
+				if r != '"' {
+					smartQuotes++
+				}
+				inString, smartString = false, false
+				if inKey {
+					inKey, awaitingColon = false, true
+				}
+				out.WriteRune('"')
+			case r == '\n':
+				rawNewlines++
+				out.WriteString(`\n`)
+			case r == '\r':
+				rawNewlines++
+			case r == '\t':
+				rawTabs++
+				out.WriteString(`\t`)
+			default:
+				out.WriteRune(r) // Smart quotes inside an ASCII-quoted string are prose
+			}
+			continue
+		}
+
+		switch {
+		case r == '"' || isSmartQuote(r):
+			if r != '"' {
+				smartQuotes++
+				smartString = true
+			}
+			if expectKey {
+				inKey, expectKey, keyStart = true, false, out.Len()
+			}
+			inString = true
+			out.WriteRune('"')
+		case r == '{':
+			stack = append(stack, '}')
+			expectKey = true
+			out.WriteRune(r)
+		case r == '[':
+			stack = append(stack, ']')
+			expectKey = false
+			out.WriteRune(r)
+		case r == ':':
+			awaitingColon = false
+			out.WriteRune(r)
+		case r == '}' || r == ']':
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+			expectKey, awaitingColon = false, false
+			out.WriteRune(r)
+			if len(stack) == 0 {
+				closed = true
+				trailingText = strings.TrimSpace(string(runes[i+1:])) != ""
+			}
+		case r == ',':
+			expectKey = len(stack) > 0 && stack[len(stack)-1] == '}'
+			if nextSignificant(runes, i+1) == '}' || nextSignificant(runes, i+1) == ']' {
+				trailingCommas++
+				continue
+			}
+			out.WriteRune(r)
+		default:
+			out.WriteRune(r)
+		}
+	}
+
+	var fixes []string
+	if smartQuotes > 0 {
+		fixes = append(fixes, fmt.Sprintf("replaced %d smart quotes used as JSON delimiters", smartQuotes))
+	}
+	if rawNewlines > 0 {
+		fixes = append(fixes, fmt.Sprintf("escaped %d raw line breaks inside strings", rawNewlines))
+	}
+	if rawTabs > 0 {
+		fixes = append(fixes, fmt.Sprintf("escaped %d raw tabs inside strings", rawTabs))
+	}
+	if trailingCommas > 0 {
+		fixes = append(fixes, fmt.Sprintf("removed %d trailing commas", trailingCommas))
+	}
+
+	result := out.String()
+	if trailingText {
+		fixes = append(fixes, "dropped text after the JSON value")
+	}
+	if !closed && len(stack) > 0 {
+		if inKey || awaitingColon {
+			// A key without a value can't be completed; drop it.
+			result, inString = result[:keyStart], false
+			fixes = append(fixes, "dropped a key cut off before its value")
+		}
+		result, fixes = closeTruncated(result, inString, escaped, stack, fixes)
+	}
+	return result, fixes
+}
+
+// closeTruncated terminates output that stopped mid-value (typically at the max token limit).
+func closeTruncated(result string, inString, escaped bool, stack []rune, fixes []string) (string, []string) {
+	if inString {
+		if escaped {
+			result = strings.TrimSuffix(result, `\`)
+		}
+		result += `"`
+		fixes = append(fixes, "closed an unterminated string")
+	}
+	result = strings.TrimRight(result, " \t\r\n")
+	result = strings.TrimSuffix(result, ",")
+	if strings.HasSuffix(result, ":") {
+		result += "null"
+	}
+	for i := len(stack) - 1; i >= 0; i-- {
+		result += string(stack[i])
+	}
+	fixes = append(fixes, fmt.Sprintf("closed %d unterminated brackets", len(stack)))
+	return result, fixes
+}
+
+func nextSignificant(runes []rune, from int) rune {
+	for i := from; i < len(runes); i++ {
+		switch runes[i] {
+		case ' ', '\t', '\r', '\n':
+			continue
+		default:
+			return runes[i]
+		}
+	}
+	return 0
+}
@@ -0,0 +1,42 @@
+package ai
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestRepairJSON(t *testing.T) {
+	tests := []struct {
+		raw   string
+		want  string
+		fixes []string
+	}{
+		{`{"a": 1}`, `{"a": 1}`, nil},
+		{"```json\n{\"a\": 1}\n```", `{"a": 1}`, []string{"stripped markdown code fences"}},
+		{`Here it is: {"a": 1} Hope that helps.`, `{"a": 1}`,
+			[]string{"dropped text before the JSON value", "dropped text after the JSON value"}},
+		{`Note [1]: {"a": 1}`, `{"a": 1}`, []string{"dropped text before the JSON value"}},
+		{`{"a": 1} see [2]`, `{"a": 1}`, []string{"dropped text after the JSON value"}},
+		{`{“a”: “say "hi"”}`, `{"a": "say \"hi\""}`, []string{"replaced 4 smart quotes used as JSON delimiters"}},
+		{"{\"a\": \"line\r\nbreak\"}", `{"a": "line\nbreak"}`, []string{"escaped 2 raw line breaks inside strings"}},
+		{"{\"a\": \"x\ty\"}", `{"a": "x\ty"}`, []string{"escaped 1 raw tabs inside strings"}},
+		{`{"a": [1, 2,], }`, `{"a": [1, 2] }`, []string{"removed 2 trailing commas"}},
+		{`{"a": [1, 2`, `{"a": [1, 2]}`, []string{"closed 2 unterminated brackets"}},
+		{`{"a": "trunc\`, `{"a": "trunc"}`, []string{"closed an unterminated string", "closed 1 unterminated brackets"}},
+		{`{"a": 1, "b":`, `{"a": 1, "b":null}`, []string{"closed 1 unterminated brackets"}},
+		{`{"a": 1, "b`, `{"a": 1}`, []string{"dropped a key cut off before its value", "closed 1 unterminated brackets"}},
+		{`{"a": 1, "b"`, `{"a": 1}`, []string{"dropped a key cut off before its value", "closed 1 unterminated brackets"}},
+		{`{"a": [{"b": 1}, {"c`, `{"a": [{"b": 1}, {}]}`, []string{"dropped a key cut off before its value", "closed 3 unterminated brackets"}},
+		{`["a", "b`, `["a", "b"]`, []string{"closed an unterminated string", "closed 1 unterminated brackets"}},
+	}
+	for _, tt := range tests {
+		got, fixes := RepairJSON(tt.raw)
+		if got != tt.want || !reflect.DeepEqual(fixes, tt.fixes) {
+			t.Errorf("RepairJSON(%q) = %q, %q; want %q, %q", tt.raw, got, fixes, tt.want, tt.fixes)
+		}
+		if !json.Valid([]byte(got)) {
+			t.Errorf("RepairJSON(%q) = %q, not valid JSON", tt.raw, got)
+		}
+	}
+}
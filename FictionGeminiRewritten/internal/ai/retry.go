@@ -0,0 +1,97 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy controls how retryable errors (rate limits and transient failures) are retried.
+type RetryPolicy struct {
+	MaxAttempts   int           // Total attempts, including the first
+	BaseDelay     time.Duration // Delay before the first retry; doubled for each further retry
+	MaxDelay      time.Duration // Cap on the backoff delay
+	MaxRetryAfter time.Duration // Longest server-suggested wait honored; longer hints fail immediately
+}
+
+// DefaultRetryPolicy is applied to every provider returned by ResolveProvider.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:   4,
+	BaseDelay:     time.Second,
+	MaxDelay:      30 * time.Second,
+	MaxRetryAfter: 2 * time.Minute,
+}
+
+// retryingProvider wraps a Provider, retrying calls that fail with a retryable *Error.
+type retryingProvider struct {
+	Provider
+	policy RetryPolicy
+}
+
+// WithRetry returns p with calls retried according to policy, using exponential backoff with
+// full jitter, or the server's retry-after hint when it gives one.
+func WithRetry(p Provider, policy RetryPolicy) Provider {
+	if policy.MaxAttempts <= 1 {
+		return p
+	}
+	return &retryingProvider{Provider: p, policy: policy}
+}
+
+// GenerateText implements Provider.
+func (r *retryingProvider) GenerateText(ctx context.Context, req Request) (*Response, error) {
+	return r.do(ctx, req, r.Provider.GenerateText)
+}
+
+// GenerateJSON implements Provider.
+func (r *retryingProvider) GenerateJSON(ctx context.Context, req Request) (*Response, error) {
+	return r.do(ctx, req, r.Provider.GenerateJSON)
+}
+
+func (r *retryingProvider) do(ctx context.Context, req Request, call func(context.Context, Request) (*Response, error)) (*Response, error) {
+	for attempt := 1; ; attempt++ {
+		resp, err := call(ctx, req)
+		if err == nil {
+			return resp, nil
+		}
+
+		var aiErr *Error
+		if !errors.As(err, &aiErr) || !aiErr.Retryable() || attempt >= r.policy.MaxAttempts {
+			return nil, err
+		}
+		delay, ok := r.delay(attempt, aiErr.RetryAfter)
+		if !ok {
+			return nil, err
+		}
+
+		log.Printf("%s call for model %s failed with %s (attempt %d/%d), retrying in %s",
+			r.Name(), req.Model, aiErr.Kind, attempt, r.policy.MaxAttempts, delay.Round(time.Millisecond))
+		if req.OnRetry != nil {
+			req.OnRetry(attempt, err)
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, err
+		case <-timer.C:
+		}
+	}
+}
+
+// delay returns how long to wait before retry number attempt. A server hint wins over the
+// computed backoff; ok is false when the hint is longer than the policy is willing to wait.
+func (r *retryingProvider) delay(attempt int, retryAfter time.Duration) (time.Duration, bool) {
+	if retryAfter > 0 {
+		return retryAfter, retryAfter <= r.policy.MaxRetryAfter
+	}
+	backoff := r.policy.BaseDelay << (attempt - 1)
+	if backoff <= 0 || backoff > r.policy.MaxDelay {
+		backoff = r.policy.MaxDelay
+	}
+	if backoff <= 0 {
+		return 0, true
+	}
+	return time.Duration(rand.Int63n(int64(backoff)) + 1), true
+}
@@ -0,0 +1,103 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+// scriptedProvider fails its calls with errs in turn, then succeeds.
+type scriptedProvider struct {
+	errs  []error
+	calls int
+}
+
+func (p *scriptedProvider) Name() string { return "scripted" }
+
+func (p *scriptedProvider) GenerateText(ctx context.Context, req Request) (*Response, error) {
+	p.calls++
+	if p.calls <= len(p.errs) {
+		return nil, p.errs[p.calls-1]
+	}
+	return &Response{Text: "ok"}, nil
+}
+
+func (p *scriptedProvider) GenerateJSON(ctx context.Context, req Request) (*Response, error) {
+	return p.GenerateText(ctx, req)
+}
+
+func (p *scriptedProvider) CountTokens(ctx context.Context, model string, prompt string) (int, error) {
+	return 0, nil
+}
+
+var testPolicy = RetryPolicy{MaxAttempts: 3, BaseDelay: time.Microsecond, MaxDelay: time.Millisecond, MaxRetryAfter: time.Millisecond}
+
+func TestWithRetry(t *testing.T) {
+	transient := &Error{Kind: ErrTransient, Message: "unavailable"}
+	limited := &Error{Kind: ErrRateLimited, Message: "slow down", RetryAfter: time.Microsecond}
+	blocked := &Error{Kind: ErrSafetyBlocked, Message: "blocked"}
+	plain := errors.New("bad request")
+	longWait := &Error{Kind: ErrRateLimited, Message: "come back later", RetryAfter: time.Hour}
+	tests := []struct {
+		name    string
+		errs    []error
+		calls   int
+		retries []int
+		wantErr error
+	}{
+		{"success", nil, 1, nil, nil},
+		{"transient then success", []error{transient, limited}, 3, []int{1, 2}, nil},
+		{"gives up after max attempts", []error{transient, transient, transient, transient}, 3, []int{1, 2}, transient},
+		{"not retryable", []error{blocked}, 1, nil, blocked},
+		{"unclassified", []error{plain}, 1, nil, plain},
+		{"retry-after too long", []error{longWait}, 1, nil, longWait},
+	}
+	for _, tt := range tests {
+		provider := &scriptedProvider{errs: tt.errs}
+		var retries []int
+		req := Request{Model: "m", OnRetry: func(attempt int, err error) { retries = append(retries, attempt) }}
+		resp, err := WithRetry(provider, testPolicy).GenerateJSON(context.Background(), req)
+		if err != tt.wantErr || (err == nil && resp.Text != "ok") {
+			t.Errorf("%s: got %v, %v; want error %v", tt.name, resp, err, tt.wantErr)
+		}
+		if provider.calls != tt.calls || !slices.Equal(retries, tt.retries) {
+			t.Errorf("%s: %d calls, retries %v; want %d calls, retries %v", tt.name, provider.calls, retries, tt.calls, tt.retries)
+		}
+	}
+}
+
+func TestWithRetryStopsWhenCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	provider := &scriptedProvider{errs: []error{&Error{Kind: ErrTransient}, &Error{Kind: ErrTransient}}}
+	policy := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Hour, MaxDelay: time.Hour}
+	req := Request{OnRetry: func(int, error) { cancel() }}
+	if _, err := WithRetry(provider, policy).GenerateText(ctx, req); KindOf(err) != ErrTransient || provider.calls != 1 {
+		t.Errorf("got %v after %d calls, want the first error after 1 call", err, provider.calls)
+	}
+}
+
+func TestWithRetrySingleAttempt(t *testing.T) {
+	provider := &scriptedProvider{}
+	if WithRetry(provider, RetryPolicy{MaxAttempts: 1}) != Provider(provider) {
+		t.Error("a policy of one attempt should return the provider as is")
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	r := &retryingProvider{policy: RetryPolicy{BaseDelay: time.Second, MaxDelay: 5 * time.Second, MaxRetryAfter: time.Minute}}
+	for attempt, limit := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 5 * time.Second, 70: 5 * time.Second} {
+		for range 100 {
+			if delay, ok := r.delay(attempt, 0); !ok || delay <= 0 || delay > limit {
+				t.Fatalf("delay(%d) = %v, %v; want up to %v", attempt, delay, ok, limit)
+			}
+		}
+	}
+	if delay, ok := r.delay(1, 30*time.Second); !ok || delay != 30*time.Second {
+		t.Errorf("delay with a retry-after hint = %v, %v; want the hint", delay, ok)
+	}
+	if _, ok := r.delay(1, 2*time.Minute); ok {
+		t.Error("a retry-after hint over MaxRetryAfter should not be waited for")
+	}
+}
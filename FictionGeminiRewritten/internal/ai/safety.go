@@ -0,0 +1,146 @@
+package ai
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/generative-ai-go/genai"
+
+	"workspace/FictionGeminiRewritten/internal/models"
+)
+
+var geminiHarmCategories = map[string]genai.HarmCategory{
+	models.SafetyHarassment:       genai.HarmCategoryHarassment,
+	models.SafetyHateSpeech:       genai.HarmCategoryHateSpeech,
+	models.SafetySexuallyExplicit: genai.HarmCategorySexuallyExplicit,
+	models.SafetyDangerousContent: genai.HarmCategoryDangerousContent,
+}
+
+var geminiHarmThresholds = map[string]genai.HarmBlockThreshold{
+	"block_none":             genai.HarmBlockNone,
+	"block_only_high":        genai.HarmBlockOnlyHigh,
+	"block_medium_and_above": genai.HarmBlockMediumAndAbove,
+	"block_low_and_above":    genai.HarmBlockLowAndAbove,
+}
+
+// geminiSafetySettings converts safety_settings into Gemini's form. Unknown categories and
+// thresholds are skipped; requests are validated before they get here.
+func geminiSafetySettings(settings map[string]string) []*genai.SafetySetting {
+	var out []*genai.SafetySetting
+	for _, name := range models.SafetyCategories {
+		threshold, ok := geminiHarmThresholds[settings[name]]
+		if !ok {
+			continue
+		}
+		out = append(out, &genai.SafetySetting{Category: geminiHarmCategories[name], Threshold: threshold})
+	}
+	return out
+}
+
+// promptSafetyDiagnostic describes a prompt rejected by Gemini's safety filters.
+func promptSafetyDiagnostic(feedback *genai.PromptFeedback) *models.SafetyDiagnostic {
+	diag := &models.SafetyDiagnostic{Source: models.SafetySourcePrompt}
+	if feedback != nil {
+		diag.BlockReason = enumName(feedback.BlockReason.String(), "BlockReason")
+		diag.Ratings = safetyRatings(feedback.SafetyRatings)
+	}
+	diag.Hint = safetyHint(diag)
+	return diag
+}
+
+// candidateSafetyDiagnostic describes a response Gemini stopped for safety.
+func candidateSafetyDiagnostic(cand *genai.Candidate) *models.SafetyDiagnostic {
+	diag := &models.SafetyDiagnostic{Source: models.SafetySourceResponse}
+	if cand != nil {
+		diag.FinishReason = enumName(cand.FinishReason.String(), "FinishReason")
+		diag.Ratings = safetyRatings(cand.SafetyRatings)
+	}
+	diag.Hint = safetyHint(diag)
+	return diag
+}
+
+// safetyRatings converts Gemini's ratings. Gemini usually flags the ratings that caused a
+// block; when it flags none, every category rated medium or higher is reported as blocking.
+func safetyRatings(ratings []*genai.SafetyRating) []models.SafetyRating {
+	flagged := false
+	for _, r := range ratings {
+		flagged = flagged || r.Blocked
+	}
+	out := make([]models.SafetyRating, 0, len(ratings))
+	for _, r := range ratings {
+		out = append(out, models.SafetyRating{
+			Category:    harmCategoryName(r.Category),
+			Probability: enumName(r.Probability.String(), "HarmProbability"),
+			Blocked:     r.Blocked || (!flagged && r.Probability >= genai.HarmProbabilityMedium),
+		})
+	}
+	return out
+}
+
+// safetyHint tells the user what to change to get past a block.
+func safetyHint(diag *models.SafetyDiagnostic) string {
+	blocked := blockedCategories(diag)
+	where := "The model's output for this step was blocked"
+	if diag.Source == models.SafetySourcePrompt {
+		where = "The prompt itself was blocked, so the series name or premise triggered the filter"
+	}
+	switch {
+	case diag.FinishReason == "content_filter":
+		return "The backend's content filter stopped the output. safety_settings only apply to Gemini; use a different model or adjust the filter on the backend."
+	case diag.BlockReason == "other":
+		return where + " for a reason other than the harm categories, so safety_settings won't help."
+	case len(blocked) > 0:
+		// block_only_high lets medium ratings through; content already rated high needs block_none.
+		threshold := "block_only_high"
+		for _, r := range diag.Ratings {
+			if r.Blocked && r.Probability == "high" {
+				threshold = "block_none"
+			}
+		}
+		return fmt.Sprintf("%s for %s. Loosen the threshold in safety_settings, e.g. {\"%s\": \"%s\"}.",
+			where, strings.Join(blocked, ", "), blocked[0], threshold)
+	}
+	return where + ". Loosening safety_settings for the categories rated highest may help."
+}
+
+// blockedCategories lists the categories of the ratings that caused a block.
+func blockedCategories(diag *models.SafetyDiagnostic) []string {
+	if diag == nil {
+		return nil
+	}
+	var categories []string
+	for _, r := range diag.Ratings {
+		if r.Blocked {
+			categories = append(categories, r.Category)
+		}
+	}
+	return categories
+}
+
+// harmCategoryName returns the safety_settings name of a category, falling back to a
+// snake_case form of Gemini's name for categories that can't be configured.
+func harmCategoryName(category genai.HarmCategory) string {
+	for name, c := range geminiHarmCategories {
+		if c == category {
+			return name
+		}
+	}
+	return enumName(category.String(), "HarmCategory")
+}
+
+// enumName turns a genai enum name such as "HarmProbabilityHigh" into "high" or
+// "FinishReasonMaxTokens" into "max_tokens".
+func enumName(name, prefix string) string {
+	name = strings.TrimPrefix(name, prefix)
+	var b strings.Builder
+	for i, r := range name {
+		if r >= 'A' && r <= 'Z' {
+			if i > 0 {
+				b.WriteByte('_')
+			}
+			r += 'a' - 'A'
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
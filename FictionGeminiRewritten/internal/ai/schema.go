@@ -0,0 +1,380 @@
+package ai
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// SchemaType is the JSON type of a Schema node.
+type SchemaType string
+
+const (
+	SchemaString  SchemaType = "string"
+	SchemaNumber  SchemaType = "number"
+	SchemaInteger SchemaType = "integer"
+	SchemaBoolean SchemaType = "boolean"
+	SchemaArray   SchemaType = "array"
+	SchemaObject  SchemaType = "object"
+)
+
+// Schema is a backend-neutral description of the JSON shape a structured call must produce.
+// Providers translate it into their native schema format (genai.Schema, JSON Schema, ...).
+type Schema struct {
+	Type        SchemaType
+	Description string
+	Enum        []string
+	Minimum     *float64 // Bounds of numbers and integers
+	Maximum     *float64
+	Items       *Schema            // Element schema for arrays
+	Properties  map[string]*Schema // Member schemas for objects
+	Order       []string           // Property names in struct declaration order
+	Required    []string
+}
+
+// SchemaFor derives a Schema from a Go value's type using its `json` struct tags.
+// Fields tagged omitempty are optional, all others are required. A `schema` tag constrains
+// a field's values: `schema:"enum=a|b|c"` for strings, `schema:"min=0,max=100"` for numbers.
+// The fields of untagged embedded structs are promoted, as encoding/json does, with the outer
+// struct's own fields winning over promoted ones of the same name. Free-form maps
+// (e.g. models.Extensions) and interface fields are left out, since models can't be
+// constrained to them and they are filled in by the forge rather than the AI.
+func SchemaFor(v interface{}) *Schema {
+	return schemaForType(reflect.TypeOf(v), map[reflect.Type]bool{})
+}
+
+func schemaForType(t reflect.Type, visiting map[reflect.Type]bool) *Schema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return &Schema{Type: SchemaString}
+	case reflect.Bool:
+		return &Schema{Type: SchemaBoolean}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: SchemaInteger}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: SchemaNumber}
+	case reflect.Slice, reflect.Array:
+		items := schemaForType(t.Elem(), visiting)
+		if items == nil {
+			return nil
+		}
+		return &Schema{Type: SchemaArray, Items: items}
+	case reflect.Struct:
+		if visiting[t] {
+			return nil // Recursive types can't be expressed; drop the cycle
+		}
+		visiting[t] = true
+		defer delete(visiting, t)
+
+		s := &Schema{Type: SchemaObject, Properties: map[string]*Schema{}}
+		promoted := map[string]bool{} // Properties taken from embedded structs
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if embedded := embeddedStruct(field); embedded != nil {
+				inner := schemaForType(embedded, visiting)
+				if inner == nil {
+					continue
+				}
+				for _, name := range inner.Order {
+					if _, taken := s.Properties[name]; !taken {
+						s.addProperty(name, inner.Properties[name], containsString(inner.Required, name))
+						promoted[name] = true
+					}
+				}
+				continue
+			}
+			if !field.IsExported() {
+				continue
+			}
+			name, omitEmpty, skip := parseJSONTag(field)
+			if skip {
+				continue
+			}
+			fieldSchema := schemaForType(field.Type, visiting)
+			if fieldSchema == nil {
+				continue
+			}
+			if tag, ok := field.Tag.Lookup("schema"); ok {
+				applySchemaTag(fieldSchema, t.Name()+"."+field.Name, tag)
+			}
+			if promoted[name] {
+				s.removeProperty(name)
+				delete(promoted, name)
+			}
+			s.addProperty(name, fieldSchema, !omitEmpty)
+		}
+		return s
+	default:
+		return nil // Maps, interfaces, funcs, channels
+	}
+}
+
+// embeddedStruct returns the struct type of an embedded field whose fields encoding/json
+// promotes (one without a json name), or nil for any other field.
+func embeddedStruct(field reflect.StructField) reflect.Type {
+	if !field.Anonymous {
+		return nil
+	}
+	if name, _, _ := strings.Cut(field.Tag.Get("json"), ","); name != "" {
+		return nil // Named, or "-"
+	}
+	t := field.Type
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+	return t
+}
+
+func (s *Schema) addProperty(name string, property *Schema, required bool) {
+	s.Properties[name] = property
+	s.Order = append(s.Order, name)
+	if required {
+		s.Required = append(s.Required, name)
+	}
+}
+
+func (s *Schema) removeProperty(name string) {
+	delete(s.Properties, name)
+	s.Order = slices.DeleteFunc(s.Order, func(n string) bool { return n == name })
+	s.Required = slices.DeleteFunc(s.Required, func(n string) bool { return n == name })
+}
+
+// applySchemaTag sets the constraints of a `schema` struct tag on s. A malformed tag is a
+// programming error, so it panics.
+func applySchemaTag(s *Schema, field, tag string) {
+	for _, option := range strings.Split(tag, ",") {
+		key, value, _ := strings.Cut(option, "=")
+		switch key {
+		case "enum":
+			s.Enum = strings.Split(value, "|")
+		case "min", "max":
+			bound, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				panic(fmt.Sprintf("ai: %s: bad %s in schema tag %q", field, key, tag))
+			}
+			if key == "min" {
+				s.Minimum = &bound
+			} else {
+				s.Maximum = &bound
+			}
+		default:
+			panic(fmt.Sprintf("ai: %s: unknown option %q in schema tag %q", field, key, tag))
+		}
+	}
+}
+
+func parseJSONTag(field reflect.StructField) (name string, omitEmpty bool, skip bool) {
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return "", false, true
+	}
+	parts := strings.Split(tag, ",")
+	name = parts[0]
+	if name == "" {
+		name = field.Name
+	}
+	for _, opt := range parts[1:] {
+		if opt == "omitempty" {
+			omitEmpty = true
+		}
+	}
+	return name, omitEmpty, false
+}
+
+// JSONSchema renders the Schema as a standard JSON Schema document.
+func (s *Schema) JSONSchema() map[string]interface{} {
+	out := map[string]interface{}{"type": string(s.Type)}
+	if s.Description != "" {
+		out["description"] = s.Description
+	}
+	if len(s.Enum) > 0 {
+		out["enum"] = s.Enum
+	}
+	if s.Minimum != nil {
+		out["minimum"] = *s.Minimum
+	}
+	if s.Maximum != nil {
+		out["maximum"] = *s.Maximum
+	}
+	if s.Items != nil {
+		out["items"] = s.Items.JSONSchema()
+	}
+	if s.Type == SchemaObject {
+		props := map[string]interface{}{}
+		for _, name := range s.Order {
+			props[name] = s.Properties[name].JSONSchema()
+		}
+		out["properties"] = props
+		if len(s.Required) > 0 {
+			out["required"] = s.Required
+		}
+	}
+	return out
+}
+
+// SchemaViolation is a single place where a JSON document doesn't match its Schema.
+type SchemaViolation struct {
+	Path    string // e.g. "data.alternate_greetings[2]"
+	Message string
+}
+
+func (v SchemaViolation) String() string {
+	return fmt.Sprintf("%s: %s", v.Path, v.Message)
+}
+
+// SchemaError is returned when a structured response is valid JSON but doesn't match its Schema.
+type SchemaError struct {
+	Violations []SchemaViolation
+}
+
+func (e *SchemaError) Error() string {
+	if len(e.Violations) == 1 {
+		return fmt.Sprintf("response does not match the expected schema: %s", e.Violations[0])
+	}
+	return fmt.Sprintf("response does not match the expected schema: %d violations, first: %s", len(e.Violations), e.Violations[0])
+}
+
+// ValidateJSON checks data against schema. A non-nil error means data isn't valid JSON at all;
+// otherwise every mismatch is returned as a SchemaViolation.
+func ValidateJSON(data []byte, schema *Schema) ([]SchemaViolation, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var doc interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, fmt.Errorf("unexpected data after the top-level JSON value at offset %d", dec.InputOffset())
+	}
+	var violations []SchemaViolation
+	validateNode(doc, schema, "$", &violations)
+	return violations, nil
+}
+
+func validateNode(node interface{}, schema *Schema, path string, violations *[]SchemaViolation) {
+	if schema == nil {
+		return
+	}
+	add := func(format string, args ...interface{}) {
+		*violations = append(*violations, SchemaViolation{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+	if node == nil {
+		add("expected %s, got null", schema.Type)
+		return
+	}
+
+	switch schema.Type {
+	case SchemaString:
+		str, ok := node.(string)
+		if !ok {
+			add("expected string, got %s", jsonTypeName(node))
+			return
+		}
+		if len(schema.Enum) > 0 && !containsString(schema.Enum, str) {
+			add("value %q is not one of %v", str, schema.Enum)
+		}
+	case SchemaBoolean:
+		if _, ok := node.(bool); !ok {
+			add("expected boolean, got %s", jsonTypeName(node))
+		}
+	case SchemaNumber:
+		num, ok := node.(json.Number)
+		if !ok {
+			add("expected number, got %s", jsonTypeName(node))
+			return
+		}
+		checkBounds(num, schema, add)
+	case SchemaInteger:
+		num, ok := node.(json.Number)
+		if !ok {
+			add("expected integer, got %s", jsonTypeName(node))
+			return
+		}
+		if _, err := num.Int64(); err != nil {
+			add("expected integer, got %s", num.String())
+			return
+		}
+		checkBounds(num, schema, add)
+	case SchemaArray:
+		items, ok := node.([]interface{})
+		if !ok {
+			add("expected array, got %s", jsonTypeName(node))
+			return
+		}
+		for i, item := range items {
+			validateNode(item, schema.Items, fmt.Sprintf("%s[%d]", path, i), violations)
+		}
+	case SchemaObject:
+		obj, ok := node.(map[string]interface{})
+		if !ok {
+			add("expected object, got %s", jsonTypeName(node))
+			return
+		}
+		for _, name := range schema.Required {
+			if _, present := obj[name]; !present {
+				*violations = append(*violations, SchemaViolation{Path: path + "." + name, Message: "required field is missing"})
+			}
+		}
+		for _, name := range schema.Order {
+			if value, present := obj[name]; present {
+				if value == nil && !containsString(schema.Required, name) {
+					continue // Optional fields may be null
+				}
+				validateNode(value, schema.Properties[name], path+"."+name, violations)
+			}
+		}
+	}
+}
+
+// checkBounds reports num if it lies outside schema's minimum or maximum.
+func checkBounds(num json.Number, schema *Schema, add func(format string, args ...interface{})) {
+	f, err := num.Float64()
+	if err != nil {
+		return
+	}
+	if schema.Minimum != nil && f < *schema.Minimum {
+		add("value %s is less than the minimum %g", num, *schema.Minimum)
+	}
+	if schema.Maximum != nil && f > *schema.Maximum {
+		add("value %s is greater than the maximum %g", num, *schema.Maximum)
+	}
+}
+
+func jsonTypeName(node interface{}) string {
+	switch node.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case json.Number:
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", node)
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
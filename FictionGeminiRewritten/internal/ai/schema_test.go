@@ -0,0 +1,117 @@
+package ai
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+
+	"workspace/FictionGeminiRewritten/internal/models"
+)
+
+type schemaBase struct {
+	ID    string `json:"id"`
+	Notes string `json:"notes,omitempty"`
+}
+
+type schemaNode struct {
+	schemaBase
+	Name     string                 `json:"name"`
+	Notes    int                    `json:"notes" schema:"min=0"` // Wins over schemaBase.Notes
+	Kind     string                 `json:"kind,omitempty" schema:"enum=a|b"`
+	Weight   float64                `json:"weight,omitempty" schema:"min=0,max=1"`
+	Tags     []string               `json:"tags"`
+	Children []schemaNode           `json:"children,omitempty"` // Recursive, so left out
+	Extra    map[string]interface{} `json:"extra,omitempty"`
+	Internal string                 `json:"-"`
+	hidden   string
+	Untagged bool
+}
+
+func TestSchemaFor(t *testing.T) {
+	s := SchemaFor(schemaNode{})
+	if s.Type != SchemaObject {
+		t.Fatalf("type = %s, want object", s.Type)
+	}
+	if want := []string{"id", "name", "notes", "kind", "weight", "tags", "Untagged"}; !slices.Equal(s.Order, want) {
+		t.Errorf("order = %q, want %q", s.Order, want)
+	}
+	if want := []string{"id", "name", "notes", "tags", "Untagged"}; !slices.Equal(s.Required, want) {
+		t.Errorf("required = %q, want %q", s.Required, want)
+	}
+	if notes := s.Properties["notes"]; notes.Type != SchemaInteger || notes.Minimum == nil || *notes.Minimum != 0 {
+		t.Errorf("notes = %+v, want the outer integer field", notes)
+	}
+	if kind := s.Properties["kind"]; !slices.Equal(kind.Enum, []string{"a", "b"}) {
+		t.Errorf("kind enum = %q", kind.Enum)
+	}
+	if weight := s.Properties["weight"]; weight.Type != SchemaNumber || *weight.Minimum != 0 || *weight.Maximum != 1 {
+		t.Errorf("weight = %+v", weight)
+	}
+	if tags := s.Properties["tags"]; tags.Type != SchemaArray || tags.Items.Type != SchemaString {
+		t.Errorf("tags = %+v", tags)
+	}
+}
+
+func TestSchemaForPromotesEmbeddedFields(t *testing.T) {
+	s := SchemaFor(models.CardDataV3{})
+	if _, ok := s.Properties["CardData"]; ok {
+		t.Fatalf("embedded struct became a property: %q", s.Order)
+	}
+	for _, name := range []string{"name", "description", "character_book", "nickname"} {
+		if !slices.Contains(s.Order, name) {
+			t.Errorf("order = %q, missing %s", s.Order, name)
+		}
+	}
+	if slices.Index(s.Order, "name") > slices.Index(s.Order, "nickname") {
+		t.Errorf("order = %q, want CardData's fields before CardV3Fields'", s.Order)
+	}
+}
+
+func TestSchemaForPanicsOnBadTag(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("a malformed schema tag did not panic")
+		}
+	}()
+	SchemaFor(struct {
+		N int `json:"n" schema:"min=low"`
+	}{})
+}
+
+func TestValidateJSON(t *testing.T) {
+	schema := SchemaFor(schemaNode{})
+	tests := []struct {
+		doc  string
+		want []SchemaViolation
+	}{
+		{`{"id":"x","name":"n","notes":0,"tags":[],"Untagged":true}`, nil},
+		{`{"id":"x","name":"n","notes":0,"tags":[],"Untagged":true,"kind":null,"weight":null,"unknown":1}`, nil},
+		{`{"id":1,"notes":-1,"kind":"c","weight":1.5,"tags":["a",2],"Untagged":"yes"}`, []SchemaViolation{
+			{"$.name", "required field is missing"},
+			{"$.id", "expected string, got number"},
+			{"$.notes", "value -1 is less than the minimum 0"},
+			{"$.kind", `value "c" is not one of [a b]`},
+			{"$.weight", "value 1.5 is greater than the maximum 1"},
+			{"$.tags[1]", "expected string, got number"},
+			{"$.Untagged", "expected boolean, got string"},
+		}},
+		{`{"id":"x","name":null,"notes":2.5,"tags":{},"Untagged":false}`, []SchemaViolation{
+			{"$.name", "expected string, got null"},
+			{"$.notes", "expected integer, got 2.5"},
+			{"$.tags", "expected array, got object"},
+		}},
+		{`[]`, []SchemaViolation{{"$", "expected object, got array"}}},
+	}
+	for _, tt := range tests {
+		got, err := ValidateJSON([]byte(tt.doc), schema)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ValidateJSON(%s) = %v, %v; want %v", tt.doc, got, err, tt.want)
+		}
+	}
+
+	for _, doc := range []string{`{"id":`, `{} {}`, ``} {
+		if _, err := ValidateJSON([]byte(doc), schema); err == nil {
+			t.Errorf("ValidateJSON(%q) did not fail", doc)
+		}
+	}
+}
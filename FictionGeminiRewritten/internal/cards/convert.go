@@ -0,0 +1,188 @@
+// Package cards converts character cards between the SillyTavern card specs.
+package cards
+
+import (
+	"encoding/json"
+	"maps"
+	"reflect"
+	"strings"
+
+	"workspace/FictionGeminiRewritten/internal/models"
+)
+
+// v3Extension is the extensions key under which a V2 card keeps what only V3 can express:
+// the card's V3 fields, and each lorebook entry's decorators. Converting the card back to V3
+// restores them, so V3 → V2 → V3 loses nothing.
+const v3Extension = "chara_card_v3"
+
+// v3Fields holds the V3-only card fields kept in a V2 card's data extensions.
+type v3Fields struct {
+	Nickname                 string             `json:"nickname,omitempty"`
+	CreatorNotesMultilingual map[string]string  `json:"creator_notes_multilingual,omitempty"`
+	Source                   []string           `json:"source,omitempty"`
+	GroupOnlyGreetings       []string           `json:"group_only_greetings,omitempty"`
+	CreationDate             int64              `json:"creation_date,omitempty"`
+	ModificationDate         int64              `json:"modification_date,omitempty"`
+	Assets                   []models.CardAsset `json:"assets,omitempty"`
+}
+
+// v3EntryFields holds the decorator lines of a V3 lorebook entry, kept in the entry's
+// extensions once they are cut from a V2 entry's content.
+type v3EntryFields struct {
+	Decorators []string `json:"decorators,omitempty"`
+}
+
+// V2ToV3 converts a V2 card to V3. V3 fields and decorators kept by V3ToV2 are restored, and
+// V3 fields a V2 writer put among the card's unknown members are taken as such; otherwise the
+// V3 fields are left empty. The card passed in is not modified.
+func V2ToV3(card models.CharacterCardV2) models.CharacterCardV3 {
+	data := card.Data
+	var fields v3Fields
+	data.Unknown = takeUnknown(data.Unknown, &fields)
+	data.Extensions = takeExtension(data.Extensions, &fields)
+	data.CharacterBook = lorebookToV3(data.CharacterBook)
+
+	groupOnly := fields.GroupOnlyGreetings
+	if groupOnly == nil {
+		groupOnly = []string{}
+	}
+	return models.CharacterCardV3{
+		Spec:        models.CardSpecV3,
+		SpecVersion: models.CardSpecVersionV3,
+		Data: models.CardDataV3{
+			CardData: data,
+			CardV3Fields: models.CardV3Fields{
+				Nickname:                 fields.Nickname,
+				CreatorNotesMultilingual: fields.CreatorNotesMultilingual,
+				Source:                   fields.Source,
+				GroupOnlyGreetings:       groupOnly,
+				CreationDate:             fields.CreationDate,
+				ModificationDate:         fields.ModificationDate,
+				Assets:                   fields.Assets,
+			},
+		},
+		Extensions: card.Extensions,
+		Unknown:    card.Unknown,
+	}
+}
+
+// V3ToV2 converts a V3 card to V2. The V3-only fields are kept in the card's data extensions
+// and decorators are cut from lorebook entries into the entries' extensions, since V2 readers
+// would insert them into prompts as text. The card passed in is not modified.
+func V3ToV2(card models.CharacterCardV3) models.CharacterCardV2 {
+	data := card.Data.CardData
+	data.Extensions = putExtension(data.Extensions, v3Fields{
+		Nickname:                 card.Data.Nickname,
+		CreatorNotesMultilingual: card.Data.CreatorNotesMultilingual,
+		Source:                   card.Data.Source,
+		GroupOnlyGreetings:       card.Data.GroupOnlyGreetings,
+		CreationDate:             card.Data.CreationDate,
+		ModificationDate:         card.Data.ModificationDate,
+		Assets:                   card.Data.Assets,
+	})
+	data.CharacterBook = lorebookToV2(data.CharacterBook)
+	return models.CharacterCardV2{
+		Spec:        models.CardSpecV2,
+		SpecVersion: models.CardSpecVersionV2,
+		Data:        data,
+		Extensions:  card.Extensions,
+		Unknown:     card.Unknown,
+	}
+}
+
+// lorebookToV3 puts the decorators kept by lorebookToV2 back at the top of each entry.
+func lorebookToV3(book *models.Lorebook) *models.Lorebook {
+	if book == nil {
+		return nil
+	}
+	converted := *book
+	converted.Entries = make([]models.LorebookEntry, len(book.Entries))
+	for i, entry := range book.Entries {
+		var fields v3EntryFields
+		entry.Extensions = takeExtension(entry.Extensions, &fields)
+		entry.Content = JoinDecorators(fields.Decorators, entry.Content)
+		converted.Entries[i] = entry
+	}
+	if book.Entries == nil {
+		converted.Entries = nil
+	}
+	return &converted
+}
+
+// lorebookToV2 cuts the decorators from the top of each entry into the entry's extensions.
+func lorebookToV2(book *models.Lorebook) *models.Lorebook {
+	if book == nil {
+		return nil
+	}
+	converted := *book
+	converted.Entries = make([]models.LorebookEntry, len(book.Entries))
+	for i, entry := range book.Entries {
+		decorators, content := SplitDecorators(entry.Content)
+		entry.Content = content
+		entry.Extensions = putExtension(entry.Extensions, v3EntryFields{Decorators: decorators})
+		converted.Entries[i] = entry
+	}
+	if book.Entries == nil {
+		converted.Entries = nil
+	}
+	return &converted
+}
+
+// putExtension returns a copy of ext with fields stored under v3Extension, or ext itself if
+// fields are all empty.
+func putExtension(ext models.Extensions, fields any) models.Extensions {
+	encoded, err := json.Marshal(fields)
+	if err != nil || string(encoded) == "{}" {
+		return ext
+	}
+	var value map[string]interface{}
+	if err := json.Unmarshal(encoded, &value); err != nil {
+		return ext
+	}
+	copied := maps.Clone(ext)
+	if copied == nil {
+		copied = make(models.Extensions)
+	}
+	copied[v3Extension] = value
+	return copied
+}
+
+// takeUnknown decodes the members of unknown that name V3 fields into fields, returning a copy
+// of unknown without them.
+func takeUnknown(unknown models.UnknownFields, fields *v3Fields) models.UnknownFields {
+	if len(unknown) == 0 {
+		return unknown
+	}
+	encoded, err := json.Marshal(unknown)
+	if err != nil || json.Unmarshal(encoded, fields) != nil {
+		return unknown
+	}
+	rest := maps.Clone(unknown)
+	t := reflect.TypeOf(*fields)
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		delete(rest, name)
+	}
+	if len(rest) == 0 {
+		return nil
+	}
+	return rest
+}
+
+// takeExtension decodes what putExtension stored in ext into fields, returning a copy of ext
+// without it. ext is returned unchanged if it holds nothing under v3Extension.
+func takeExtension(ext models.Extensions, fields any) models.Extensions {
+	value, ok := ext[v3Extension]
+	if !ok {
+		return ext
+	}
+	if encoded, err := json.Marshal(value); err == nil {
+		_ = json.Unmarshal(encoded, fields)
+	}
+	copied := maps.Clone(ext)
+	delete(copied, v3Extension)
+	if len(copied) == 0 {
+		return nil
+	}
+	return copied
+}
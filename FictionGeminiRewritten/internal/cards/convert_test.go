@@ -0,0 +1,207 @@
+package cards
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"workspace/FictionGeminiRewritten/internal/models"
+)
+
+func v2Card() models.CharacterCardV2 {
+	return models.CharacterCardV2{
+		Spec:        models.CardSpecV2,
+		SpecVersion: models.CardSpecVersionV2,
+		Data: models.CardData{
+			Name:               "The Narrator of Dune",
+			Description:        "Keeper of Arrakis' story.",
+			Personality:        "Measured, ominous.",
+			Scenario:           "The spice must flow.",
+			FirstMes:           "The sands shift.",
+			MesExample:         "<START>\n{{char}}: Listen.",
+			AlternateGreetings: []string{"Dawn breaks over the Shield Wall."},
+			Tags:               []string{"narrator", "dune"},
+			Tropes:             []string{"chosen one"},
+			CharacterBook: &models.Lorebook{
+				Name:    "Arrakis",
+				Enabled: true,
+				Entries: []models.LorebookEntry{
+					{Keys: []string{"spice", "melange"}, Content: "The spice extends life.", Enabled: true, InsertionOrder: 1},
+					{Keys: []string{"^Fremen$"}, Content: "Desert people.", Enabled: true, UseRegex: true, Extensions: models.Extensions{"weight": 2.0}},
+				},
+			},
+			Extensions: models.Extensions{"fiction_gemini": map[string]interface{}{"prompt_versions": map[string]interface{}{"narrator_card": "1.0"}}},
+			Unknown:    models.UnknownFields{"depth_prompt": json.RawMessage(`{"depth":4,"prompt":"Stay grim."}`)},
+		},
+		Extensions: models.Extensions{"talkativeness": "0.5"},
+	}
+}
+
+func v3Card() models.CharacterCardV3 {
+	card := V2ToV3(v2Card())
+	card.Data.Nickname = "Narrator"
+	card.Data.CreatorNotesMultilingual = map[string]string{"en": "Use with the master lorebook.", "fr": "À utiliser avec le lorebook."}
+	card.Data.Source = []string{"https://example.com/cards/42"}
+	card.Data.GroupOnlyGreetings = []string{"The council convenes."}
+	card.Data.CreationDate = 1760000000
+	card.Data.ModificationDate = 1760600000
+	card.Data.Assets = []models.CardAsset{{Type: "icon", URI: "ccdefault:", Name: "main", Ext: "png"}}
+	card.Data.CharacterBook.Entries[0].Content = "@@depth 4\n@@@position after_char\nThe spice extends life."
+	card.Data.CharacterBook.Entries[1].Content = "@@dont_activate"
+	return card
+}
+
+// jsonOf marshals v, failing the test if it can't.
+func jsonOf(t *testing.T, v any) string {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	return string(data)
+}
+
+func TestV2ToV3ToV2IsLossless(t *testing.T) {
+	original := v2Card()
+	want := jsonOf(t, original)
+
+	got := jsonOf(t, V3ToV2(V2ToV3(original)))
+	if got != want {
+		t.Errorf("V2 -> V3 -> V2 changed the card:\n got %s\nwant %s", got, want)
+	}
+	if after := jsonOf(t, original); after != want {
+		t.Errorf("conversion modified its input:\n got %s\nwant %s", after, want)
+	}
+}
+
+func TestV3ToV2ToV3IsLossless(t *testing.T) {
+	original := v3Card()
+	want := jsonOf(t, original)
+
+	got := jsonOf(t, V2ToV3(V3ToV2(original)))
+	if got != want {
+		t.Errorf("V3 -> V2 -> V3 changed the card:\n got %s\nwant %s", got, want)
+	}
+	if after := jsonOf(t, original); after != want {
+		t.Errorf("conversion modified its input:\n got %s\nwant %s", after, want)
+	}
+}
+
+func TestV3ToV2SurvivesJSON(t *testing.T) {
+	// A V2 card saved to disk and read back must still convert back to the same V3 card.
+	original := v3Card()
+	var saved models.CharacterCardV2
+	if err := json.Unmarshal([]byte(jsonOf(t, V3ToV2(original))), &saved); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if got, want := jsonOf(t, V2ToV3(saved)), jsonOf(t, original); got != want {
+		t.Errorf("V3 -> V2 -> JSON -> V3 changed the card:\n got %s\nwant %s", got, want)
+	}
+}
+
+func TestThirdPartyCardSurvivesRoundTrip(t *testing.T) {
+	// Members our types don't know, at every level, must come back out after decoding,
+	// converting to V3 and back, and encoding again.
+	const input = `{"spec":"chara_card_v2","spec_version":"2.0","data":{"name":"Paul","description":"","personality":"","scenario":"","first_mes":"","mes_example":"",` +
+		`"character_book":{"insertion_order":0,"enabled":true,"entries":[{"keys":["spice"],"content":"The spice.","enabled":true,"insertion_order":0,"id":3,"position":"before_char"}],"scan_mode":"deep"},` +
+		`"depth_prompt":{"depth":4}},"create_date":"2024-01-01"}`
+	var card models.CharacterCardV2
+	if err := json.Unmarshal([]byte(input), &card); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if got := jsonOf(t, V3ToV2(V2ToV3(card))); got != input {
+		t.Errorf("unknown members were lost:\n got %s\nwant %s", got, input)
+	}
+}
+
+func TestV2ToV3TakesUnknownV3Fields(t *testing.T) {
+	var card models.CharacterCardV2
+	if err := json.Unmarshal([]byte(`{"spec":"chara_card_v2","data":{"name":"Paul","nickname":"Usul","source":["x"],"talkativeness":"0.4"}}`), &card); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	v3 := V2ToV3(card)
+	if v3.Data.Nickname != "Usul" || len(v3.Data.Source) != 1 {
+		t.Errorf("V3 fields not taken from unknown members: %s", jsonOf(t, v3.Data))
+	}
+	if _, ok := v3.Data.Unknown["talkativeness"]; !ok || len(v3.Data.Unknown) != 1 {
+		t.Errorf("unknown members = %s, want only talkativeness", jsonOf(t, v3.Data.Unknown))
+	}
+}
+
+func TestV2ToV3(t *testing.T) {
+	card := V2ToV3(v2Card())
+	if card.Spec != "chara_card_v3" || card.SpecVersion != "3.0" {
+		t.Errorf("spec = %q %q, want chara_card_v3 3.0", card.Spec, card.SpecVersion)
+	}
+	if card.Data.GroupOnlyGreetings == nil || len(card.Data.GroupOnlyGreetings) != 0 {
+		t.Errorf("group_only_greetings = %#v, want an empty list", card.Data.GroupOnlyGreetings)
+	}
+	if card.Data.Name != "The Narrator of Dune" || card.Data.CharacterBook.Entries[1].UseRegex != true {
+		t.Errorf("V2 fields were not carried over: %s", jsonOf(t, card))
+	}
+	if _, ok := card.Data.Extensions[v3Extension]; ok {
+		t.Errorf("data.extensions has %q after converting a plain V2 card", v3Extension)
+	}
+}
+
+func TestV3ToV2(t *testing.T) {
+	card := V3ToV2(v3Card())
+	if card.Spec != "chara_card_v2" || card.SpecVersion != "2.0" {
+		t.Errorf("spec = %q %q, want chara_card_v2 2.0", card.Spec, card.SpecVersion)
+	}
+
+	kept, ok := card.Data.Extensions[v3Extension].(map[string]interface{})
+	if !ok {
+		t.Fatalf("data.extensions.%s missing: %s", v3Extension, jsonOf(t, card.Data.Extensions))
+	}
+	if kept["nickname"] != "Narrator" || kept["creation_date"] != float64(1760000000) {
+		t.Errorf("V3 fields not kept: %s", jsonOf(t, kept))
+	}
+	if _, ok := card.Data.Extensions["fiction_gemini"]; !ok {
+		t.Errorf("existing data.extensions were dropped: %s", jsonOf(t, card.Data.Extensions))
+	}
+
+	entries := card.Data.CharacterBook.Entries
+	if entries[0].Content != "The spice extends life." || entries[1].Content != "" {
+		t.Errorf("decorators not cut from content: %q, %q", entries[0].Content, entries[1].Content)
+	}
+	want := map[string]interface{}{"decorators": []string{"@@depth 4", "@@@position after_char"}}
+	if got := entries[0].Extensions[v3Extension]; jsonOf(t, got) != jsonOf(t, want) {
+		t.Errorf("entry extensions = %s, want %s", jsonOf(t, got), jsonOf(t, want))
+	}
+}
+
+func TestSplitAndJoinDecorators(t *testing.T) {
+	tests := []struct {
+		content    string
+		decorators []string
+		rest       string
+	}{
+		{"No decorators.", nil, "No decorators."},
+		{"@@depth 4\nText", []string{"@@depth 4"}, "Text"},
+		{"@@depth 4\n@@@role system\nLine 1\n@@not_a_decorator", []string{"@@depth 4", "@@@role system"}, "Line 1\n@@not_a_decorator"},
+		{"@@dont_activate", []string{"@@dont_activate"}, ""},
+		{"", nil, ""},
+	}
+	for _, tt := range tests {
+		decorators, rest := SplitDecorators(tt.content)
+		if !reflect.DeepEqual(decorators, tt.decorators) || rest != tt.rest {
+			t.Errorf("SplitDecorators(%q) = %q, %q; want %q, %q", tt.content, decorators, rest, tt.decorators, tt.rest)
+		}
+		if joined := JoinDecorators(decorators, rest); joined != tt.content {
+			t.Errorf("JoinDecorators(%q, %q) = %q, want %q", decorators, rest, joined, tt.content)
+		}
+	}
+}
+
+func TestParseDecorators(t *testing.T) {
+	got := ParseDecorators([]string{"@@depth 4", "@@@position after_char", "@@@role system", "@@dont_activate", "@@scan_depth  5 "})
+	want := []Decorator{
+		{Name: "depth", Value: "4", Fallbacks: []Decorator{{Name: "position", Value: "after_char"}, {Name: "role", Value: "system"}}},
+		{Name: "dont_activate"},
+		{Name: "scan_depth", Value: "5"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseDecorators = %+v, want %+v", got, want)
+	}
+}
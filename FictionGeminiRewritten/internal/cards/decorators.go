@@ -0,0 +1,59 @@
+package cards
+
+import "strings"
+
+// Decorator is one V3 lorebook decorator, e.g. "@@depth 4" or "@@dont_activate".
+type Decorator struct {
+	Name  string
+	Value string // Empty for decorators without an argument
+
+	// Fallbacks are the "@@@" lines that follow the decorator, to be applied in order
+	// instead of it by readers that don't support it.
+	Fallbacks []Decorator
+}
+
+// SplitDecorators cuts the decorator lines from the top of a lorebook entry's content,
+// returning them and the rest of the content. Decorators only count before the first line
+// that isn't one.
+func SplitDecorators(content string) ([]string, string) {
+	var decorators []string
+	rest := content
+	for strings.HasPrefix(rest, "@@") {
+		line, after, found := strings.Cut(rest, "\n")
+		decorators = append(decorators, line)
+		if !found {
+			return decorators, ""
+		}
+		rest = after
+	}
+	return decorators, rest
+}
+
+// JoinDecorators puts decorator lines back at the top of content, undoing SplitDecorators.
+func JoinDecorators(decorators []string, content string) string {
+	if len(decorators) == 0 {
+		return content
+	}
+	if content == "" {
+		return strings.Join(decorators, "\n")
+	}
+	return strings.Join(decorators, "\n") + "\n" + content
+}
+
+// ParseDecorators parses decorator lines as returned by SplitDecorators. A fallback line with
+// no decorator before it is treated as a decorator of its own.
+func ParseDecorators(lines []string) []Decorator {
+	var decorators []Decorator
+	for _, line := range lines {
+		fallback := strings.HasPrefix(line, "@@@")
+		name, value, _ := strings.Cut(strings.TrimLeft(line, "@"), " ")
+		d := Decorator{Name: strings.TrimSpace(name), Value: strings.TrimSpace(value)}
+		if fallback && len(decorators) > 0 {
+			last := &decorators[len(decorators)-1]
+			last.Fallbacks = append(last.Fallbacks, d)
+			continue
+		}
+		decorators = append(decorators, d)
+	}
+	return decorators
+}
@@ -0,0 +1,621 @@
+package cards
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"workspace/FictionGeminiRewritten/internal/ai"
+	"workspace/FictionGeminiRewritten/internal/models"
+)
+
+// ImportedName is the name given to an imported card that has none.
+const ImportedName = "Imported Character"
+
+// v1Fields are the fields of a V1 card, which V2 moved under data.
+var v1Fields = []string{"name", "description", "personality", "scenario", "first_mes", "mes_example"}
+
+// v1Copies are the members SillyTavern writes at the top level of V2 and V3 cards too, for V1
+// readers. They are dropped on import, as stale copies of data's fields.
+var v1Copies = append(slices.Clone(v1Fields), "creatorcomment", "tags", "avatar", "chat")
+
+// listFields are []string fields whose value, when given as a single string, is a
+// comma-separated list rather than one item.
+var listFields = []string{"keys", "secondaryKeys", "tags", "tropes"}
+
+// Import reads a character card from a PNG image or a JSON document, written in the V1, V2 or
+// V3 spec, and normalizes it to a V2 card. The report lists every field that was upgraded to
+// fit the V2 model, filled in with a default, or dropped. V3 cards are converted with V3ToV2,
+// so their V3 fields are kept; members the models have no field for are kept as unknown
+// members.
+func Import(data []byte) (models.CharacterCardV2, models.ImportReport, error) {
+	im := &importer{report: models.ImportReport{
+		Source:    models.ImportSourceJSON,
+		Upgraded:  []models.ImportChange{},
+		Defaulted: []models.ImportChange{},
+		Dropped:   []models.ImportChange{},
+	}}
+	if bytes.HasPrefix(data, pngSignature) {
+		im.report.Source = models.ImportSourcePNG
+		text, err := im.pngCard(data)
+		if err != nil {
+			return models.CharacterCardV2{}, im.report, err
+		}
+		data = text
+	}
+	card, err := im.card(data)
+	return card, im.report, err
+}
+
+// importer normalizes one card, recording what it changed.
+type importer struct {
+	report models.ImportReport
+}
+
+func (im *importer) upgraded(field, format string, args ...any) {
+	im.report.Upgraded = append(im.report.Upgraded, models.ImportChange{Field: field, Detail: fmt.Sprintf(format, args...)})
+}
+
+func (im *importer) defaulted(field, format string, args ...any) {
+	im.report.Defaulted = append(im.report.Defaulted, models.ImportChange{Field: field, Detail: fmt.Sprintf(format, args...)})
+}
+
+func (im *importer) dropped(field, format string, args ...any) {
+	im.report.Dropped = append(im.report.Dropped, models.ImportChange{Field: field, Detail: fmt.Sprintf(format, args...)})
+}
+
+// pngCard returns the card JSON embedded in a PNG image. A ccv3 chunk is preferred to a chara
+// chunk, which V3 writers only add for V2 readers.
+func (im *importer) pngCard(data []byte) ([]byte, error) {
+	chunks, err := readPNGChunks(data)
+	if err != nil {
+		return nil, err
+	}
+	found := map[string][]byte{}
+	for _, chunk := range chunks {
+		keyword, ok := textKeyword(chunk)
+		if !ok || (keyword != PNGKeywordV2 && keyword != PNGKeywordV3) {
+			continue
+		}
+		if _, seen := found[keyword]; seen {
+			im.dropped("png:"+keyword, "more than one %s chunk; only the first is read", keyword)
+			continue
+		}
+		text, err := chunkText(chunk)
+		if err != nil {
+			return nil, fmt.Errorf("PNG chunk %s: %w", keyword, err)
+		}
+		found[keyword] = text
+	}
+
+	v2, hasV2 := found[PNGKeywordV2]
+	if v3, ok := found[PNGKeywordV3]; ok {
+		card, err := decodeCardText(v3)
+		if err == nil {
+			if hasV2 {
+				im.dropped("png:"+PNGKeywordV2, "V2 copy of the card; the ccv3 chunk is read instead")
+			}
+			return card, nil
+		}
+		if !hasV2 {
+			return nil, fmt.Errorf("PNG chunk %s: %w", PNGKeywordV3, err)
+		}
+		im.dropped("png:"+PNGKeywordV3, "unreadable (%v); the chara chunk is read instead", err)
+	}
+	if hasV2 {
+		card, err := decodeCardText(v2)
+		if err != nil {
+			return nil, fmt.Errorf("PNG chunk %s: %w", PNGKeywordV2, err)
+		}
+		return card, nil
+	}
+	return nil, errors.New("PNG image has no chara or ccv3 chunk")
+}
+
+// chunkText returns the text of a tEXt, zTXt or iTXt chunk, decompressed if need be.
+func chunkText(chunk pngChunk) ([]byte, error) {
+	_, rest, _ := bytes.Cut(chunk.Data, []byte{0})
+	compressed := false
+	switch chunk.Type {
+	case "zTXt":
+		if len(rest) < 1 {
+			return nil, errors.New("truncated zTXt chunk")
+		}
+		rest, compressed = rest[1:], true
+	case "iTXt":
+		// Compression flag and method, then the language tag and translated keyword.
+		if len(rest) < 2 {
+			return nil, errors.New("truncated iTXt chunk")
+		}
+		compressed = rest[0] == 1
+		fields := bytes.SplitN(rest[2:], []byte{0}, 3)
+		if len(fields) < 3 {
+			return nil, errors.New("truncated iTXt chunk")
+		}
+		rest = fields[2]
+	}
+	if !compressed {
+		return rest, nil
+	}
+	r, err := zlib.NewReader(bytes.NewReader(rest))
+	if err != nil {
+		return nil, fmt.Errorf("bad compressed text: %w", err)
+	}
+	defer r.Close()
+	text, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("bad compressed text: %w", err)
+	}
+	return text, nil
+}
+
+// decodeCardText decodes the text of a card chunk: base64-encoded JSON, or, as some writers
+// store it, plain JSON.
+func decodeCardText(text []byte) ([]byte, error) {
+	text = bytes.TrimSpace(text)
+	if bytes.HasPrefix(text, []byte("{")) {
+		return text, nil
+	}
+	decoded, err := base64.StdEncoding.DecodeString(string(text))
+	if err != nil {
+		decoded, err = base64.RawStdEncoding.DecodeString(strings.TrimRight(string(text), "="))
+	}
+	if err != nil {
+		return nil, errors.New("not base64-encoded JSON")
+	}
+	return decoded, nil
+}
+
+// card normalizes a card's JSON, telling the spec it was written in from its spec field, or,
+// if it has none, from its shape.
+func (im *importer) card(data []byte) (models.CharacterCardV2, error) {
+	decoder := json.NewDecoder(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))))
+	decoder.UseNumber() // Keeps numbers in unknown members exactly as written
+	var raw map[string]any
+	if err := decoder.Decode(&raw); err != nil {
+		return models.CharacterCardV2{}, fmt.Errorf("not a JSON card: %w", err)
+	}
+	if raw == nil {
+		return models.CharacterCardV2{}, errors.New("not a JSON card: the document is null")
+	}
+
+	spec, isString := raw["spec"].(string)
+	_, hasData := raw["data"].(map[string]any)
+	switch {
+	case spec == models.CardSpecV3:
+		im.report.Spec = models.CardSpecV3
+		im.normalize(raw, reflect.TypeOf(models.CharacterCardV3{}), models.CardSpecVersionV3)
+		var card models.CharacterCardV3
+		if err := remarshal(raw, &card); err != nil {
+			return models.CharacterCardV2{}, err
+		}
+		return V3ToV2(card), nil
+	case spec == models.CardSpecV2:
+		im.report.Spec = models.CardSpecV2
+	case raw["spec"] != nil:
+		if !isString {
+			return models.CharacterCardV2{}, errors.New("card spec is not a string")
+		}
+		return models.CharacterCardV2{}, fmt.Errorf("unsupported card spec '%s' (use %s or %s)", spec, models.CardSpecV2, models.CardSpecV3)
+	case hasData:
+		im.report.Spec = models.CardSpecV2
+		im.defaulted("spec", "missing; the card has a data object, so it is read as %s", models.CardSpecV2)
+		raw["spec"] = models.CardSpecV2
+	case slices.ContainsFunc(v1Fields, func(field string) bool { return raw[field] != nil }):
+		im.report.Spec = models.CardSpecV1
+		raw = im.upgradeV1(raw)
+	default:
+		return models.CharacterCardV2{}, errors.New("not a character card: it has no spec, data or name")
+	}
+
+	im.normalize(raw, reflect.TypeOf(models.CharacterCardV2{}), models.CardSpecVersionV2)
+	var card models.CharacterCardV2
+	if err := remarshal(raw, &card); err != nil {
+		return models.CharacterCardV2{}, err
+	}
+	return card, nil
+}
+
+// upgradeV1 moves a V1 card's fields under data. creatorcomment becomes creator_notes; other
+// members are kept in data's extensions, except SillyTavern's references to the avatar and
+// chat it stored the card with.
+func (im *importer) upgradeV1(raw map[string]any) map[string]any {
+	data := map[string]any{}
+	extensions := map[string]any{}
+	for _, key := range slices.Sorted(maps.Keys(raw)) {
+		value := raw[key]
+		switch key {
+		case "name", "description", "personality", "scenario", "first_mes", "mes_example", "tags":
+			data[key] = value
+		case "creatorcomment":
+			data["creator_notes"] = value
+			im.upgraded(key, "moved to data.creator_notes")
+		case "avatar", "chat":
+			im.dropped(key, "SillyTavern's local %s reference, meaningless outside the app that exported the card", key)
+		default:
+			extensions[key] = value
+			im.upgraded(key, "moved to data.extensions.%s", key)
+		}
+	}
+	if len(extensions) > 0 {
+		data["extensions"] = extensions
+	}
+	im.upgraded("spec", "V1 card upgraded to %s; its fields moved under data", models.CardSpecV2)
+	return map[string]any{"spec": models.CardSpecV2, "spec_version": models.CardSpecVersionV2, "data": data}
+}
+
+// normalize fixes a V2 or V3 card, of type cardType, in place: fields of the wrong type are
+// converted or dropped, and missing required fields get defaults.
+func (im *importer) normalize(raw map[string]any, cardType reflect.Type, specVersion string) {
+	if _, ok := raw["spec_version"].(string); !ok {
+		im.defaulted("spec_version", "set to %s", specVersion)
+		raw["spec_version"] = specVersion
+	}
+	var copies []string
+	for _, key := range v1Copies {
+		if _, ok := raw[key]; ok {
+			copies = append(copies, key)
+			delete(raw, key)
+		}
+	}
+	if len(copies) > 0 {
+		im.dropped(strings.Join(copies, ", "), "top-level V1 copies of the card's data")
+	}
+	data, ok := raw["data"].(map[string]any)
+	if !ok {
+		if raw["data"] != nil {
+			im.dropped("data", "expected an object, got %s", describeValue(raw["data"]))
+		}
+		data = map[string]any{}
+		raw["data"] = data
+	}
+
+	im.coerce(raw, cardType, "")
+
+	for _, field := range v1Fields {
+		if data[field] == nil {
+			im.defaulted("data."+field, "missing; set to an empty string")
+			data[field] = ""
+		}
+	}
+	if name, _ := data["name"].(string); strings.TrimSpace(name) == "" {
+		im.defaulted("data.name", "empty; set to '%s'", ImportedName)
+		data["name"] = ImportedName
+	}
+	if cardType == reflect.TypeOf(models.CharacterCardV3{}) && data["group_only_greetings"] == nil {
+		im.defaulted("data.group_only_greetings", "missing; set to an empty list")
+		data["group_only_greetings"] = []any{}
+	}
+	if book, ok := data["character_book"].(map[string]any); ok {
+		im.normalizeLorebook(book, "data.character_book")
+	}
+}
+
+// normalizeLorebook fills in the fields lorebook entries require, and the lorebook's own
+// enabled flag, which the spec leaves out but the models need.
+func (im *importer) normalizeLorebook(book map[string]any, path string) {
+	if book["enabled"] == nil {
+		im.defaulted(path+".enabled", "missing; set to true")
+		book["enabled"] = true
+	}
+	entries, _ := book["entries"].([]any)
+	if entries == nil {
+		im.defaulted(path+".entries", "missing; set to an empty list")
+		book["entries"] = []any{}
+	}
+	for i, value := range entries {
+		entry, ok := value.(map[string]any)
+		if !ok {
+			continue
+		}
+		field := fmt.Sprintf("%s.entries[%d]", path, i)
+		if entry["secondaryKeys"] == nil && entry["secondary_keys"] != nil {
+			// The spec's name; the models use SillyTavern's.
+			keys, ok := im.coerceValue(entry["secondary_keys"], reflect.TypeOf([]string{}), "secondaryKeys", field+".secondary_keys")
+			if ok {
+				entry["secondaryKeys"] = keys
+				delete(entry, "secondary_keys")
+				im.upgraded(field+".secondary_keys", "moved to secondaryKeys")
+			}
+		}
+		if entry["keys"] == nil {
+			im.defaulted(field+".keys", "missing; set to an empty list")
+			entry["keys"] = []any{}
+		}
+		if entry["content"] == nil {
+			im.defaulted(field+".content", "missing; set to an empty string")
+			entry["content"] = ""
+		}
+		if entry["enabled"] == nil {
+			im.defaulted(field+".enabled", "missing; set to true")
+			entry["enabled"] = true
+		}
+		if entry["insertion_order"] == nil {
+			im.defaulted(field+".insertion_order", "missing; set to %d, the entry's position", i)
+			entry["insertion_order"] = i
+		}
+		im.checkEntryValues(entry, field)
+	}
+}
+
+// entrySchema holds the values lorebook entries may take, from the schema tags of the models
+// that generated lorebooks are validated against too.
+var entrySchema = ai.SchemaFor(models.LorebookEntry{})
+
+// checkEntryValues drops the settings of a lorebook entry whose values the models don't allow.
+// Settings are found whatever the case of their names, as encoding/json matches them, and
+// values that only differ in case from an allowed one are read as the one they match.
+func (im *importer) checkEntryValues(entry map[string]any, field string) {
+	keys := slices.Sorted(maps.Keys(entry))
+	for _, name := range entrySchema.Order {
+		schema := entrySchema.Properties[name]
+		for _, key := range keys {
+			if strings.EqualFold(key, name) && !im.allowedEntryValue(entry, key, schema, field+"."+key) {
+				delete(entry, key)
+			}
+		}
+	}
+}
+
+// allowedEntryValue reports whether the value of entry[key] is allowed by schema, reporting it
+// as dropped if not. An enum value that only differs in case is replaced by the one it matches.
+func (im *importer) allowedEntryValue(entry map[string]any, key string, schema *ai.Schema, field string) bool {
+	switch v := entry[key].(type) {
+	case string:
+		if len(schema.Enum) == 0 || slices.Contains(schema.Enum, v) {
+			return true
+		}
+		if i := slices.IndexFunc(schema.Enum, func(allowed string) bool { return strings.EqualFold(allowed, v) }); i >= 0 {
+			im.upgraded(field, "%q read as %q", v, schema.Enum[i])
+			entry[key] = schema.Enum[i]
+			return true
+		}
+		im.dropped(field, "%q is not one of %s", v, strings.Join(schema.Enum, ", "))
+		return false
+	case json.Number, int64: // int64 if rounded by coerce
+		n, _ := strconv.ParseFloat(fmt.Sprint(v), 64)
+		switch {
+		case schema.Minimum != nil && n < *schema.Minimum:
+			im.dropped(field, "%v is less than the minimum of %g", v, *schema.Minimum)
+		case schema.Maximum != nil && n > *schema.Maximum:
+			im.dropped(field, "%v is greater than the maximum of %g", v, *schema.Maximum)
+		default:
+			return true
+		}
+		return false
+	}
+	return true
+}
+
+// coerce converts the members of obj that have the wrong JSON type for the field of t they
+// decode into, and drops those that can't be converted. Unknown members are left alone.
+func (im *importer) coerce(obj map[string]any, t reflect.Type, path string) {
+	fields := jsonFields(t)
+	for _, key := range slices.Sorted(maps.Keys(obj)) {
+		fieldType, ok := fields[strings.ToLower(key)]
+		value := obj[key]
+		if !ok || value == nil {
+			continue
+		}
+		field := key
+		if path != "" {
+			field = path + "." + key
+		}
+		if coerced, ok := im.coerceValue(value, fieldType, key, field); ok {
+			obj[key] = coerced
+			continue
+		}
+		delete(obj, key)
+		im.dropped(field, "expected %s, got %s", describeType(fieldType), describeValue(value))
+	}
+}
+
+// coerceValue converts value, the member name at field, to the JSON type that decodes into t.
+// It returns false if it can't.
+func (im *importer) coerceValue(value any, t reflect.Type, name, field string) (any, bool) {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return im.coerceValue(value, t.Elem(), name, field)
+	case reflect.String:
+		switch v := value.(type) {
+		case string:
+			return v, true
+		case json.Number:
+			im.upgraded(field, "number %s read as a string", v)
+			return v.String(), true
+		case bool:
+			im.upgraded(field, "boolean %t read as a string", v)
+			return strconv.FormatBool(v), true
+		}
+	case reflect.Bool:
+		switch v := value.(type) {
+		case bool:
+			return v, true
+		case string:
+			if b, err := strconv.ParseBool(strings.TrimSpace(v)); err == nil {
+				im.upgraded(field, "string %q read as %t", v, b)
+				return b, true
+			}
+		case json.Number:
+			if f, err := v.Float64(); err == nil {
+				im.upgraded(field, "number %s read as %t", v, f != 0)
+				return f != 0, true
+			}
+		}
+	case reflect.Int, reflect.Int64:
+		var f float64
+		var err error
+		switch v := value.(type) {
+		case json.Number:
+			if _, intErr := v.Int64(); intErr == nil {
+				return v, true
+			}
+			f, err = v.Float64()
+		case string:
+			f, err = strconv.ParseFloat(strings.TrimSpace(v), 64)
+		default:
+			err = errors.New("not a number")
+		}
+		if err == nil && !math.IsInf(f, 0) && !math.IsNaN(f) {
+			n := int64(math.Round(f))
+			im.upgraded(field, "%v read as the integer %d", value, n)
+			return n, true
+		}
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.String {
+			return im.coerceStrings(value, name, field)
+		}
+		items, ok := value.([]any)
+		if !ok {
+			break
+		}
+		kept := make([]any, 0, len(items))
+		for i, item := range items {
+			itemField := fmt.Sprintf("%s[%d]", field, i)
+			if coerced, ok := im.coerceValue(item, t.Elem(), name, itemField); ok {
+				kept = append(kept, coerced)
+			} else {
+				im.dropped(itemField, "expected %s, got %s", describeType(t.Elem()), describeValue(item))
+			}
+		}
+		return kept, true
+	case reflect.Map:
+		obj, ok := value.(map[string]any)
+		if !ok {
+			break
+		}
+		if t.Elem().Kind() == reflect.String {
+			for _, key := range slices.Sorted(maps.Keys(obj)) {
+				if coerced, ok := im.coerceValue(obj[key], t.Elem(), key, field+"."+key); ok {
+					obj[key] = coerced
+				} else {
+					im.dropped(field+"."+key, "expected a string, got %s", describeValue(obj[key]))
+					delete(obj, key)
+				}
+			}
+		}
+		return obj, true
+	case reflect.Struct:
+		obj, ok := value.(map[string]any)
+		if !ok {
+			break
+		}
+		im.coerce(obj, t, field)
+		return obj, true
+	}
+	return nil, false
+}
+
+// coerceStrings converts value to a list of strings. A single string is split on commas if the
+// field is a list of keywords or tags, and is otherwise taken as the only item.
+func (im *importer) coerceStrings(value any, name, field string) (any, bool) {
+	switch v := value.(type) {
+	case []any:
+		kept := make([]any, 0, len(v))
+		for i, item := range v {
+			itemField := fmt.Sprintf("%s[%d]", field, i)
+			if coerced, ok := im.coerceValue(item, reflect.TypeOf(""), name, itemField); ok {
+				kept = append(kept, coerced)
+			} else {
+				im.dropped(itemField, "expected a string, got %s", describeValue(item))
+			}
+		}
+		return kept, true
+	case string:
+		if !slices.Contains(listFields, name) {
+			im.upgraded(field, "single string read as a list of one")
+			return []any{v}, true
+		}
+		items := []any{}
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		im.upgraded(field, "comma-separated string read as a list of %d", len(items))
+		return items, true
+	}
+	return nil, false
+}
+
+// jsonFields maps the lowercased JSON names of t's fields, including those of embedded
+// structs, to their types.
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := map[string]reflect.Type{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			maps.Copy(fields, jsonFields(field.Type))
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields[strings.ToLower(name)] = field.Type
+	}
+	return fields
+}
+
+// describeType names the JSON type that decodes into t.
+func describeType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return describeType(t.Elem())
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int64:
+		return "an integer"
+	case reflect.Slice:
+		return "a list"
+	}
+	return "an object"
+}
+
+// describeValue names the JSON type of a decoded value.
+func describeValue(value any) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "a string"
+	case json.Number:
+		return "a number"
+	case bool:
+		return "a boolean"
+	case []any:
+		return "a list"
+	}
+	return "an object"
+}
+
+// remarshal decodes the normalized card into its type.
+func remarshal(raw map[string]any, card any) error {
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return fmt.Errorf("failed to encode card: %w", err)
+	}
+	if err := json.Unmarshal(data, card); err != nil {
+		return fmt.Errorf("card does not fit the %s model: %w", reflect.TypeOf(card).Elem().Name(), err)
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package cards
+
+import (
+	"slices"
+	"testing"
+
+	"workspace/FictionGeminiRewritten/internal/models"
+)
+
+// fields lists the fields of a report's changes.
+func fields(changes []models.ImportChange) []string {
+	var out []string
+	for _, change := range changes {
+		out = append(out, change.Field)
+	}
+	return out
+}
+
+func TestImportV1(t *testing.T) {
+	const input = `{"name":"Paul","description":"Heir of Atreides.","first_mes":"Hello.","creatorcomment":"Old card.","talkativeness":"0.5","avatar":"none","chat":"Paul - 2023"}`
+	card, report, err := Import([]byte(input))
+	if err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+	if report.Source != models.ImportSourceJSON || report.Spec != models.CardSpecV1 {
+		t.Errorf("source, spec = %q, %q; want json, %s", report.Source, report.Spec, models.CardSpecV1)
+	}
+	if card.Spec != models.CardSpecV2 || card.Data.Name != "Paul" || card.Data.FirstMes != "Hello." || card.Data.CreatorNotes != "Old card." {
+		t.Errorf("V1 fields not moved under data: %s", jsonOf(t, card))
+	}
+	if card.Data.Extensions["talkativeness"] != "0.5" {
+		t.Errorf("talkativeness not kept in data.extensions: %s", jsonOf(t, card.Data.Extensions))
+	}
+	if got := fields(report.Dropped); !slices.Equal(got, []string{"avatar", "chat"}) {
+		t.Errorf("dropped = %q, want avatar and chat", got)
+	}
+	for _, field := range []string{"data.personality", "data.scenario", "data.mes_example"} {
+		if !slices.Contains(fields(report.Defaulted), field) {
+			t.Errorf("defaulted = %q, missing %s", fields(report.Defaulted), field)
+		}
+	}
+}
+
+func TestImportCoercesFields(t *testing.T) {
+	const input = `{"spec":"chara_card_v2","data":{"name":42,"description":"","personality":"","scenario":"","first_mes":"","mes_example":"",` +
+		`"tags":"desert, spice","alternate_greetings":"Hi.","creator_notes":{"en":"x"},` +
+		`"character_book":{"scan_depth":"4","entries":[{"keys":"spice, melange","content":"The spice.","secondary_keys":["sand"],"priority":2.6,"constant":"true",` +
+		`"position":"At_Depth","selectiveLogic":"OR","probability":150,"depth":"0","group_weight":-5,"sticky":-2},"junk"]}}}`
+	card, report, err := Import([]byte(input))
+	if err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+	data := card.Data
+	if data.Name != "42" || !slices.Equal(data.Tags, []string{"desert", "spice"}) || !slices.Equal(data.AlternateGreetings, []string{"Hi."}) {
+		t.Errorf("fields not coerced: %s", jsonOf(t, data))
+	}
+	book := data.CharacterBook
+	if book.ScanDepth != 4 || !book.Enabled || len(book.Entries) != 1 {
+		t.Fatalf("lorebook not normalized: %s", jsonOf(t, book))
+	}
+	entry := book.Entries[0]
+	if !slices.Equal(entry.Keys, []string{"spice", "melange"}) || !slices.Equal(entry.SecondaryKeys, []string{"sand"}) ||
+		entry.Priority != 3 || !entry.Constant || !entry.Enabled || entry.InsertionOrder != 0 ||
+		entry.Position != models.LorebookPositionAtDepth || entry.SelectiveLogic != "" || entry.Probability != 0 || *entry.Depth != 0 ||
+		entry.GroupWeight != 0 || entry.Sticky != 0 {
+		t.Errorf("entry not normalized: %s", jsonOf(t, entry))
+	}
+	if got := fields(report.Dropped); !slices.Equal(got, []string{"data.character_book.entries[1]", "data.creator_notes",
+		"data.character_book.entries[0].selectiveLogic", "data.character_book.entries[0].probability",
+		"data.character_book.entries[0].group_weight", "data.character_book.entries[0].sticky"}) {
+		t.Errorf("dropped = %q", got)
+	}
+	if !slices.Contains(fields(report.Defaulted), "spec_version") {
+		t.Errorf("defaulted = %q, missing spec_version", fields(report.Defaulted))
+	}
+}
+
+func TestImportChecksEntryValuesWhateverTheirCase(t *testing.T) {
+	const input = `{"spec":"chara_card_v2","data":{"name":"Paul","character_book":{"entries":[` +
+		`{"keys":["spice"],"content":"The spice.","Position":"bogus","Probability":500,"SelectiveLogic":"not_any","Depth":3}]}}}`
+	card, report, err := Import([]byte(input))
+	if err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+	entry := card.Data.CharacterBook.Entries[0]
+	if entry.Position != "" || entry.Probability != 0 || entry.SelectiveLogic != models.SelectiveLogicNotAny || *entry.Depth != 3 {
+		t.Errorf("entry not checked: %s", jsonOf(t, entry))
+	}
+	if got := fields(report.Dropped); !slices.Equal(got, []string{"data.character_book.entries[0].Probability", "data.character_book.entries[0].Position"}) {
+		t.Errorf("dropped = %q", got)
+	}
+	if got := fields(report.Upgraded); !slices.Contains(got, "data.character_book.entries[0].SelectiveLogic") {
+		t.Errorf("upgraded = %q, missing SelectiveLogic", got)
+	}
+}
+
+func TestImportPNGPrefersV3(t *testing.T) {
+	v3 := v3Card()
+	data, err := EncodePNG(DefaultAvatar(), V3ToV2(v3), &v3)
+	if err != nil {
+		t.Fatalf("EncodePNG: %v", err)
+	}
+	card, report, err := Import(data)
+	if err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+	if report.Source != models.ImportSourcePNG || report.Spec != models.CardSpecV3 {
+		t.Errorf("source, spec = %q, %q; want png, %s", report.Source, report.Spec, models.CardSpecV3)
+	}
+	if got, want := jsonOf(t, V2ToV3(card)), jsonOf(t, v3); got != want {
+		t.Errorf("imported card differs:\n got %s\nwant %s", got, want)
+	}
+	if got := fields(report.Dropped); !slices.Equal(got, []string{"png:" + PNGKeywordV2}) {
+		t.Errorf("dropped = %q, want the chara chunk", got)
+	}
+}
+
+func TestImportRejectsOtherData(t *testing.T) {
+	for _, input := range []string{`[]`, `{"spec":"chara_card_v9","data":{}}`, `{"foo":1}`, `null`} {
+		if _, _, err := Import([]byte(input)); err == nil {
+			t.Errorf("Import(%s) succeeded", input)
+		}
+	}
+	if _, _, err := Import(DefaultAvatar()); err == nil {
+		t.Error("Import accepted a PNG without a card")
+	}
+}
@@ -0,0 +1,188 @@
+package cards
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	_ "image/gif"  // Avatars may be supplied as GIF
+	_ "image/jpeg" // or JPEG, and are converted to PNG
+	"image/png"
+	"strings"
+	"sync"
+
+	"workspace/FictionGeminiRewritten/internal/models"
+)
+
+// tEXt chunk keywords under which PNG cards carry their JSON, base64-encoded. V3 cards carry
+// both, so that readers that only know V2 can still load them.
+const (
+	PNGKeywordV2 = "chara"
+	PNGKeywordV3 = "ccv3"
+)
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// pngChunk is one chunk of a PNG file. The CRC is computed when the chunk is written.
+type pngChunk struct {
+	Type string
+	Data []byte
+}
+
+// EncodePNG embeds card into avatar, a PNG image, as a tEXt chunk named "chara". If v3 is
+// set, it is embedded too, as "ccv3". Card chunks already in avatar are replaced.
+func EncodePNG(avatar []byte, card models.CharacterCardV2, v3 *models.CharacterCardV3) ([]byte, error) {
+	chunks, err := readPNGChunks(avatar)
+	if err != nil {
+		return nil, err
+	}
+	kept := chunks[:0:0]
+	for _, chunk := range chunks {
+		if keyword, ok := textKeyword(chunk); ok && (keyword == PNGKeywordV2 || keyword == PNGKeywordV3) {
+			continue
+		}
+		kept = append(kept, chunk)
+	}
+
+	cardChunks := make([]pngChunk, 0, 2)
+	v2Chunk, err := textChunk(PNGKeywordV2, card)
+	if err != nil {
+		return nil, err
+	}
+	cardChunks = append(cardChunks, v2Chunk)
+	if v3 != nil {
+		v3Chunk, err := textChunk(PNGKeywordV3, v3)
+		if err != nil {
+			return nil, err
+		}
+		cardChunks = append(cardChunks, v3Chunk)
+	}
+
+	// IEND is always last; the cards go right before it.
+	iend := kept[len(kept)-1]
+	kept = append(append(kept[:len(kept)-1], cardChunks...), iend)
+	return writePNGChunks(kept), nil
+}
+
+// DecodeAvatar decodes an avatar sent with a request: a PNG, JPEG or GIF image, base64-encoded
+// and optionally written as a data URI. It returns the image as PNG; PNG images are returned
+// as they are.
+func DecodeAvatar(encoded string) ([]byte, error) {
+	if strings.HasPrefix(encoded, "data:") {
+		_, after, found := strings.Cut(encoded, ",")
+		if !found {
+			return nil, errors.New("avatar data URI has no data")
+		}
+		encoded = after
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("avatar is not valid base64: %w", err)
+	}
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("avatar is not a PNG, JPEG or GIF image: %w", err)
+	}
+	if format == "png" {
+		return data, nil
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("failed to convert avatar to PNG: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// DefaultAvatar returns the image cards are embedded into when no avatar was supplied: a plain
+// 400x600 gradient.
+var DefaultAvatar = sync.OnceValue(func() []byte {
+	const width, height = 400, 600
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	top, bottom := color.RGBA{R: 0x2b, G: 0x1d, B: 0x4e, A: 0xff}, color.RGBA{R: 0xc2, G: 0x7c, B: 0x3a, A: 0xff}
+	for y := 0; y < height; y++ {
+		mix := func(a, b uint8) uint8 { return uint8((int(a)*(height-1-y) + int(b)*y) / (height - 1)) }
+		row := color.RGBA{R: mix(top.R, bottom.R), G: mix(top.G, bottom.G), B: mix(top.B, bottom.B), A: 0xff}
+		for x := 0; x < width; x++ {
+			img.SetRGBA(x, y, row)
+		}
+	}
+	var buf bytes.Buffer
+	encoder := png.Encoder{CompressionLevel: png.BestCompression}
+	if err := encoder.Encode(&buf, img); err != nil {
+		panic(err) // Encoding an in-memory image can't fail
+	}
+	return buf.Bytes()
+})
+
+// textChunk encodes v as JSON, base64-encoded into a tEXt chunk named keyword.
+func textChunk(keyword string, v any) (pngChunk, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return pngChunk{}, fmt.Errorf("failed to encode card: %w", err)
+	}
+	text := keyword + "\x00" + base64.StdEncoding.EncodeToString(data)
+	return pngChunk{Type: "tEXt", Data: []byte(text)}, nil
+}
+
+// textKeyword returns the keyword of a tEXt, zTXt or iTXt chunk.
+func textKeyword(chunk pngChunk) (string, bool) {
+	if chunk.Type != "tEXt" && chunk.Type != "zTXt" && chunk.Type != "iTXt" {
+		return "", false
+	}
+	keyword, _, found := bytes.Cut(chunk.Data, []byte{0})
+	return string(keyword), found
+}
+
+// readPNGChunks splits a PNG file into its chunks, checking the signature and each CRC. The
+// last chunk is always IEND.
+func readPNGChunks(data []byte) ([]pngChunk, error) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, errors.New("not a PNG image")
+	}
+	var chunks []pngChunk
+	rest := data[len(pngSignature):]
+	for len(rest) > 0 {
+		if len(rest) < 12 {
+			return nil, errors.New("truncated PNG chunk")
+		}
+		length := binary.BigEndian.Uint32(rest)
+		if uint64(length) > uint64(len(rest)-12) {
+			return nil, errors.New("truncated PNG chunk")
+		}
+		chunkType := string(rest[4:8])
+		body := rest[8 : 8+length]
+		crc := binary.BigEndian.Uint32(rest[8+length:])
+		if crc32.ChecksumIEEE(rest[4:8+length]) != crc {
+			return nil, fmt.Errorf("PNG chunk %s has a bad CRC", chunkType)
+		}
+		chunks = append(chunks, pngChunk{Type: chunkType, Data: body})
+		rest = rest[12+length:]
+		if chunkType == "IEND" {
+			return chunks, nil
+		}
+	}
+	return nil, errors.New("PNG image has no IEND chunk")
+}
+
+// writePNGChunks assembles a PNG file from its chunks.
+func writePNGChunks(chunks []pngChunk) []byte {
+	var buf bytes.Buffer
+	buf.Write(pngSignature)
+	for _, chunk := range chunks {
+		var header [8]byte
+		binary.BigEndian.PutUint32(header[:4], uint32(len(chunk.Data)))
+		copy(header[4:], chunk.Type)
+		buf.Write(header[:])
+		buf.Write(chunk.Data)
+		crc := crc32.NewIEEE()
+		crc.Write(header[4:])
+		crc.Write(chunk.Data)
+		binary.Write(&buf, binary.BigEndian, crc.Sum32())
+	}
+	return buf.Bytes()
+}
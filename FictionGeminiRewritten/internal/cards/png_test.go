@@ -0,0 +1,90 @@
+package cards
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"image/png"
+	"testing"
+
+	"workspace/FictionGeminiRewritten/internal/models"
+)
+
+// embedded returns the JSON of each card chunk in a PNG, by keyword.
+func embedded(t *testing.T, data []byte) map[string][]string {
+	t.Helper()
+	chunks, err := readPNGChunks(data)
+	if err != nil {
+		t.Fatalf("readPNGChunks: %v", err)
+	}
+	if last := chunks[len(chunks)-1].Type; last != "IEND" {
+		t.Errorf("last chunk is %s, want IEND", last)
+	}
+	found := map[string][]string{}
+	for _, chunk := range chunks {
+		keyword, ok := textKeyword(chunk)
+		if !ok {
+			continue
+		}
+		decoded, err := base64.StdEncoding.DecodeString(string(chunk.Data[len(keyword)+1:]))
+		if err != nil {
+			t.Fatalf("chunk %s is not base64: %v", keyword, err)
+		}
+		found[keyword] = append(found[keyword], string(decoded))
+	}
+	return found
+}
+
+func TestEncodePNG(t *testing.T) {
+	card := v2Card()
+	data, err := EncodePNG(DefaultAvatar(), card, nil)
+	if err != nil {
+		t.Fatalf("EncodePNG: %v", err)
+	}
+	if _, err := png.Decode(bytes.NewReader(data)); err != nil {
+		t.Fatalf("result is not a valid PNG: %v", err)
+	}
+
+	found := embedded(t, data)
+	if len(found[PNGKeywordV2]) != 1 || found[PNGKeywordV2][0] != jsonOf(t, card) {
+		t.Errorf("chara chunks = %q, want the card", found[PNGKeywordV2])
+	}
+	if len(found[PNGKeywordV3]) != 0 {
+		t.Errorf("ccv3 chunk written for a V2 card")
+	}
+}
+
+func TestEncodePNGReplacesCards(t *testing.T) {
+	first, err := EncodePNG(DefaultAvatar(), v2Card(), nil)
+	if err != nil {
+		t.Fatalf("EncodePNG: %v", err)
+	}
+	v3 := v3Card()
+	v3.Data.Name = "Renamed"
+	second, err := EncodePNG(first, V3ToV2(v3), &v3)
+	if err != nil {
+		t.Fatalf("EncodePNG: %v", err)
+	}
+
+	found := embedded(t, second)
+	if len(found[PNGKeywordV2]) != 1 || len(found[PNGKeywordV3]) != 1 {
+		t.Fatalf("got %d chara and %d ccv3 chunks, want one of each", len(found[PNGKeywordV2]), len(found[PNGKeywordV3]))
+	}
+	var decoded models.CharacterCardV3
+	if err := json.Unmarshal([]byte(found[PNGKeywordV3][0]), &decoded); err != nil {
+		t.Fatalf("ccv3 chunk: %v", err)
+	}
+	if jsonOf(t, decoded) != jsonOf(t, v3) {
+		t.Errorf("ccv3 chunk = %s, want %s", jsonOf(t, decoded), jsonOf(t, v3))
+	}
+}
+
+func TestEncodePNGRejectsOtherData(t *testing.T) {
+	if _, err := EncodePNG([]byte("GIF89a"), v2Card(), nil); err == nil {
+		t.Error("EncodePNG accepted an image that isn't a PNG")
+	}
+	truncated := DefaultAvatar()[:100]
+	if _, err := EncodePNG(truncated, v2Card(), nil); err == nil {
+		t.Error("EncodePNG accepted a truncated PNG")
+	}
+}
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"workspace/FictionGeminiRewritten/internal/services"
+)
+
+// CardsHandler serves the PNG cards saved for a session: GET /cards/{id} lists them and
+// GET /cards/{id}/{file} downloads one.
+type CardsHandler struct{}
+
+// NewCardsHandler creates a new CardsHandler.
+func NewCardsHandler() *CardsHandler {
+	return &CardsHandler{}
+}
+
+func (h *CardsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, file := r.PathValue("id"), r.PathValue("file")
+	if file == "" {
+		list, err := services.SessionCards(id)
+		if err != nil {
+			writeCardError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, list)
+		return
+	}
+
+	path, err := services.CardPNGPath(id, file)
+	if err != nil {
+		writeCardError(w, err)
+		return
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		http.Error(w, "Card not found", http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		http.Error(w, "Card not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", file))
+	http.ServeContent(w, r, file, info.ModTime(), f)
+}
+
+// writeCardError answers 404 for sessions and cards that don't exist and 400 otherwise.
+func writeCardError(w http.ResponseWriter, err error) {
+	if errors.Is(err, services.ErrSessionNotFound) || errors.Is(err, services.ErrCardNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusBadRequest)
+}
@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"workspace/FictionGeminiRewritten/internal/services"
+)
+
+// EstimateHandler handles the /estimate endpoint. It accepts the same payload as /generate and
+// responds with the projected tokens and cost of each step, without generating anything.
+type EstimateHandler struct {
+	orchestrator *services.OrchestratorService
+}
+
+// NewEstimateHandler creates a new EstimateHandler.
+func NewEstimateHandler(orchestrator *services.OrchestratorService) *EstimateHandler {
+	return &EstimateHandler{orchestrator: orchestrator}
+}
+
+func (h *EstimateHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	payload, ok := decodeGenerateRequest(w, r)
+	if !ok {
+		return
+	}
+	log.Printf("Received /estimate request. Series: '%s', Option: %s, Model: %s", payload.Series, payload.Option, payload.Model)
+
+	estimate, err := h.orchestrator.Estimate(r.Context(), payload, payload.APIKey)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if encodeErr := json.NewEncoder(w).Encode(estimate); encodeErr != nil {
+		log.Printf("Failed to encode estimate: %v", encodeErr)
+	}
+}
@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"net/http"
+
+	"workspace/FictionGeminiRewritten/internal/services"
+)
+
+// ExperimentsHandler serves GET /experiments: the recorded outcomes of every session, summed up
+// per step and combination of prompt template versions. The "step" query parameter keeps one
+// step, and "experiments_only=true" counts only runs whose versions an experiment assigned.
+type ExperimentsHandler struct {
+	orchestrator *services.OrchestratorService
+}
+
+// NewExperimentsHandler creates a new ExperimentsHandler.
+func NewExperimentsHandler(orchestrator *services.OrchestratorService) *ExperimentsHandler {
+	return &ExperimentsHandler{orchestrator: orchestrator}
+}
+
+func (h *ExperimentsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	report, err := h.orchestrator.ExperimentReport(query.Get("step"), query.Get("experiments_only") == "true")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
@@ -2,24 +2,31 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
+	"workspace/FictionGeminiRewritten/internal/ai"
+	"workspace/FictionGeminiRewritten/internal/cards"
 	"workspace/FictionGeminiRewritten/internal/models"
 	"workspace/FictionGeminiRewritten/internal/services"
 )
 
-// GenerateHandler handles the /generate endpoint.
+// GenerateHandler handles the /generate endpoint. Requests with "async": true are queued on
+// the JobService and answered immediately with 202 Accepted and the job's status URL.
 type GenerateHandler struct {
 	orchestrator *services.OrchestratorService
+	jobs         *services.JobService
 }
 
 // NewGenerateHandler creates a new GenerateHandler.
-func NewGenerateHandler(orchestrator *services.OrchestratorService) *GenerateHandler {
-	return &GenerateHandler{orchestrator: orchestrator}
+func NewGenerateHandler(orchestrator *services.OrchestratorService, jobs *services.JobService) *GenerateHandler {
+	return &GenerateHandler{orchestrator: orchestrator, jobs: jobs}
 }
 
 func (h *GenerateHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -38,75 +45,277 @@ func (h *GenerateHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	payload, ok := decodeGenerateRequest(w, r)
+	if !ok {
+		return
+	}
+	if payload.DryRun {
+		servePreview(w, h.orchestrator, payload)
+		return
+	}
+
+	// Generate a unique log identifier for this request session, or continue the resumed one
+	logIdentifier := sessionLogIdentifier(payload)
+	log.Printf("Received /generate request. Log ID: %s, Series: '%s', Option: %s, Model: %s", 
+		logIdentifier, payload.Series, payload.Option, payload.Model)
+
+	if payload.Async {
+		h.submitJob(w, payload, logIdentifier)
+		return
+	}
+
+	ctx := r.Context() // Use request context
+	events := services.NewEventLog()
+	generatedJSON, messageLog, optionText, err := h.orchestrator.ProcessGenerationRequest(ctx, payload, logIdentifier, payload.APIKey, events)
+
+	response := buildResponse(payload, logIdentifier, generatedJSON, messageLog, optionText, events, err)
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		var aiErr *ai.Error
+		if errors.As(err, &aiErr) && aiErr.RetryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(aiErr.RetryAfter.Round(time.Second).Seconds())))
+		}
+		w.WriteHeader(statusForError(err))
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	if encodeErr := json.NewEncoder(w).Encode(response); encodeErr != nil {
+		log.Printf("Failed to encode response (Log ID: %s): %v", logIdentifier, encodeErr)
+		// http.Error already sent, or client will time out
+	}
+}
+
+// submitJob queues the request and responds with the new job's status.
+func (h *GenerateHandler) submitJob(w http.ResponseWriter, payload models.RequestPayload, logIdentifier string) {
+	job, err := h.jobs.Submit(payload, logIdentifier)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/jobs/"+job.ID())
+	w.WriteHeader(http.StatusAccepted)
+	if encodeErr := json.NewEncoder(w).Encode(job.Snapshot(false)); encodeErr != nil {
+		log.Printf("Failed to encode job status (Log ID: %s): %v", logIdentifier, encodeErr)
+	}
+}
+
+// decodeGenerateRequest decodes and validates a generation request body, writing a 4xx
+// response and returning false if it is unusable.
+func decodeGenerateRequest(w http.ResponseWriter, r *http.Request) (models.RequestPayload, bool) {
 	var payload models.RequestPayload
 	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
 		http.Error(w, fmt.Sprintf("Invalid request payload: %v", err), http.StatusBadRequest)
-		return
+		return payload, false
+	}
+
+	if payload.ResumeLogIdentifier != "" {
+		resumed, err := services.ResumePayload(payload)
+		if errors.Is(err, services.ErrCheckpointNotFound) {
+			http.Error(w, fmt.Sprintf("Cannot resume '%s': %v", payload.ResumeLogIdentifier, err), http.StatusNotFound)
+			return payload, false
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Cannot resume '%s': %v", payload.ResumeLogIdentifier, err), http.StatusBadRequest)
+			return payload, false
+		}
+		payload = resumed
 	}
 
 	// Basic Validations
-	if payload.APIKey == "" {
+	// Local OpenAI-compatible backends (e.g. "ollama:llama3") don't need a key; Gemini and hosted gateways do.
+	if payload.APIKey == "" && ai.RequiresAPIKey(payload.Model) {
 		http.Error(w, "API Key is missing", http.StatusBadRequest)
-		return
+		return payload, false
 	}
-	// In a real app, you'd validate the API key against a stored one, e.g., from env.
-	// For this rewrite, we'll assume it matches the one needed for the Gemini client if provided.
 
 	if strings.TrimSpace(payload.Series) == "" {
 		http.Error(w, "Series name is missing or empty", http.StatusBadRequest)
-		return
+		return payload, false
 	}
 	if payload.Option == "" {
 		http.Error(w, "Option is missing", http.StatusBadRequest)
-		return
+		return payload, false
 	}
 	if payload.Option == "3" && strings.TrimSpace(payload.ToolCardPurpose) == "" {
 		http.Error(w, "Tool Card Purpose is required for Option 3", http.StatusBadRequest)
-		return
+		return payload, false
+	}
+	if payload.LorebookMode != "" && payload.LorebookMode != models.LorebookModeSingle && payload.LorebookMode != models.LorebookModeFanout {
+		http.Error(w, fmt.Sprintf("Unknown lorebook_mode '%s' (use '%s' or '%s')", payload.LorebookMode, models.LorebookModeSingle, models.LorebookModeFanout), http.StatusBadRequest)
+		return payload, false
+	}
+	if payload.CardFormat != "" && payload.CardFormat != models.CardFormatV2 && payload.CardFormat != models.CardFormatV3 {
+		http.Error(w, fmt.Sprintf("Unknown card_format '%s' (use '%s' or '%s')", payload.CardFormat, models.CardFormatV2, models.CardFormatV3), http.StatusBadRequest)
+		return payload, false
+	}
+	if payload.Avatar != "" {
+		if _, err := cards.DecodeAvatar(payload.Avatar); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid avatar: %v", err), http.StatusBadRequest)
+			return payload, false
+		}
+	}
+	if _, err := services.LoadWorkspaceCards(payload.Series, payload.ContextCards); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid context_cards: %v", err), http.StatusBadRequest)
+		return payload, false
+	}
+	if err := validateGenerationSettings(payload); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return payload, false
+	}
+	if err := validateSafetySettings(payload.SafetySettings); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return payload, false
+	}
+	if err := validateModelRoutes(payload); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return payload, false
 	}
 	if payload.Model == "" {
-		// Default to a model if not provided, or could make it mandatory.
-		// For now, let's assume a default is handled by Gemini client or orchestrator if needed,
-		// but ideally, it should be validated or defaulted here.
-		// Based on original, it seems user must select one.
+		// Based on original, the user must select a model; there is no server-side default.
 		http.Error(w, "AI Model selection is missing", http.StatusBadRequest)
-		return
+		return payload, false
 	}
+	return payload, true
+}
 
-	// Generate a unique log identifier for this request session
-	logIdentifier := services.GenerateLogIdentifier(payload.Series)
-	log.Printf("Received /generate request. Log ID: %s, Series: '%s', Option: %s, Model: %s", 
-		logIdentifier, payload.Series, payload.Option, payload.Model)
+// validateGenerationSettings checks the request-wide and per-step generation parameters.
+func validateGenerationSettings(payload models.RequestPayload) error {
+	if payload.Generation != nil {
+		if err := validateGenerationConfig(*payload.Generation); err != nil {
+			return fmt.Errorf("Invalid generation settings: %v", err)
+		}
+	}
+	for step, cfg := range payload.StepGeneration {
+		if !slices.Contains(models.PipelineSteps, step) {
+			return fmt.Errorf("Unknown step '%s' in step_generation (valid steps: %s)", step, strings.Join(models.PipelineSteps, ", "))
+		}
+		if err := validateGenerationConfig(cfg); err != nil {
+			return fmt.Errorf("Invalid generation settings for step '%s': %v", step, err)
+		}
+	}
+	return nil
+}
 
+func validateGenerationConfig(cfg models.GenerationConfig) error {
+	switch {
+	case cfg.Temperature != nil && (*cfg.Temperature < 0 || *cfg.Temperature > 2):
+		return fmt.Errorf("temperature must be between 0 and 2")
+	case cfg.TopP != nil && (*cfg.TopP < 0 || *cfg.TopP > 1):
+		return fmt.Errorf("top_p must be between 0 and 1")
+	case cfg.TopK != nil && *cfg.TopK < 1:
+		return fmt.Errorf("top_k must be at least 1")
+	case cfg.MaxOutputTokens != nil && *cfg.MaxOutputTokens < 1:
+		return fmt.Errorf("max_output_tokens must be at least 1")
+	case cfg.CandidateCount != nil && (*cfg.CandidateCount < 1 || *cfg.CandidateCount > 8):
+		return fmt.Errorf("candidate_count must be between 1 and 8")
+	case len(cfg.StopSequences) > 5:
+		return fmt.Errorf("at most 5 stop_sequences are allowed")
+	}
+	return nil
+}
 
-	ctx := r.Context() // Use request context
-	generatedJSON, messageLog, optionText, err := h.orchestrator.ProcessGenerationRequest(ctx, payload, logIdentifier, payload.APIKey)
+// validateModelRoutes checks step_models and fallback_models: known steps, complete model
+// specs, and an API key for every backend that needs one.
+func validateModelRoutes(payload models.RequestPayload) error {
+	checkSpec := func(spec, field string) error {
+		if _, model := ai.ParseModelSpec(spec); model == "" {
+			return fmt.Errorf("Model name is missing in '%s' in %s", spec, field)
+		}
+		if payload.APIKey == "" && ai.RequiresAPIKey(spec) {
+			return fmt.Errorf("API Key is missing (required by '%s' in %s)", spec, field)
+		}
+		return nil
+	}
+	for _, spec := range payload.FallbackModels {
+		if err := checkSpec(spec, "fallback_models"); err != nil {
+			return err
+		}
+	}
+	for step, route := range payload.StepModels {
+		if !slices.Contains(models.PipelineSteps, step) {
+			return fmt.Errorf("Unknown step '%s' in step_models (valid steps: %s)", step, strings.Join(models.PipelineSteps, ", "))
+		}
+		for _, spec := range append([]string{route.Model}, route.Fallbacks...) {
+			if spec == "" {
+				continue
+			}
+			if err := checkSpec(spec, "step_models."+step); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// validateSafetySettings checks that safety_settings only names known categories and thresholds.
+func validateSafetySettings(settings map[string]string) error {
+	for category, threshold := range settings {
+		if !slices.Contains(models.SafetyCategories, category) {
+			return fmt.Errorf("Unknown harm category '%s' in safety_settings (valid categories: %s)", category, strings.Join(models.SafetyCategories, ", "))
+		}
+		if !slices.Contains(models.SafetyThresholds, threshold) {
+			return fmt.Errorf("Unknown threshold '%s' for '%s' in safety_settings (valid thresholds: %s)", threshold, category, strings.Join(models.SafetyThresholds, ", "))
+		}
+	}
+	return nil
+}
 
+// statusForError maps a generation failure to the HTTP status reported to the client.
+func statusForError(err error) int {
+	switch ai.KindOf(err) {
+	case ai.ErrRateLimited, ai.ErrQuotaExhausted:
+		return http.StatusTooManyRequests
+	case ai.ErrInvalidAPIKey:
+		return http.StatusUnauthorized
+	case ai.ErrModelNotFound:
+		return http.StatusBadRequest
+	case ai.ErrSafetyBlocked, ai.ErrRecitation:
+		return http.StatusUnprocessableEntity
+	case ai.ErrMaxTokens, ai.ErrEmptyResponse:
+		return http.StatusBadGateway
+	case ai.ErrTransient:
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+// sessionLogIdentifier returns the log identifier of the session being resumed, or a new one.
+func sessionLogIdentifier(payload models.RequestPayload) string {
+	if payload.ResumeLogIdentifier != "" {
+		return payload.ResumeLogIdentifier
+	}
+	return services.GenerateLogIdentifier(payload.Series)
+}
+
+// buildResponse assembles the final ResponsePayload from the orchestrator's results and the
+// session's event log.
+func buildResponse(payload models.RequestPayload, logIdentifier, generatedJSON, messageLog, optionText string, events *services.EventLog, err error) models.ResponsePayload {
 	response := models.ResponsePayload{
-		Timestamp:    time.Now().Format(time.RFC3339),
-		LogIdentifier: logIdentifier,
-		Series:       payload.Series,
-		OptionChosen: optionText, // Set by orchestrator
-		ModelUsed:    payload.Model,
+		Timestamp:      time.Now().Format(time.RFC3339),
+		LogIdentifier:  logIdentifier,
+		Series:         payload.Series,
+		OptionChosen:   optionText, // Set by orchestrator
+		ModelUsed:      payload.Model,
+		APIKeyReceived: payload.APIKey != "",
+		Generation:     payload.Generation,
+		StepGeneration: payload.StepGeneration,
+		StepModelsUsed: events.StepModels(),
+		Usage:          events.UsageReport(),
+		Prompts:        events.PromptAssignment(),
 	}
-response.APIKeyReceived = (payload.APIKey != "")
 
 	if err != nil {
-		log.Printf("Error processing request (Log ID: %s): %v", logIdentifier, err)
-response.Error = fmt.Sprintf("Error during generation: %s", err.Error())
-response.Message = messageLog
+		response.Error = fmt.Sprintf("Error during generation: %s", err.Error())
+		response.ErrorType = string(ai.KindOf(err))
+		response.SafetyDiagnostic = ai.SafetyDiagnosticOf(err)
+		response.Message = messageLog
 		response.GeneratedContent = ""
-		w.WriteHeader(http.StatusInternalServerError) // Or map error types to specific HTTP statuses
 	} else {
 		response.Message = "Generation process completed. See details below and check generated files.\n" + messageLog
 		response.GeneratedContent = generatedJSON
-		w.WriteHeader(http.StatusOK)
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	if encodeErr := json.NewEncoder(w).Encode(response); encodeErr != nil {
-		log.Printf("Failed to encode response (Log ID: %s): %v", logIdentifier, encodeErr)
-		// http.Error already sent, or client will time out
 	}
+	return response
 }
-
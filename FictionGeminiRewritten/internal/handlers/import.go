@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"workspace/FictionGeminiRewritten/internal/services"
+)
+
+// maxImportSize caps the size of an imported card, which may be a large PNG image.
+const maxImportSize = 20 << 20
+
+// ImportHandler serves /import. POST reads a PNG or JSON card, sent as the request body or as
+// the "file" field of a multipart form, and returns it normalized, with a report of what was
+// changed; with a "series" query parameter (or form field) the card is also stored in the
+// series workspace, under "name" or the card's own name. GET /import?series=... lists the
+// cards stored for a series.
+type ImportHandler struct{}
+
+// NewImportHandler creates a new ImportHandler.
+func NewImportHandler() *ImportHandler {
+	return &ImportHandler{}
+}
+
+func (h *ImportHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodOptions:
+		w.WriteHeader(http.StatusOK)
+	case http.MethodGet:
+		series := r.URL.Query().Get("series")
+		if strings.TrimSpace(series) == "" {
+			http.Error(w, "Series name is missing or empty", http.StatusBadRequest)
+			return
+		}
+		list, err := services.WorkspaceCards(series)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, list)
+	case http.MethodPost:
+		h.importCard(w, r)
+	default:
+		http.Error(w, "Only GET and POST methods are allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *ImportHandler) importCard(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+	var data []byte
+	var err error
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		data, err = readFormFile(r)
+	} else {
+		data, err = io.ReadAll(r.Body)
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Cannot read card: %v", err), http.StatusBadRequest)
+		return
+	}
+	if len(data) == 0 {
+		http.Error(w, "No card was sent", http.StatusBadRequest)
+		return
+	}
+
+	// FormValue reads the query string, and the multipart form if there is one.
+	imported, err := services.ImportCard(data, r.FormValue("series"), r.FormValue("name"))
+	if errors.Is(err, services.ErrInvalidCard) {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]interface{}{"error": err.Error(), "report": imported.Report})
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	status := http.StatusOK
+	if imported.Name != "" {
+		status = http.StatusCreated
+	}
+	writeJSON(w, status, imported)
+}
+
+// readFormFile reads the "file" field of a multipart form.
+func readFormFile(r *http.Request) ([]byte, error) {
+	if err := r.ParseMultipartForm(maxImportSize); err != nil {
+		return nil, err
+	}
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		return nil, fmt.Errorf("form has no file field: %w", err)
+	}
+	defer file.Close()
+	return io.ReadAll(file)
+}
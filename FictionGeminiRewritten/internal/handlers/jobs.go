@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"workspace/FictionGeminiRewritten/internal/models"
+	"workspace/FictionGeminiRewritten/internal/services"
+)
+
+// JobsHandler serves the status of asynchronous generation jobs: GET /jobs lists every
+// retained job, GET /jobs/{id} returns one job including its artifacts and, once it has
+// finished, the same ResponsePayload /generate would have returned.
+type JobsHandler struct {
+	jobs *services.JobService
+}
+
+// NewJobsHandler creates a new JobsHandler.
+func NewJobsHandler(jobs *services.JobService) *JobsHandler {
+	return &JobsHandler{jobs: jobs}
+}
+
+func (h *JobsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := r.PathValue("id")
+	if id == "" {
+		jobs := h.jobs.List()
+		statuses := make([]models.JobStatus, 0, len(jobs))
+		for _, job := range jobs {
+			statuses = append(statuses, job.Snapshot(false))
+		}
+		writeJSON(w, http.StatusOK, statuses)
+		return
+	}
+
+	job, ok := h.jobs.Get(id)
+	if !ok {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	status := job.Snapshot(true)
+	if result := job.Result(); result != nil {
+		response := buildResponse(job.Payload(), status.LogIdentifier, result.GeneratedJSON, result.MessageLog, result.OptionText, job.Events(), result.Err)
+		response.APIKeyReceived = result.APIKeyReceived
+		status.Result = &response
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+// writeJSON encodes v as the JSON response body with the given status code.
+func writeJSON(w http.ResponseWriter, statusCode int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Failed to encode response: %v", err)
+	}
+}
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"workspace/FictionGeminiRewritten/internal/models"
+	"workspace/FictionGeminiRewritten/internal/services"
+)
+
+// PreviewHandler handles the /preview endpoint. It accepts the same payload as /generate and
+// responds with every prompt the request would send, without calling a model. /generate and
+// /generate/stream answer the same way for requests with "dry_run": true.
+type PreviewHandler struct {
+	orchestrator *services.OrchestratorService
+}
+
+// NewPreviewHandler creates a new PreviewHandler.
+func NewPreviewHandler(orchestrator *services.OrchestratorService) *PreviewHandler {
+	return &PreviewHandler{orchestrator: orchestrator}
+}
+
+func (h *PreviewHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	payload, ok := decodeGenerateRequest(w, r)
+	if !ok {
+		return
+	}
+	servePreview(w, h.orchestrator, payload)
+}
+
+// servePreview writes the rendered prompts of a request. A prompt whose template fails to
+// render makes it answer 422 Unprocessable Entity, since generating would fail the same way.
+// Overrides rejected on reload are only reported: generation keeps using the templates the
+// preview rendered.
+func servePreview(w http.ResponseWriter, orchestrator *services.OrchestratorService, payload models.RequestPayload) {
+	log.Printf("Previewing prompts. Series: '%s', Option: %s, Model: %s", payload.Series, payload.Option, payload.Model)
+
+	preview, err := orchestrator.Preview(payload)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	status := http.StatusOK
+	for _, prompt := range preview.Prompts {
+		if prompt.TemplateError != "" {
+			status = http.StatusUnprocessableEntity
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if encodeErr := json.NewEncoder(w).Encode(preview); encodeErr != nil {
+		log.Printf("Failed to encode prompt preview: %v", encodeErr)
+	}
+}
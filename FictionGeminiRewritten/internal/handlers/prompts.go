@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"net/http"
+
+	"workspace/FictionGeminiRewritten/internal/models"
+	"workspace/FictionGeminiRewritten/internal/prompts"
+)
+
+// PromptsHandler serves GET /prompts: the prompt templates in use, where each was loaded from,
+// and why the latest overrides were rejected, if they were.
+type PromptsHandler struct {
+	templates *prompts.Registry
+}
+
+// NewPromptsHandler creates a new PromptsHandler.
+func NewPromptsHandler(templates *prompts.Registry) *PromptsHandler {
+	return &PromptsHandler{templates: templates}
+}
+
+func (h *PromptsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	list := models.PromptTemplateList{Templates: h.templates.List()}
+	if err := h.templates.ReloadError(); err != nil {
+		list.ReloadError = err.Error()
+	}
+	writeJSON(w, http.StatusOK, list)
+}
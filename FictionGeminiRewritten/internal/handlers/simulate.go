@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net/http"
+
+	"workspace/FictionGeminiRewritten/internal/activation"
+	"workspace/FictionGeminiRewritten/internal/models"
+	"workspace/FictionGeminiRewritten/internal/worldinfo"
+)
+
+// SimulateHandler serves /simulate. POST takes a lorebook, or a SillyTavern World Info file,
+// and a sample chat and answers with the entries that would be inserted after it, why each
+// triggered, and which the token budget cut, without calling a model.
+type SimulateHandler struct{}
+
+// NewSimulateHandler creates a new SimulateHandler.
+func NewSimulateHandler() *SimulateHandler {
+	return &SimulateHandler{}
+}
+
+func (h *SimulateHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req models.ActivationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request payload: %v", err), http.StatusBadRequest)
+		return
+	}
+	if req.WorldInfo != nil {
+		if len(req.Lorebook.Entries) > 0 {
+			http.Error(w, "Send either lorebook or world_info, not both", http.StatusBadRequest)
+			return
+		}
+		req.Lorebook = worldinfo.ToLorebook(*req.WorldInfo, req.Lorebook.Name)
+	}
+	if len(req.Lorebook.Entries) == 0 {
+		http.Error(w, "Lorebook has no entries", http.StatusBadRequest)
+		return
+	}
+	if req.ScanDepth < 0 || req.TokenBudget < 0 {
+		http.Error(w, "scan_depth and token_budget must not be negative", http.StatusBadRequest)
+		return
+	}
+	seed := rand.Int64()
+	if req.Seed != nil {
+		seed = *req.Seed
+	}
+
+	result := activation.Simulate(req.Lorebook, req.Chat, activation.Settings{
+		ScanDepth:   req.ScanDepth,
+		TokenBudget: req.TokenBudget,
+		Seed:        seed,
+	})
+	log.Printf("Simulated lorebook '%s' over %d messages (seed %d): %d triggered, %d cut by budget, %d skipped",
+		req.Lorebook.Name, len(req.Chat), seed, len(result.Triggered), len(result.CutByBudget), len(result.Skipped))
+	writeJSON(w, http.StatusOK, result)
+}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"workspace/FictionGeminiRewritten/internal/models"
+	"workspace/FictionGeminiRewritten/internal/services"
+)
+
+// StreamHandler handles the /generate/stream endpoint. It accepts the same payload as /generate
+// but responds with Server-Sent Events: step start/finish, each artifact as soon as it is saved,
+// model output tokens while they are written, and a final "done" event carrying the ResponsePayload.
+type StreamHandler struct {
+	orchestrator *services.OrchestratorService
+}
+
+// NewStreamHandler creates a new StreamHandler.
+func NewStreamHandler(orchestrator *services.OrchestratorService) *StreamHandler {
+	return &StreamHandler{orchestrator: orchestrator}
+}
+
+func (h *StreamHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	payload, ok := decodeGenerateRequest(w, r)
+	if !ok {
+		return
+	}
+	if payload.DryRun {
+		servePreview(w, h.orchestrator, payload)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported by this server", http.StatusInternalServerError)
+		return
+	}
+	// Long packs outlive the server's WriteTimeout; the stream itself keeps the connection honest.
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		log.Printf("Could not lift write deadline for stream: %v", err)
+	}
+
+	logIdentifier := sessionLogIdentifier(payload)
+	log.Printf("Received /generate/stream request. Log ID: %s, Series: '%s', Option: %s, Model: %s",
+		logIdentifier, payload.Series, payload.Option, payload.Model)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no") // Disable proxy buffering (nginx)
+	w.WriteHeader(http.StatusOK)
+
+	events := services.NewEventLog()
+	events.Subscribe(func(event models.GenerationEvent) {
+		writeSSE(w, flusher, event)
+	})
+
+	generatedJSON, messageLog, optionText, err := h.orchestrator.ProcessGenerationRequest(r.Context(), payload, logIdentifier, payload.APIKey, events)
+	response := buildResponse(payload, logIdentifier, generatedJSON, messageLog, optionText, events, err)
+	events.Emit(models.GenerationEvent{Type: models.EventDone, Response: &response})
+}
+
+// writeSSE writes one event in text/event-stream framing and flushes it to the client.
+func writeSSE(w http.ResponseWriter, flusher http.Flusher, event models.GenerationEvent) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Failed to encode SSE event %s: %v", event.Type, err)
+		return
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+	flusher.Flush()
+}
@@ -6,6 +6,8 @@ type CharacterCardV2 struct {
 	SpecVersion string     `json:"spec_version"`
 	Data        CardData   `json:"data"`
 	Extensions  Extensions `json:"extensions,omitempty"`
+
+	Unknown UnknownFields `json:"-"`
 }
 type CardData struct {
 	Name                    string     `json:"name"`
@@ -33,6 +35,8 @@ type CardData struct {
 	Alignment               string     `json:"alignment,omitempty"`
 	Tropes                  []string   `json:"tropes,omitempty"`
 	Extensions              Extensions `json:"extensions,omitempty"` // For arbitrary data
+
+	Unknown UnknownFields `json:"-"`
 }
 type Lorebook struct {
 	Name              string          `json:"name,omitempty"`
@@ -44,23 +48,309 @@ type Lorebook struct {
 	Enabled           bool            `json:"enabled"`
 	Entries           []LorebookEntry `json:"entries"`
 	Extensions        Extensions      `json:"extensions,omitempty"`
+
+	Unknown UnknownFields `json:"-"`
 }
 type LorebookEntry struct {
-	Keys           []string   `json:"keys"`
-	Content        string     `json:"content"`
-	Enabled        bool       `json:"enabled"`
-	InsertionOrder int        `json:"insertion_order"`
-	Priority       int        `json:"priority,omitempty"`
-	Comment        string     `json:"comment,omitempty"`
-	SelectiveLogic string     `json:"selectiveLogic,omitempty"`
-	SecondaryKeys  []string   `json:"secondaryKeys,omitempty"`
-	Constant       bool       `json:"constant,omitempty"`
-	CaseSensitive  bool       `json:"case_sensitive,omitempty"`
-	Probability    int        `json:"probability,omitempty"`
-	Extensions     Extensions `json:"extensions,omitempty"`
+	Keys           []string `json:"keys"`
+	Content        string   `json:"content"`
+	Enabled        bool     `json:"enabled"`
+	InsertionOrder int      `json:"insertion_order"`
+	Priority       int      `json:"priority,omitempty"`
+	Comment        string   `json:"comment,omitempty"`
+	SelectiveLogic string   `json:"selectiveLogic,omitempty" schema:"enum=AND_ANY|NOT_ALL|NOT_ANY|AND_ALL"`
+	SecondaryKeys  []string `json:"secondaryKeys,omitempty"`
+	Constant       bool     `json:"constant,omitempty"`
+	CaseSensitive  bool     `json:"case_sensitive,omitempty"`
+	Probability    int      `json:"probability,omitempty" schema:"min=0,max=100"` // Percent chance it is inserted when triggered; 100 if zero
+	UseRegex       bool     `json:"use_regex,omitempty"`                          // Keys are regular expressions (V3)
+
+	// SillyTavern's entry settings. Generated lorebooks must keep to their schema tags.
+	ID   *int   `json:"id,omitempty"` // Unique within the lorebook; the UID of its World Info entry
+	Name string `json:"name,omitempty"`
+	// Position is one of the LorebookPosition names; empty means before_char. Depth, for
+	// at_depth, counts messages from the end of the chat; nil means 4.
+	Position string `json:"position,omitempty" schema:"enum=before_char|after_char|an_top|an_bottom|at_depth|em_top|em_bottom"`
+	Depth    *int   `json:"depth,omitempty" schema:"min=0"`
+	// Of the entries of an inclusion group that trigger together, only one is inserted, picked
+	// with odds by GroupWeight; zero means 100.
+	Group       string `json:"group,omitempty"`
+	GroupWeight int    `json:"group_weight,omitempty" schema:"min=0"`
+	// Timed effects, in messages: how long the entry stays inserted once triggered, how long it
+	// can't trigger after that, and how long the chat must be before it can trigger at all.
+	Sticky   int `json:"sticky,omitempty" schema:"min=0"`
+	Cooldown int `json:"cooldown,omitempty" schema:"min=0"`
+	Delay    int `json:"delay,omitempty" schema:"min=0"`
+	// ExcludeRecursion keeps other entries' content from triggering the entry, and
+	// PreventRecursion keeps its content from triggering others.
+	ExcludeRecursion bool `json:"exclude_recursion,omitempty"`
+	PreventRecursion bool `json:"prevent_recursion,omitempty"`
+	MatchWholeWords  bool `json:"match_whole_words,omitempty"` // False leaves it to the frontend's setting
+	Vectorized       bool `json:"vectorized,omitempty"`        // Also triggered by vector similarity to the chat
+
+	Extensions Extensions `json:"extensions,omitempty"`
+
+	Unknown UnknownFields `json:"-"`
 }
 type Extensions map[string]interface{}
 
+// --- Character Card V3 Structures ---
+
+// Card spec identifiers.
+const (
+	CardSpecV2        = "chara_card_v2"
+	CardSpecVersionV2 = "2.0"
+	CardSpecV3        = "chara_card_v3"
+	CardSpecVersionV3 = "3.0"
+
+	// CardSpecV1 names cards from before the V2 spec, which have no spec field and keep their
+	// fields at the top level.
+	CardSpecV1 = "chara_card_v1"
+)
+
+// Card formats a request may generate its character cards in.
+const (
+	CardFormatV2 = "v2" // chara_card_v2, the default
+	CardFormatV3 = "v3" // chara_card_v3
+)
+
+// CharacterCardV3 is a chara_card_v3 card. Its lorebook entries may start with decorators,
+// lines such as "@@depth 4" that tune how the entry is inserted.
+type CharacterCardV3 struct {
+	Spec        string     `json:"spec"`
+	SpecVersion string     `json:"spec_version"`
+	Data        CardDataV3 `json:"data"`
+	Extensions  Extensions `json:"extensions,omitempty"`
+
+	Unknown UnknownFields `json:"-"`
+}
+
+// CardDataV3 holds every V2 field plus the ones V3 added. Unknown members are kept in
+// CardData.Unknown.
+type CardDataV3 struct {
+	CardData
+	CardV3Fields
+}
+
+// CardV3Fields are the card fields V3 added to V2.
+type CardV3Fields struct {
+	Nickname                 string            `json:"nickname,omitempty"`                   // Used for {{char}} instead of the name
+	CreatorNotesMultilingual map[string]string `json:"creator_notes_multilingual,omitempty"` // Keyed by ISO 639-1 language code
+	Source                   []string          `json:"source,omitempty"`                     // IDs or URLs the card came from
+	GroupOnlyGreetings       []string          `json:"group_only_greetings"`
+	CreationDate             int64             `json:"creation_date,omitempty"`     // Unix seconds
+	ModificationDate         int64             `json:"modification_date,omitempty"` // Unix seconds
+	Assets                   []CardAsset       `json:"assets,omitempty"`
+}
+
+// CardAsset is an image, sound or other file that belongs to a V3 card.
+type CardAsset struct {
+	Type string `json:"type"` // e.g. "icon", "background" or "emotion"
+	URI  string `json:"uri"`  // URL, data URI, "embeded://path" or "ccdefault:"
+	Name string `json:"name"` // "main" for the card's primary asset of its type
+	Ext  string `json:"ext"`  // File extension without the dot, e.g. "png"
+
+	Unknown UnknownFields `json:"-"`
+}
+
+// --- SillyTavern World Info Structures ---
+
+// WorldInfo is a standalone SillyTavern World Info file, the layout of the files in its
+// worlds/ folder. The world is named after its file.
+type WorldInfo struct {
+	Entries      map[string]WorldInfoEntry `json:"entries"`                // Keyed by each entry's UID, as a string
+	OriginalData *Lorebook                 `json:"originalData,omitempty"` // Settings of the lorebook the world was converted from
+
+	Unknown UnknownFields `json:"-"`
+}
+
+// WorldInfoEntry is one entry of a WorldInfo file. Null pointer fields use SillyTavern's
+// global settings.
+type WorldInfoEntry struct {
+	UID                 int         `json:"uid"`
+	Key                 []string    `json:"key"`          // Primary keys; "/pattern/flags" keys are regular expressions
+	KeySecondary        []string    `json:"keysecondary"` // Optional filter, combined per SelectiveLogic
+	Comment             string      `json:"comment"`      // Title shown in the editor
+	Content             string      `json:"content"`
+	Constant            bool        `json:"constant"`
+	Vectorized          bool        `json:"vectorized"`
+	Selective           bool        `json:"selective"`
+	SelectiveLogic      int         `json:"selectiveLogic"` // One of the WorldInfoLogic values
+	AddMemo             bool        `json:"addMemo"`
+	Order               int         `json:"order"`
+	Position            int         `json:"position"` // One of the WorldInfoPosition values
+	Disable             bool        `json:"disable"`
+	ExcludeRecursion    bool        `json:"excludeRecursion"`
+	PreventRecursion    bool        `json:"preventRecursion"`
+	DelayUntilRecursion interface{} `json:"delayUntilRecursion"` // false, true or a recursion level
+	Probability         int         `json:"probability"`
+	UseProbability      bool        `json:"useProbability"`
+	Depth               int         `json:"depth"` // Messages from the end of the chat, for WorldInfoAtDepth
+	Group               string      `json:"group"`
+	GroupOverride       bool        `json:"groupOverride"`
+	GroupWeight         int         `json:"groupWeight"`
+	ScanDepth           *int        `json:"scanDepth"`
+	CaseSensitive       *bool       `json:"caseSensitive"`
+	MatchWholeWords     *bool       `json:"matchWholeWords"`
+	UseGroupScoring     *bool       `json:"useGroupScoring"`
+	AutomationID        string      `json:"automationId"`
+	Role                *int        `json:"role"` // 0 system, 1 user, 2 assistant, for WorldInfoAtDepth
+	Sticky              *int        `json:"sticky"`
+	Cooldown            *int        `json:"cooldown"`
+	Delay               *int        `json:"delay"`
+	DisplayIndex        int         `json:"displayIndex"`
+
+	Unknown UnknownFields `json:"-"`
+}
+
+// World Info entry positions: where an entry's content is inserted into the prompt.
+const (
+	WorldInfoBeforeChar = 0 // Before the character definitions
+	WorldInfoAfterChar  = 1 // After the character definitions
+	WorldInfoANTop      = 2 // Top of the author's note
+	WorldInfoANBottom   = 3 // Bottom of the author's note
+	WorldInfoAtDepth    = 4 // Into the chat, Depth messages from its end
+	WorldInfoEMTop      = 5 // Before the example messages
+	WorldInfoEMBottom   = 6 // After the example messages
+)
+
+// World Info selective logic: how an entry's secondary keys filter its primary keys.
+const (
+	WorldInfoAndAny = 0 // Any secondary key must match too
+	WorldInfoNotAll = 1 // Not all secondary keys may match
+	WorldInfoNotAny = 2 // No secondary key may match
+	WorldInfoAndAll = 3 // All secondary keys must match too
+)
+
+// Names of the selective logic values in LorebookEntry.SelectiveLogic.
+const (
+	SelectiveLogicAndAny = "AND_ANY"
+	SelectiveLogicNotAll = "NOT_ALL"
+	SelectiveLogicNotAny = "NOT_ANY"
+	SelectiveLogicAndAll = "AND_ALL"
+)
+
+// SelectiveLogics lists the selective logic names, indexed by their World Info value.
+var SelectiveLogics = []string{SelectiveLogicAndAny, SelectiveLogicNotAll, SelectiveLogicNotAny, SelectiveLogicAndAll}
+
+// Names of the insertion positions in LorebookEntry.Position. The first two are the V2 spec's.
+const (
+	LorebookPositionBeforeChar = "before_char"
+	LorebookPositionAfterChar  = "after_char"
+	LorebookPositionANTop      = "an_top"
+	LorebookPositionANBottom   = "an_bottom"
+	LorebookPositionAtDepth    = "at_depth"
+	LorebookPositionEMTop      = "em_top"
+	LorebookPositionEMBottom   = "em_bottom"
+)
+
+// LorebookPositions lists the position names, indexed by their World Info value.
+var LorebookPositions = []string{
+	LorebookPositionBeforeChar, LorebookPositionAfterChar, LorebookPositionANTop, LorebookPositionANBottom,
+	LorebookPositionAtDepth, LorebookPositionEMTop, LorebookPositionEMBottom,
+}
+
+// --- Lorebook Activation Simulation ---
+
+// ActivationRequest is the body of /simulate: a lorebook, or a SillyTavern World Info file in
+// its place, and a sample chat to run it against.
+type ActivationRequest struct {
+	Lorebook    Lorebook   `json:"lorebook"`
+	WorldInfo   *WorldInfo `json:"world_info,omitempty"`
+	Chat        []string   `json:"chat"`                   // One message per item, oldest first, e.g. "Paul: Where is the spice?"
+	Seed        *int64     `json:"seed,omitempty"`         // Seeds probability rolls and inclusion groups; random if nil
+	ScanDepth   int        `json:"scan_depth,omitempty"`   // Overrides the lorebook's
+	TokenBudget int        `json:"token_budget,omitempty"` // Overrides the lorebook's
+}
+
+// ActivationResult is what a lorebook would insert into the prompt after the sample chat.
+type ActivationResult struct {
+	Seed        int64            `json:"seed"` // Send it back to repeat the run
+	ScanDepth   int              `json:"scan_depth"`
+	TokenBudget int              `json:"token_budget"` // 0 is unlimited
+	TokensUsed  int              `json:"tokens_used"`
+	Triggered   []ActivatedEntry `json:"triggered"`     // Inserted, in insertion order
+	CutByBudget []ActivatedEntry `json:"cut_by_budget"` // Triggered, but past the token budget
+	Skipped     []ActivatedEntry `json:"skipped"`       // Matched, but delayed or lost a probability roll or inclusion group
+	Warnings    []string         `json:"warnings,omitempty"`
+}
+
+// ActivatedEntry is an entry of an ActivationResult, with why it was triggered or skipped.
+type ActivatedEntry struct {
+	Index                int      `json:"index"` // Position in the lorebook's entries
+	ID                   *int     `json:"id,omitempty"`
+	Title                string   `json:"title"` // Its name, comment or first key
+	InsertionOrder       int      `json:"insertion_order"`
+	Tokens               int      `json:"tokens"` // Estimated size of its content
+	Constant             bool     `json:"constant,omitempty"`
+	MatchedKeys          []string `json:"matched_keys,omitempty"`
+	MatchedSecondaryKeys []string `json:"matched_secondary_keys,omitempty"`
+	RecursionLevel       int      `json:"recursion_level,omitempty"` // 0 if the chat triggered it, else how many entries removed
+	TriggeredBy          []int    `json:"triggered_by,omitempty"`    // Indexes of the entries whose content matched its keys
+	Roll                 *int     `json:"roll,omitempty"`            // Its probability roll, 1-100, if it has a probability
+	Reason               string   `json:"reason"`
+}
+
+// CardFile is a PNG card saved for a session.
+type CardFile struct {
+	File       string `json:"file"`
+	URL        string `json:"url"` // Download path, e.g. "/cards/<log_identifier>/<file>"
+	Size       int64  `json:"size"`
+	ModifiedAt string `json:"modified_at"`
+}
+
+// CardFileList is the response of GET /cards/{log_identifier}.
+type CardFileList struct {
+	LogIdentifier string     `json:"log_identifier"`
+	Cards         []CardFile `json:"cards"`
+}
+
+// Where an imported card was read from.
+const (
+	ImportSourcePNG  = "png"  // The chara or ccv3 chunk of a PNG image
+	ImportSourceJSON = "json" // A JSON document
+)
+
+// ImportReport describes how an imported card was normalized to the V2 model.
+type ImportReport struct {
+	Source    string         `json:"source"`    // ImportSourcePNG or ImportSourceJSON
+	Spec      string         `json:"spec"`      // Spec the card was written in, e.g. "chara_card_v3"
+	Upgraded  []ImportChange `json:"upgraded"`  // Fields moved or converted to fit the model
+	Defaulted []ImportChange `json:"defaulted"` // Missing fields that were filled in
+	Dropped   []ImportChange `json:"dropped"`   // Fields that couldn't be used and were discarded
+}
+
+// ImportChange is one change made while importing a card.
+type ImportChange struct {
+	Field  string `json:"field"` // JSON path, e.g. "data.character_book.entries[2].keys"
+	Detail string `json:"detail"`
+}
+
+// ImportedCard is a card imported into a series workspace, or, if no series was given, only
+// normalized and returned.
+type ImportedCard struct {
+	Name       string          `json:"name,omitempty"` // Workspace name, used to pick the card in context_cards
+	Series     string          `json:"series,omitempty"`
+	ImportedAt string          `json:"imported_at"`
+	Card       CharacterCardV2 `json:"card"`
+	Report     ImportReport    `json:"report"`
+}
+
+// ImportedCardInfo summarizes a card in a series workspace.
+type ImportedCardInfo struct {
+	Name       string `json:"name"`
+	CardName   string `json:"card_name"` // The card's data.name
+	Source     string `json:"source"`
+	Spec       string `json:"spec"`
+	ImportedAt string `json:"imported_at"`
+}
+
+// ImportedCardList is the response of GET /import?series=...
+type ImportedCardList struct {
+	Series string             `json:"series"`
+	Cards  []ImportedCardInfo `json:"cards"`
+}
+
 // --- Request and Response Payloads ---
 
 // Struct for AI-suggested tool details (Option 4)
@@ -70,12 +360,87 @@ type AISuggestedTool struct {
 	ToolJustification string `json:"tool_justification"`
 }
 
+// LorebookOutline is the response shape expected from LorebookOutlinePrompt.
+type LorebookOutline struct {
+	Categories []LorebookOutlineCategory `json:"categories"`
+}
+
+// LorebookOutlineCategory is one category of lore and the entities to write entries for.
+type LorebookOutlineCategory struct {
+	Name     string   `json:"name"`
+	Entities []string `json:"entities"`
+}
+
+// LorebookEntryBatch is the response shape expected from LorebookBatchPrompt.
+type LorebookEntryBatch struct {
+	Entries []LorebookEntry `json:"entries"`
+}
+
+// Lorebook generation modes.
+const (
+	LorebookModeSingle = "single" // One prompt writes the whole lorebook
+	LorebookModeFanout = "fanout" // An outline first, then entries per batch of entities in parallel
+)
+
+// AIToolSuggestions is the response shape expected from ToolSuggestionPrompt.
+type AIToolSuggestions struct {
+	Tools []AISuggestedTool `json:"suggested_tools"`
+}
+
 type RequestPayload struct {
 	APIKey          string `json:"api_key"`
 	Series          string `json:"series"`
 	Option          string `json:"option"`
-	Model           string `json:"model"`
+	Model           string `json:"model"` // "backend:model" spec, e.g. "gemini:gemini-1.5-pro" or "ollama:llama3"; bare names use Gemini
 	ToolCardPurpose string `json:"toolCardPurpose,omitempty"` // New field for Option 3
+	RepairAttempts  *int   `json:"repair_attempts,omitempty"` // Model calls allowed to fix unparseable JSON per artifact; nil uses the server default
+	// MaxContinuations caps follow-up calls made when output is cut off at the token limit; nil uses the server default.
+	MaxContinuations *int `json:"max_continuations,omitempty"`
+	Async           bool   `json:"async,omitempty"`           // Return a job ID immediately instead of waiting for the result
+
+	// LorebookMode selects how lorebooks are generated: "single" (default) or "fanout".
+	// FanoutConcurrency and FanoutBatchSize tune fan-out mode; zero uses the server defaults.
+	LorebookMode      string `json:"lorebook_mode,omitempty"`
+	FanoutConcurrency int    `json:"fanout_concurrency,omitempty"`
+	FanoutBatchSize   int    `json:"fanout_batch_size,omitempty"`
+
+	// CardFormat selects the spec character cards are saved and returned in: "v2" (default,
+	// chara_card_v2) or "v3" (chara_card_v3).
+	CardFormat string `json:"card_format,omitempty"`
+
+	// Avatar is the image PNG cards are embedded into: a base64-encoded PNG, JPEG or GIF,
+	// optionally as a data URI. Without one, cards get a plain default image.
+	Avatar string `json:"avatar,omitempty"`
+
+	// ContextCards names cards imported into the series workspace whose excerpts are given to
+	// the model as established context when generating cards and lorebooks.
+	ContextCards []string `json:"context_cards,omitempty"`
+
+	// Generation sets sampling parameters for every model call; StepGeneration overrides them
+	// field by field for individual pipeline steps, keyed by step ID (e.g. "narrator_card").
+	Generation     *GenerationConfig           `json:"generation,omitempty"`
+	StepGeneration map[string]GenerationConfig `json:"step_generation,omitempty"`
+
+	// StepModels routes pipeline steps (keyed by step ID) to other models than Model, each
+	// with its own fallbacks. FallbackModels are tried in order, for steps without their own
+	// fallbacks, when a step's model fails or is blocked.
+	StepModels     map[string]ModelRoute `json:"step_models,omitempty"`
+	FallbackModels []string              `json:"fallback_models,omitempty"`
+
+	// SafetySettings sets the blocking threshold per harm category, e.g.
+	// {"dangerous_content": "block_only_high"}. Categories left out use the backend's defaults.
+	SafetySettings map[string]string `json:"safety_settings,omitempty"`
+
+	// Experiment runs the request in experiment mode: every prompt template with variants is
+	// rendered at one of its versions, picked at random or from Experiment.Seed.
+	Experiment *ExperimentSettings `json:"experiment,omitempty"`
+
+	// DryRun returns the rendered prompts of every step instead of calling the model.
+	DryRun bool `json:"dry_run,omitempty"`
+
+	// ResumeLogIdentifier resumes an earlier session from its checkpoint, re-running only the
+	// steps that failed or never ran. Series, option and tool card purpose come from the checkpoint.
+	ResumeLogIdentifier string `json:"resume_log_identifier,omitempty"`
 }
 type ResponsePayload struct {
 	Series           string `json:"series"`
@@ -86,9 +451,365 @@ type ResponsePayload struct {
 	GeneratedContent string `json:"generated_content,omitempty"`
 	Timestamp        string `json:"timestamp"`
 	Error            string `json:"error,omitempty"`
+	ErrorType        string `json:"error_type,omitempty"` // Classified AI failure, e.g. "rate_limited" or "safety_blocked"
 	LogIdentifier    string `json:"log_identifier,omitempty"`
+
+	Generation     *GenerationConfig           `json:"generation,omitempty"`      // Echo of the request's generation parameters
+	StepGeneration map[string]GenerationConfig `json:"step_generation,omitempty"` // Echo of the per-step overrides
+
+	SafetyDiagnostic *SafetyDiagnostic `json:"safety_diagnostic,omitempty"` // Set when the error was a safety block
+
+	// StepModelsUsed records, per step ID, the model spec that produced its output. Steps whose
+	// calls were answered by different models (e.g. fan-out batches after a fallback) list them all.
+	StepModelsUsed map[string]string `json:"step_models_used,omitempty"`
+
+	Usage *UsageReport `json:"usage,omitempty"` // Tokens and estimated cost of the session so far
+
+	Prompts *PromptAssignment `json:"prompts,omitempty"` // Prompt template versions the session renders
+}
+
+// TokenUsage counts the tokens and estimated cost of one or more model calls.
+type TokenUsage struct {
+	Calls           int     `json:"calls"`
+	PromptTokens    int     `json:"prompt_tokens"`
+	CandidateTokens int     `json:"candidate_tokens"`
+	TotalTokens     int     `json:"total_tokens"`
+	EstimatedCost   float64 `json:"estimated_cost"` // Calls to models without a known price count as zero
+}
+
+// UsageReport is the token usage of a generation session, per step, per model and in total.
+// A resumed session keeps adding to the report of its earlier runs.
+type UsageReport struct {
+	Currency       string                `json:"currency"`
+	Total          TokenUsage            `json:"total"`
+	Steps          map[string]TokenUsage `json:"steps"`
+	Models         map[string]TokenUsage `json:"models"`
+	UnpricedModels []string              `json:"unpriced_models,omitempty"` // Models missing from the price table
+	UpdatedAt      string                `json:"updated_at"`
+}
+
+// ModelRoute selects the model for one pipeline step and the models to fall back to, in order.
+type ModelRoute struct {
+	Model     string   `json:"model,omitempty"` // "backend:model" spec; empty keeps the request's model
+	Fallbacks []string `json:"fallbacks,omitempty"`
+}
+
+// Harm categories accepted as safety_settings keys.
+const (
+	SafetyHarassment       = "harassment"
+	SafetyHateSpeech       = "hate_speech"
+	SafetySexuallyExplicit = "sexually_explicit"
+	SafetyDangerousContent = "dangerous_content"
+)
+
+// SafetyCategories lists every harm category that can be configured.
+var SafetyCategories = []string{SafetyHarassment, SafetyHateSpeech, SafetySexuallyExplicit, SafetyDangerousContent}
+
+// SafetyThresholds lists the accepted safety_settings values, from most to least permissive.
+var SafetyThresholds = []string{"block_none", "block_only_high", "block_medium_and_above", "block_low_and_above"}
+
+// Where a safety block happened.
+const (
+	SafetySourcePrompt   = "prompt"   // The prompt was rejected before anything was generated
+	SafetySourceResponse = "response" // The model's output was stopped
+)
+
+// SafetyDiagnostic explains a safety block: which step and which side of the call was blocked,
+// and how each harm category was rated.
+type SafetyDiagnostic struct {
+	Step         string         `json:"step,omitempty"`          // Pipeline step whose call was blocked
+	Source       string         `json:"source"`                  // SafetySourcePrompt or SafetySourceResponse
+	BlockReason  string         `json:"block_reason,omitempty"`  // Why the prompt was blocked, e.g. "safety" or "other"
+	FinishReason string         `json:"finish_reason,omitempty"` // Why the response stopped, e.g. "safety" or "content_filter"
+	Ratings      []SafetyRating `json:"ratings,omitempty"`
+	Hint         string         `json:"hint,omitempty"` // What the user can change to get past the block
+}
+
+// SafetyRating is the rating of one harm category.
+type SafetyRating struct {
+	Category    string `json:"category"`    // e.g. "dangerous_content"
+	Probability string `json:"probability"` // "negligible", "low", "medium" or "high"
+	Blocked     bool   `json:"blocked"`     // Whether this category caused the block
+}
+
+// GenerationConfig holds optional sampling parameters for model calls. Unset fields use the
+// backend's defaults.
+type GenerationConfig struct {
+	Temperature     *float32 `json:"temperature,omitempty"`
+	TopP            *float32 `json:"top_p,omitempty"`
+	TopK            *int32   `json:"top_k,omitempty"`
+	MaxOutputTokens *int32   `json:"max_output_tokens,omitempty"`
+	StopSequences   []string `json:"stop_sequences,omitempty"`
+	CandidateCount  *int32   `json:"candidate_count,omitempty"` // Only the first candidate is used
+	Seed            *int64   `json:"seed,omitempty"`
+}
+
+// --- Generation Progress Events ---
+
+// Pipeline step identifiers, used in progress events and per-step settings.
+const (
+	StepComprehensiveLorebook = "comprehensive_lorebook"
+	StepToolCard              = "tool_card"
+	StepNarratorCard          = "narrator_card"
+	StepMasterLorebook        = "master_lorebook"
+	StepContextSummary        = "context_summary"
+	StepToolSuggestion        = "tool_suggestion"
+	StepUtilityCard           = "utility_card"
+)
+
+// PipelineSteps lists every step identifier, for validating per-step settings.
+var PipelineSteps = []string{
+	StepComprehensiveLorebook,
+	StepToolCard,
+	StepNarratorCard,
+	StepMasterLorebook,
+	StepContextSummary,
+	StepToolSuggestion,
+	StepUtilityCard,
+}
+
+// Event types emitted while a generation request runs.
+const (
+	EventMessage    = "message"     // A line of the human-readable message log
+	EventStepStart  = "step_start"  // A pipeline step began
+	EventStepFinish = "step_finish" // A pipeline step ended; Error is set if it failed
+	EventModel      = "model"       // A model answered a call of a step; Model names it and Usage counts it
+	EventUsage      = "usage"       // The session's token usage so far, in UsageReport
+	EventArtifact   = "artifact"    // A generated artifact was produced (and saved, if FilePath is set)
+	EventToken      = "token"       // A chunk of model output while it is being written
+	EventRetry      = "retry"       // A model call of a step failed and is sent again; Text holds the token text it streamed
+	EventPrompts    = "prompts"     // The prompt template versions the session renders, in Prompts
+	EventDone       = "done"        // The request finished; Response holds the final payload
+)
+
+// GenerationEvent is a single progress event, streamed to clients as Server-Sent Events.
+type GenerationEvent struct {
+	Type        string            `json:"type"`
+	Step        string            `json:"step,omitempty"`
+	Message     string            `json:"message,omitempty"`
+	Text        string            `json:"text,omitempty"` // Token chunk for "token" events; what the failed attempt streamed for "retry" events
+	Error       string            `json:"error,omitempty"`
+	Attempt     int               `json:"attempt,omitempty"` // The failed attempt, for "retry" events
+	Model       string            `json:"model,omitempty"`   // Model spec for "model" events
+	Usage       *TokenUsage       `json:"usage,omitempty"`   // Tokens used by the call, for "model" events
+	UsageReport *UsageReport      `json:"usage_report,omitempty"`
+	Artifact    *ArtifactInfo     `json:"artifact,omitempty"`
+	Prompts     *PromptAssignment `json:"prompts,omitempty"`
+	Response    *ResponsePayload  `json:"response,omitempty"`
+	Time        string            `json:"time"`
+}
+
+// ArtifactInfo describes one generated card or lorebook.
+type ArtifactInfo struct {
+	Kind     string `json:"kind"` // File prefix, e.g. "narrator_card" or "utility_card_ai_suggested_1"
+	Name     string `json:"name"`
+	FilePath string `json:"file_path,omitempty"`
+	Content  string `json:"content,omitempty"`
+
+	PromptVersions map[string]string `json:"prompt_versions,omitempty"` // Versions of the templates its prompts came from
+
+	// For character cards, the PNG card saved next to the JSON and where to download it.
+	PNGFilePath string `json:"png_file_path,omitempty"`
+	PNGURL      string `json:"png_url,omitempty"`
+
+	// For lorebooks, the SillyTavern World Info file saved beside the JSON.
+	WorldInfoFilePath string `json:"world_info_file_path,omitempty"`
+}
+
+// --- Asynchronous Jobs ---
+
+// Job lifecycle states.
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+)
+
+// JobStatus is the externally visible state of an asynchronous generation job.
+type JobStatus struct {
+	ID            string           `json:"id"`
+	Status        string           `json:"status"`
+	LogIdentifier string           `json:"log_identifier"`
+	Series        string           `json:"series"`
+	Option        string           `json:"option"`
+	Model         string           `json:"model"`
+	CreatedAt     string           `json:"created_at"`
+	StartedAt     string           `json:"started_at,omitempty"`
+	FinishedAt    string           `json:"finished_at,omitempty"`
+	CurrentStep   string           `json:"current_step,omitempty"`
+	Steps         []JobStep        `json:"steps,omitempty"`
+	Artifacts     []ArtifactInfo   `json:"artifacts,omitempty"` // Partial artifacts, available as soon as each is generated
+	Message       string           `json:"message,omitempty"`   // Message log so far
+	Result        *ResponsePayload `json:"result,omitempty"`    // Set once the job has finished
 }
 
+// JobStep records the progress of one pipeline step within a job.
+type JobStep struct {
+	Step       string `json:"step"`
+	Status     string `json:"status"` // running, succeeded or failed
+	Error      string `json:"error,omitempty"`
+	Model      string `json:"model,omitempty"` // Model that answered the step's last call
+	StartedAt  string `json:"started_at"`
+	FinishedAt string `json:"finished_at,omitempty"`
+}
+
+
+// --- Cost Estimates ---
+
+// TokenRange is a low and high token count.
+type TokenRange struct {
+	Min int `json:"min"`
+	Max int `json:"max"`
+}
+
+// CostRange is a low and high cost, in the estimate's currency.
+type CostRange struct {
+	Min float64 `json:"min"`
+	Max float64 `json:"max"`
+}
+
+// StepEstimate is the projected usage of one pipeline step, or of one call within it.
+type StepEstimate struct {
+	Step            string     `json:"step"`
+	Label           string     `json:"label"`
+	Model           string     `json:"model"`
+	Calls           int        `json:"calls"`
+	PromptTokens    TokenRange `json:"prompt_tokens"`
+	PromptCountedBy string     `json:"prompt_counted_by"` // "provider", or "estimator" when counted locally
+	OutputTokens    TokenRange `json:"output_tokens"`
+	OutputBasis     string     `json:"output_basis"` // Where the output projection came from
+	EstimatedCost   CostRange  `json:"estimated_cost"`
+	Priced          bool       `json:"priced"` // False when the model is missing from the price table
+	TemplateError   string     `json:"template_error,omitempty"`
+}
+
+// EstimateResponse is the pre-flight estimate of what a generation request would use and cost.
+// Repair and continuation calls are not included.
+type EstimateResponse struct {
+	Series        string         `json:"series"`
+	Option        string         `json:"option"`
+	OptionChosen  string         `json:"option_chosen"`
+	Currency      string         `json:"currency"`
+	Steps         []StepEstimate `json:"steps"`
+	PromptTokens  TokenRange     `json:"prompt_tokens"`
+	OutputTokens  TokenRange     `json:"output_tokens"`
+	EstimatedCost CostRange      `json:"estimated_cost"`
+	Notes         []string       `json:"notes,omitempty"`
+}
+
+// --- Prompt Templates ---
+
+// PromptTemplateInfo describes a loaded prompt template.
+type PromptTemplateInfo struct {
+	Name              string   `json:"name"`
+	Version           string   `json:"version"`
+	Description       string   `json:"description,omitempty"`
+	RequiredVariables []string `json:"required_variables"`
+	OutputKind        string   `json:"output_kind"` // "json" or "text"
+	Source            string   `json:"source"`      // "embedded", or the override file it was read from
+
+	Variants []PromptVariantInfo `json:"variants,omitempty"` // Alternative versions for experiments
+}
+
+// PromptVariantInfo describes an alternative version of a prompt template.
+type PromptVariantInfo struct {
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+	Source      string `json:"source"`
+}
+
+// PromptTemplateList is the response of GET /prompts.
+type PromptTemplateList struct {
+	Templates   []PromptTemplateInfo `json:"templates"`
+	ReloadError string               `json:"reload_error,omitempty"` // Why the override directory's current contents were rejected
+}
+
+// --- Prompt Previews ---
+
+// PromptPreview is one prompt a generation request would send, fully rendered.
+type PromptPreview struct {
+	Step          string `json:"step"`
+	Label         string `json:"label"`
+	Model         string `json:"model"`
+	Calls         int    `json:"calls"` // Fan-out batches send one prompt like this per batch
+	Prompt        string `json:"prompt"`
+	TemplateError string `json:"template_error,omitempty"` // Prompt holds the raw template when set
+}
+
+// PreviewResponse is the result of a dry run: every prompt the request's option would send,
+// without calling a model. Output of earlier steps is substituted from the resumed session's
+// checkpoint when there is one, and shown as bracketed placeholders otherwise.
+type PreviewResponse struct {
+	Series         string          `json:"series"`
+	Option         string          `json:"option"`
+	OptionChosen   string          `json:"option_chosen"`
+	Prompts        []PromptPreview `json:"prompts"`
+	Placeholders   bool            `json:"placeholders"`              // Whether any prompt still holds placeholders
+	TemplateErrors []string        `json:"template_errors,omitempty"` // Why overrides were rejected on reload; the prompts come from the templates still in use
+
+	PromptVersions *PromptAssignment `json:"prompt_versions"` // Template versions the prompts were rendered from
+}
+
+// --- Prompt Experiments ---
+
+// ExperimentSettings turns on experiment mode for a request.
+type ExperimentSettings struct {
+	Seed *int64 `json:"seed,omitempty"` // The same seed always picks the same variants; random when unset
+}
+
+// PromptAssignment records the prompt template versions a session renders.
+type PromptAssignment struct {
+	Versions       map[string]string `json:"versions"`                  // Version of every template, by name
+	ExperimentSeed *int64            `json:"experiment_seed,omitempty"` // In experiment mode, the seed that picked the variants
+}
+
+// StepOutcome is how the prompts of one pipeline step fared in one run of a session.
+type StepOutcome struct {
+	Step           string            `json:"step"`
+	PromptVersions map[string]string `json:"prompt_versions"`
+	ParseAttempts  int               `json:"parse_attempts"`    // Responses that had to parse as the step's JSON
+	ParseFailures  int               `json:"parse_failures"`    // Responses that still didn't parse after every repair
+	RepairAttempts int               `json:"repair_attempts"`   // Model calls made to fix JSON that didn't parse
+	Entries        int               `json:"entries,omitempty"` // Lorebook entries written, or tools suggested
+	Usage          TokenUsage        `json:"usage"`
+}
+
+// PromptRun holds the step outcomes of one run of a session. Every session directory keeps
+// the runs of that session in prompt_outcomes.json.
+type PromptRun struct {
+	LogIdentifier  string        `json:"log_identifier"`
+	Series         string        `json:"series"`
+	Option         string        `json:"option"`
+	ExperimentSeed *int64        `json:"experiment_seed,omitempty"`
+	FinishedAt     string        `json:"finished_at"`
+	Steps          []StepOutcome `json:"steps"`
+}
+
+// VariantStats sums up the outcomes of one step across runs that rendered it with the same
+// template versions.
+type VariantStats struct {
+	Step              string            `json:"step"`
+	PromptVersions    map[string]string `json:"prompt_versions"`
+	Runs              int               `json:"runs"`
+	ExperimentRuns    int               `json:"experiment_runs"` // Runs whose versions were assigned by an experiment
+	ParseAttempts     int               `json:"parse_attempts"`
+	ParseFailures     int               `json:"parse_failures"`
+	ParseSuccessRate  float64           `json:"parse_success_rate"` // Share of parse attempts that succeeded; 1 when there were none
+	RepairAttempts    int               `json:"repair_attempts"`
+	AvgRepairAttempts float64           `json:"avg_repair_attempts"` // Per run
+	AvgEntries        float64           `json:"avg_entries,omitempty"`
+	AvgTotalTokens    float64           `json:"avg_total_tokens"`
+	AvgEstimatedCost  float64           `json:"avg_estimated_cost"`
+	FirstRun          string            `json:"first_run"`
+	LastRun           string            `json:"last_run"`
+}
+
+// ExperimentReport is the response of GET /experiments.
+type ExperimentReport struct {
+	Runs     int            `json:"runs"`
+	Variants []VariantStats `json:"variants"`
+}
 // --- Constants ---
 const CHARACTER_CARD_SEPARATOR = "CHARACTER_CARD_SEPARATOR_AI_FICTION_FORGE"
 
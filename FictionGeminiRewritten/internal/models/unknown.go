@@ -0,0 +1,235 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// UnknownFields holds the members of a JSON object that its Go type has no field for. Cards,
+// lorebooks, World Info files and their entries keep them when decoded and write them back
+// when encoded, after the known fields and sorted by name, since the specs require editors to
+// preserve keys they don't understand.
+type UnknownFields map[string]json.RawMessage
+
+// The types are converted to plain copies of themselves, without these methods, so that
+// encoding/json handles their known fields as usual.
+
+func (c CharacterCardV2) MarshalJSON() ([]byte, error) {
+	type plain CharacterCardV2
+	return encodeWithUnknown(plain(c), c.Unknown)
+}
+
+func (c *CharacterCardV2) UnmarshalJSON(data []byte) error {
+	type plain CharacterCardV2
+	return decodeWithUnknown(data, (*plain)(c), &c.Unknown)
+}
+
+func (d CardData) MarshalJSON() ([]byte, error) {
+	type plain CardData
+	return encodeWithUnknown(plain(d), d.Unknown)
+}
+
+func (d *CardData) UnmarshalJSON(data []byte) error {
+	type plain CardData
+	return decodeWithUnknown(data, (*plain)(d), &d.Unknown)
+}
+
+func (l Lorebook) MarshalJSON() ([]byte, error) {
+	type plain Lorebook
+	return encodeWithUnknown(plain(l), l.Unknown)
+}
+
+func (l *Lorebook) UnmarshalJSON(data []byte) error {
+	type plain Lorebook
+	return decodeWithUnknown(data, (*plain)(l), &l.Unknown)
+}
+
+func (e LorebookEntry) MarshalJSON() ([]byte, error) {
+	type plain LorebookEntry
+	return encodeWithUnknown(plain(e), e.Unknown)
+}
+
+func (e *LorebookEntry) UnmarshalJSON(data []byte) error {
+	type plain LorebookEntry
+	return decodeWithUnknown(data, (*plain)(e), &e.Unknown)
+}
+
+func (c CharacterCardV3) MarshalJSON() ([]byte, error) {
+	type plain CharacterCardV3
+	return encodeWithUnknown(plain(c), c.Unknown)
+}
+
+func (c *CharacterCardV3) UnmarshalJSON(data []byte) error {
+	type plain CharacterCardV3
+	return decodeWithUnknown(data, (*plain)(c), &c.Unknown)
+}
+
+func (a CardAsset) MarshalJSON() ([]byte, error) {
+	type plain CardAsset
+	return encodeWithUnknown(plain(a), a.Unknown)
+}
+
+func (a *CardAsset) UnmarshalJSON(data []byte) error {
+	type plain CardAsset
+	return decodeWithUnknown(data, (*plain)(a), &a.Unknown)
+}
+
+func (w WorldInfo) MarshalJSON() ([]byte, error) {
+	type plain WorldInfo
+	return encodeWithUnknown(plain(w), w.Unknown)
+}
+
+func (w *WorldInfo) UnmarshalJSON(data []byte) error {
+	type plain WorldInfo
+	return decodeWithUnknown(data, (*plain)(w), &w.Unknown)
+}
+
+func (e WorldInfoEntry) MarshalJSON() ([]byte, error) {
+	type plain WorldInfoEntry
+	return encodeWithUnknown(plain(e), e.Unknown)
+}
+
+func (e *WorldInfoEntry) UnmarshalJSON(data []byte) error {
+	type plain WorldInfoEntry
+	return decodeWithUnknown(data, (*plain)(e), &e.Unknown)
+}
+
+// CardDataV3 would otherwise inherit the methods of its embedded CardData and encode only the
+// V2 fields. Its unknown members are kept in CardData.Unknown.
+
+func (d CardDataV3) MarshalJSON() ([]byte, error) {
+	type plain CardData
+	v2, err := json.Marshal(plain(d.CardData))
+	if err != nil {
+		return nil, err
+	}
+	v3, err := json.Marshal(d.CardV3Fields)
+	if err != nil {
+		return nil, err
+	}
+	return appendUnknown(joinObjects(v2, v3), d.Unknown, reflect.TypeOf(d)), nil
+}
+
+func (d *CardDataV3) UnmarshalJSON(data []byte) error {
+	if err := d.CardData.UnmarshalJSON(data); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &d.CardV3Fields); err != nil {
+		return err
+	}
+	d.Unknown = withoutKnown(d.Unknown, reflect.TypeOf(d.CardV3Fields))
+	return nil
+}
+
+// encodeWithUnknown encodes known, a struct, followed by the unknown members.
+func encodeWithUnknown(known any, unknown UnknownFields) ([]byte, error) {
+	data, err := json.Marshal(known)
+	if err != nil {
+		return nil, err
+	}
+	return appendUnknown(data, unknown, reflect.TypeOf(known)), nil
+}
+
+// decodeWithUnknown decodes data into known, a pointer to a struct, and the members it has no
+// field for into unknown.
+func decodeWithUnknown(data []byte, known any, unknown *UnknownFields) error {
+	if err := json.Unmarshal(data, known); err != nil {
+		return err
+	}
+	var members UnknownFields
+	if err := json.Unmarshal(data, &members); err != nil {
+		return err
+	}
+	*unknown = withoutKnown(members, reflect.TypeOf(known).Elem())
+	return nil
+}
+
+// appendUnknown adds the unknown members to the encoded object, skipping any that t now has a
+// field for.
+func appendUnknown(object []byte, unknown UnknownFields, t reflect.Type) []byte {
+	unknown = withoutKnown(unknown, t)
+	if len(unknown) == 0 {
+		return object
+	}
+	var b bytes.Buffer
+	b.Write(object[:len(object)-1])
+	for i, name := range slices.Sorted(maps.Keys(unknown)) {
+		if i > 0 || len(object) > 2 {
+			b.WriteByte(',')
+		}
+		key, _ := json.Marshal(name)
+		b.Write(key)
+		b.WriteByte(':')
+		b.Write(unknown[name])
+	}
+	b.WriteByte('}')
+	return b.Bytes()
+}
+
+// joinObjects merges two encoded objects into one.
+func joinObjects(a, b []byte) []byte {
+	switch {
+	case len(b) <= 2:
+		return a
+	case len(a) <= 2:
+		return b
+	}
+	joined := append(bytes.Clone(a[:len(a)-1]), ',')
+	return append(joined, b[1:]...)
+}
+
+// withoutKnown returns the members of unknown that none of t's fields decode, or nil if there
+// are none. Like encoding/json, names are matched case-insensitively.
+func withoutKnown(unknown UnknownFields, t reflect.Type) UnknownFields {
+	if len(unknown) == 0 {
+		return nil
+	}
+	known := knownNames(t)
+	var rest UnknownFields
+	for name, value := range unknown {
+		if slices.ContainsFunc(known, func(k string) bool { return strings.EqualFold(k, name) }) {
+			continue
+		}
+		if rest == nil {
+			rest = make(UnknownFields)
+		}
+		rest[name] = value
+	}
+	return rest
+}
+
+var knownNamesCache sync.Map // reflect.Type -> []string
+
+// knownNames lists the JSON names of t's fields, including those of embedded structs.
+func knownNames(t reflect.Type) []string {
+	if names, ok := knownNamesCache.Load(t); ok {
+		return names.([]string)
+	}
+	var names []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			names = append(names, knownNames(field.Type)...)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		names = append(names, name)
+	}
+	knownNamesCache.Store(t, names)
+	return names
+}
@@ -0,0 +1,616 @@
+package prompts
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"log"
+	"maps"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"text/template"
+	"text/template/parse"
+	"time"
+
+	"workspace/FictionGeminiRewritten/internal/models"
+)
+
+//go:embed templates
+var embedded embed.FS
+
+const (
+	embeddedDir      = "templates"
+	partialsDir      = "partials"
+	manifestFileName = "manifest.json"
+
+	// SourceEmbedded is the source of templates built into the binary.
+	SourceEmbedded = "embedded"
+)
+
+// funcs lets templates write SillyTavern's {{user}} and {{char}} macros as they are, for the
+// model to copy into the cards it writes.
+var funcs = template.FuncMap{
+	"user": func() string { return "{{user}}" },
+	"char": func() string { return "{{char}}" },
+}
+
+// manifest is the layout of manifest.json.
+type manifest struct {
+	Templates []manifestEntry `json:"templates"`
+}
+
+// manifestEntry describes one template. In an override manifest, fields left out keep the
+// embedded entry's values.
+type manifestEntry struct {
+	Name              string         `json:"name"`
+	File              string         `json:"file"`
+	Version           string         `json:"version"`
+	Description       string         `json:"description,omitempty"`
+	RequiredVariables []string       `json:"required_variables"`
+	OutputKind        string         `json:"output_kind"`
+	Variants          []variantEntry `json:"variants,omitempty"`
+}
+
+// variantEntry is an alternative version of a template, for experiments. It is rendered with
+// the same data and must use the same variables as the template's default version.
+type variantEntry struct {
+	File        string `json:"file"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+// Template is one loaded version of a prompt template.
+type Template struct {
+	Name              string
+	Version           string
+	Description       string
+	RequiredVariables []string
+	OutputKind        string
+	Source            string // SourceEmbedded, or the override file it was read from
+	Text              string
+
+	tmpl *template.Template
+}
+
+// Registry serves the prompt templates: the embedded defaults, with any found in an override
+// directory in their place. The directory may hold template files named as in the embedded
+// manifest, partials named as in templates/partials under its own partials/, and a
+// manifest.json whose entries change or extend the embedded ones, including variants of a
+// template for experiments. Reload (or Watch) picks up changes to the directory;
+// a set that fails validation is never used, so a bad edit leaves the previous templates in
+// place and is reported by ReloadError.
+type Registry struct {
+	dir string
+
+	mu          sync.RWMutex
+	templates   map[string][]*Template // Every version of each template, the default first
+	order       []string               // Template names in manifest order
+	fingerprint string                 // Of the override directory as last loaded
+	reloadErr   error
+}
+
+// NewRegistry loads the embedded templates and the overrides in dir, if dir isn't empty.
+// It fails if any template is invalid.
+func NewRegistry(dir string) (*Registry, error) {
+	r := &Registry{dir: dir}
+	fingerprint, err := fingerprintDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	templates, order, err := loadTemplates(dir)
+	if err != nil {
+		return nil, err
+	}
+	r.templates, r.order, r.fingerprint = templates, order, fingerprint
+	return r, nil
+}
+
+// Select fixes the templates a request renders: the default version of each, or the version
+// named in versions. A request keeps its selection even if the templates are reloaded while it
+// runs. Versions that don't exist are reported, and the default is selected in their place.
+func (r *Registry) Select(versions map[string]string) (Selection, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	sel := Selection{templates: make(map[string]*Template, len(r.templates))}
+	var errs []error
+	for _, name := range r.order {
+		all := r.templates[name]
+		sel.templates[name] = all[0]
+		version, ok := versions[name]
+		if !ok {
+			continue
+		}
+		i := slices.IndexFunc(all, func(t *Template) bool { return t.Version == version })
+		if i < 0 {
+			errs = append(errs, fmt.Errorf("template '%s' has no version '%s'", name, version))
+			continue
+		}
+		sel.templates[name] = all[i]
+	}
+	return sel, errors.Join(errs...)
+}
+
+// Assign picks a version of every template that has variants, uniformly from its default and
+// variants. The pick depends only on seed and the template's versions, so the same seed always
+// assigns the same versions while the manifest is unchanged.
+func (r *Registry) Assign(seed int64) map[string]string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	assigned := make(map[string]string)
+	for _, name := range r.order {
+		all := r.templates[name]
+		if len(all) < 2 {
+			continue
+		}
+		hash := fnv.New64a()
+		hash.Write([]byte(name))
+		rng := rand.New(rand.NewPCG(uint64(seed), hash.Sum64()))
+		assigned[name] = all[rng.IntN(len(all))].Version
+	}
+	return assigned
+}
+
+// List describes every template, in manifest order.
+func (r *Registry) List() []models.PromptTemplateInfo {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	infos := make([]models.PromptTemplateInfo, 0, len(r.order))
+	for _, name := range r.order {
+		all := r.templates[name]
+		t := all[0]
+		info := models.PromptTemplateInfo{
+			Name:              t.Name,
+			Version:           t.Version,
+			Description:       t.Description,
+			RequiredVariables: t.RequiredVariables,
+			OutputKind:        t.OutputKind,
+			Source:            t.Source,
+		}
+		for _, variant := range all[1:] {
+			info.Variants = append(info.Variants, models.PromptVariantInfo{
+				Version:     variant.Version,
+				Description: variant.Description,
+				Source:      variant.Source,
+			})
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+// ReloadError returns why the override directory's current contents were rejected, or nil
+// if the loaded templates are up to date.
+func (r *Registry) ReloadError() error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.reloadErr
+}
+
+// Reload reloads the templates if the override directory changed since the last load, and
+// reports whether it did.
+func (r *Registry) Reload() (bool, error) {
+	if r.dir == "" {
+		return false, nil
+	}
+	fingerprint, err := fingerprintDir(r.dir)
+	r.mu.RLock()
+	unchanged := err == nil && fingerprint == r.fingerprint
+	r.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	var templates map[string][]*Template
+	var order []string
+	if err == nil {
+		templates, order, err = loadTemplates(r.dir)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.fingerprint = fingerprint // A rejected directory isn't retried until it changes again
+	if err != nil {
+		r.reloadErr = err
+		return false, err
+	}
+	r.templates, r.order, r.reloadErr = templates, order, nil
+	return true, nil
+}
+
+// Watch reloads the templates every interval until ctx is done, logging what changed.
+func (r *Registry) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reloaded, err := r.Reload()
+			switch {
+			case err != nil:
+				log.Printf("Prompt templates in %s rejected, keeping the previous ones:\n%v", r.dir, err)
+			case reloaded:
+				log.Printf("Reloaded prompt templates from %s", r.dir)
+			}
+		}
+	}
+}
+
+// loadTemplates reads and validates the embedded templates with the overrides in dir.
+func loadTemplates(dir string) (map[string][]*Template, []string, error) {
+	entries, err := readManifest(embedded, embeddedDir+"/"+manifestFileName)
+	if err != nil {
+		return nil, nil, fmt.Errorf("embedded prompt manifest: %w", err)
+	}
+	if dir != "" {
+		overrides, err := readManifest(os.DirFS(dir), manifestFileName)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, nil, err
+		}
+		entries = mergeManifest(entries, overrides)
+	}
+
+	partials, err := loadPartials(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	templates := make(map[string][]*Template, len(entries))
+	var order []string
+	var errs []error
+	for _, entry := range entries {
+		versions, err := loadVersions(entry, partials, dir)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		templates[entry.Name] = versions
+		order = append(order, entry.Name)
+	}
+	for name := range templateData {
+		if !slices.ContainsFunc(entries, func(e manifestEntry) bool { return e.Name == name }) {
+			errs = append(errs, fmt.Errorf("template '%s' is missing from the manifest", name))
+		}
+	}
+	if len(errs) > 0 {
+		return nil, nil, errors.Join(errs...)
+	}
+	return templates, order, nil
+}
+
+// loadPartials reads the partials every template is parsed with, by file name: the files in
+// templates/partials, each replaced by the file of the same name in dir's partials/, if any.
+// Partials {{define}} templates the prompts include with {{template}}; they are passed no data.
+func loadPartials(dir string) (map[string]string, error) {
+	files, err := fs.Glob(embedded, embeddedDir+"/"+partialsDir+"/*.tmpl")
+	if err != nil {
+		return nil, err
+	}
+	partials := make(map[string]string, len(files))
+	for _, file := range files {
+		text, err := fs.ReadFile(embedded, file)
+		if dir != "" {
+			path := filepath.Join(dir, partialsDir, filepath.Base(file))
+			if override, overrideErr := os.ReadFile(path); overrideErr == nil {
+				text, err = override, nil
+			} else if !errors.Is(overrideErr, fs.ErrNotExist) {
+				err = overrideErr
+			}
+		}
+		name := filepath.Base(file)
+		if err != nil {
+			return nil, fmt.Errorf("prompt partial '%s': %w", name, err)
+		}
+		if err := checkPartial(name, string(text)); err != nil {
+			return nil, err
+		}
+		partials[name] = string(text)
+	}
+	return partials, nil
+}
+
+// checkPartial parses a partial on its own, so a bad one is reported once rather than with
+// every template, and checks it uses no data fields, since it is passed none.
+func checkPartial(name, text string) error {
+	tmpl, err := template.New(name).Funcs(funcs).Parse(text)
+	if err != nil {
+		return fmt.Errorf("failed to parse prompt partial '%s': %w", name, err)
+	}
+	var fields []string
+	for _, defined := range tmpl.Templates() {
+		if defined.Tree != nil {
+			walkFields(defined.Tree.Root, func(field string) { fields = append(fields, field) })
+		}
+	}
+	if len(fields) > 0 {
+		return fmt.Errorf("prompt partial '%s' uses %s, but partials are passed no data", name, strings.Join(fields, ", "))
+	}
+	return nil
+}
+
+// loadVersions loads the default version of a manifest entry followed by its variants.
+func loadVersions(entry manifestEntry, partials map[string]string, dir string) ([]*Template, error) {
+	switch {
+	case templateData[entry.Name] == nil:
+		return nil, fmt.Errorf("unknown template '%s' in manifest", entry.Name)
+	case entry.OutputKind != OutputJSON && entry.OutputKind != OutputText:
+		return nil, fmt.Errorf("template '%s' has output kind '%s' (use '%s' or '%s')", entry.Name, entry.OutputKind, OutputJSON, OutputText)
+	}
+	variants := append([]variantEntry{{File: entry.File, Version: entry.Version, Description: entry.Description}}, entry.Variants...)
+	versions := make([]*Template, 0, len(variants))
+	var errs []error
+	for i, variant := range variants {
+		if slices.ContainsFunc(variants[:i], func(v variantEntry) bool { return v.Version == variant.Version }) {
+			errs = append(errs, fmt.Errorf("template '%s' lists version '%s' more than once", entry.Name, variant.Version))
+			continue
+		}
+		t, err := loadTemplate(entry, variant, partials, dir)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		versions = append(versions, t)
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return versions, nil
+}
+
+// loadTemplate reads one version of a template, from dir if its file is there and embedded
+// otherwise, parses it with the partials, and checks it against the data the orchestrator
+// renders it with.
+func loadTemplate(entry manifestEntry, variant variantEntry, partials map[string]string, dir string) (*Template, error) {
+	if variant.File == "" || variant.Version == "" {
+		return nil, fmt.Errorf("every version of template '%s' needs a file and a version in the manifest", entry.Name)
+	}
+
+	t := &Template{
+		Name:              entry.Name,
+		Version:           variant.Version,
+		Description:       variant.Description,
+		RequiredVariables: entry.RequiredVariables,
+		OutputKind:        entry.OutputKind,
+		Source:            SourceEmbedded,
+	}
+	text, err := fs.ReadFile(embedded, embeddedDir+"/"+variant.File)
+	if dir != "" {
+		path := filepath.Join(dir, variant.File)
+		if override, overrideErr := os.ReadFile(path); overrideErr == nil {
+			text, err, t.Source = override, nil, path
+		} else if !errors.Is(overrideErr, fs.ErrNotExist) {
+			err = overrideErr
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("template '%s' version '%s': %w", entry.Name, variant.Version, err)
+	}
+	t.Text = string(text)
+
+	t.tmpl, err = template.New(entry.Name).Funcs(funcs).Parse(t.Text)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse template '%s' (%s): %w", entry.Name, t.Source, err)
+	}
+	for _, file := range slices.Sorted(maps.Keys(partials)) {
+		if _, err := t.tmpl.New(file).Parse(partials[file]); err != nil {
+			return nil, fmt.Errorf("template '%s' (%s) clashes with prompt partial '%s': %w", entry.Name, t.Source, file, err)
+		}
+	}
+	if err := checkVariables(t, reflect.TypeOf(templateData[entry.Name])); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// checkVariables checks that the orchestrator supplies every variable the manifest requires,
+// and that the template only uses required variables, even in branches a given render doesn't
+// reach. Fields inside range and with blocks are relative to another value and skipped,
+// except those reached through $.
+func checkVariables(t *Template, dataType reflect.Type) error {
+	var unsupplied, undeclared []string
+	for _, name := range t.RequiredVariables {
+		if _, ok := dataType.FieldByName(name); !ok {
+			unsupplied = append(unsupplied, name)
+		}
+	}
+	use := func(field string) {
+		if !slices.Contains(t.RequiredVariables, field) && !slices.Contains(undeclared, field) {
+			undeclared = append(undeclared, field)
+		}
+	}
+	walkFields(t.tmpl.Tree.Root, use)
+
+	var problems []string
+	if len(unsupplied) > 0 {
+		problems = append(problems, fmt.Sprintf("requires %s, which the orchestrator doesn't supply", strings.Join(unsupplied, ", ")))
+	}
+	if len(undeclared) > 0 {
+		problems = append(problems, fmt.Sprintf("uses %s, missing from its required_variables", strings.Join(undeclared, ", ")))
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("template '%s' (%s) %s", t.Name, t.Source, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// walkFields calls use with the name of every top-level data field a template references.
+func walkFields(root parse.Node, use func(field string)) {
+	var walkPipe func(pipe *parse.PipeNode, atRoot bool)
+	var walkArg func(arg parse.Node, atRoot bool)
+	var walk func(node parse.Node, atRoot bool)
+	walkArg = func(arg parse.Node, atRoot bool) {
+		switch n := arg.(type) {
+		case *parse.FieldNode:
+			if atRoot {
+				use(n.Ident[0])
+			}
+		case *parse.VariableNode:
+			if n.Ident[0] == "$" && len(n.Ident) > 1 {
+				use(n.Ident[1])
+			}
+		case *parse.ChainNode:
+			walkArg(n.Node, atRoot)
+		case *parse.PipeNode:
+			walkPipe(n, atRoot)
+		}
+	}
+	walkPipe = func(pipe *parse.PipeNode, atRoot bool) {
+		if pipe == nil {
+			return
+		}
+		for _, cmd := range pipe.Cmds {
+			for _, arg := range cmd.Args {
+				walkArg(arg, atRoot)
+			}
+		}
+	}
+	walk = func(node parse.Node, atRoot bool) {
+		switch n := node.(type) {
+		case *parse.ListNode:
+			if n == nil {
+				return
+			}
+			for _, child := range n.Nodes {
+				walk(child, atRoot)
+			}
+		case *parse.ActionNode:
+			walkPipe(n.Pipe, atRoot)
+		case *parse.TemplateNode:
+			walkPipe(n.Pipe, atRoot)
+		case *parse.IfNode:
+			walkPipe(n.Pipe, atRoot)
+			walk(n.List, atRoot)
+			walk(n.ElseList, atRoot)
+		case *parse.RangeNode:
+			walkPipe(n.Pipe, atRoot)
+			walk(n.List, false)
+			walk(n.ElseList, atRoot)
+		case *parse.WithNode:
+			walkPipe(n.Pipe, atRoot)
+			walk(n.List, false)
+			walk(n.ElseList, atRoot)
+		}
+	}
+	walk(root, true)
+}
+
+func readManifest(fsys fs.FS, path string) ([]manifestEntry, error) {
+	data, err := fs.ReadFile(fsys, path)
+	if err != nil {
+		return nil, err
+	}
+	var m manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse prompt manifest %s: %w", path, err)
+	}
+	return m.Templates, nil
+}
+
+// mergeManifest applies override entries to the embedded ones by name; entries for other
+// names are appended.
+func mergeManifest(entries, overrides []manifestEntry) []manifestEntry {
+	merged := slices.Clone(entries)
+	for _, override := range overrides {
+		i := slices.IndexFunc(merged, func(e manifestEntry) bool { return e.Name == override.Name })
+		if i < 0 {
+			merged = append(merged, override)
+			continue
+		}
+		entry := &merged[i]
+		if override.File != "" {
+			entry.File = override.File
+		}
+		if override.Version != "" {
+			entry.Version = override.Version
+		}
+		if override.Description != "" {
+			entry.Description = override.Description
+		}
+		if override.RequiredVariables != nil {
+			entry.RequiredVariables = override.RequiredVariables
+		}
+		if override.OutputKind != "" {
+			entry.OutputKind = override.OutputKind
+		}
+		if override.Variants != nil {
+			entry.Variants = override.Variants
+		}
+	}
+	return merged
+}
+
+// fingerprintDir summarizes the names, sizes and modification times of the files in dir and
+// its partials/, so changes can be detected without reading them.
+func fingerprintDir(dir string) (string, error) {
+	if dir == "" {
+		return "", nil
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", fmt.Errorf("failed to read prompt directory: %w", err)
+	}
+	partials, err := os.ReadDir(filepath.Join(dir, partialsDir))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return "", fmt.Errorf("failed to read prompt partials directory: %w", err)
+	}
+	var b strings.Builder
+	for _, entry := range slices.Concat(entries, partials) {
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		fmt.Fprintf(&b, "%s:%d:%d;", entry.Name(), info.Size(), info.ModTime().UnixNano())
+	}
+	return b.String(), nil
+}
+
+// Selection is the set of template versions one request renders. It is safe for concurrent use.
+type Selection struct {
+	templates map[string]*Template
+}
+
+// Render executes the named template with data, which must be the template's data type
+// (e.g. NarratorCardData for NarratorCard).
+func (s Selection) Render(name string, data any) (string, error) {
+	t, ok := s.templates[name]
+	if !ok {
+		return "", fmt.Errorf("unknown prompt template '%s'", name)
+	}
+	if want := reflect.TypeOf(templateData[name]); reflect.TypeOf(data) != want {
+		return "", fmt.Errorf("template '%s' is rendered with %s, not %T", name, want, data)
+	}
+	var out bytes.Buffer
+	if err := t.tmpl.Execute(&out, data); err != nil {
+		return "", fmt.Errorf("failed to execute template '%s' version '%s': %w", name, t.Version, err)
+	}
+	return out.String(), nil
+}
+
+// Template returns the selected version of the named template.
+func (s Selection) Template(name string) (*Template, bool) {
+	t, ok := s.templates[name]
+	return t, ok
+}
+
+// Versions returns the selected version of each named template, or of every template if no
+// names are given.
+func (s Selection) Versions(names ...string) map[string]string {
+	versions := make(map[string]string)
+	for name, t := range s.templates {
+		if len(names) == 0 || slices.Contains(names, name) {
+			versions[name] = t.Version
+		}
+	}
+	return versions
+}
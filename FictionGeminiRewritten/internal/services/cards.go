@@ -0,0 +1,167 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"workspace/FictionGeminiRewritten/internal/cards"
+	"workspace/FictionGeminiRewritten/internal/models"
+)
+
+// Cards are always generated, checkpointed and passed between steps as V2; the request's card
+// format only decides how they are saved and returned.
+
+// avatarFileName is where a session keeps the avatar it was started with, so that cards
+// regenerated on resume get the same image.
+const avatarFileName = "avatar.png"
+
+var (
+	// ErrSessionNotFound is returned when no session directory has the requested log identifier.
+	ErrSessionNotFound = errors.New("no session found for this log identifier")
+
+	// ErrCardNotFound is returned when a session has no PNG card of the requested name.
+	ErrCardNotFound = errors.New("card not found")
+)
+
+// exportCard returns card in the session's card format. V3 cards are dated when their session
+// was created, so a card reused from a checkpoint is exported exactly as it was first saved.
+func (a *aiSession) exportCard(card models.CharacterCardV2) interface{} {
+	if a.cardFormat != models.CardFormatV3 {
+		return card
+	}
+	v3 := cards.V2ToV3(card)
+	if v3.Data.CreationDate == 0 {
+		v3.Data.CreationDate = a.cardDate
+	}
+	if v3.Data.ModificationDate == 0 {
+		v3.Data.ModificationDate = a.cardDate
+	}
+	return v3
+}
+
+// saveCardPNG embeds card into the session's avatar, as V2 and, for V3 sessions, as V3 too,
+// and saves it next to the card's JSON file. It returns the PNG's path, or "" if it could not
+// be saved, which is reported but doesn't fail the step.
+func (a *aiSession) saveCardPNG(card models.CharacterCardV2, jsonPath string) string {
+	var v3 *models.CharacterCardV3
+	if exported, ok := a.exportCard(card).(models.CharacterCardV3); ok {
+		v3 = &exported
+	}
+	avatar := a.avatar
+	if avatar == nil {
+		avatar = cards.DefaultAvatar()
+	}
+	data, err := cards.EncodePNG(avatar, card, v3)
+	if err == nil {
+		var path string
+		if path, err = SavePNGNextToFile(jsonPath, data); err == nil {
+			a.events.Message(fmt.Sprintf("  Saved PNG card to: %s\n", path))
+			return path
+		}
+	}
+	a.events.Message(fmt.Sprintf("  WARNING: failed to save PNG card for '%s': %v\n", card.Data.Name, err))
+	return ""
+}
+
+// sessionAvatar returns the avatar a session's cards are embedded into: the request's, which
+// is saved in the session directory, or else the one saved when the session started. It
+// returns nil if there is neither.
+func sessionAvatar(payload models.RequestPayload, sessionDir string) ([]byte, error) {
+	path := filepath.Join(sessionDir, avatarFileName)
+	if payload.Avatar == "" {
+		avatar, err := os.ReadFile(path)
+		if err != nil {
+			return nil, nil
+		}
+		return avatar, nil
+	}
+
+	avatar, err := cards.DecodeAvatar(payload.Avatar)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(sessionDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to save avatar: %w", err)
+	}
+	if err := os.WriteFile(path, avatar, 0644); err != nil {
+		return nil, fmt.Errorf("failed to save avatar: %w", err)
+	}
+	return avatar, nil
+}
+
+// sessionCreated returns when the checkpoint's session was created, in Unix seconds, or the
+// current time if that can't be read.
+func sessionCreated(cp *checkpoint) int64 {
+	created, err := time.Parse(time.RFC3339, cp.CreatedAt)
+	if err != nil {
+		return time.Now().Unix()
+	}
+	return created.Unix()
+}
+
+// cardURL returns where the PNG card at path can be downloaded, or "" if path is empty.
+func cardURL(logIdentifier, path string) string {
+	if path == "" {
+		return ""
+	}
+	return fmt.Sprintf("/cards/%s/%s", logIdentifier, filepath.Base(path))
+}
+
+// SessionCards lists the PNG cards saved for a session, by file name.
+func SessionCards(logIdentifier string) (models.CardFileList, error) {
+	list := models.CardFileList{LogIdentifier: logIdentifier, Cards: []models.CardFile{}}
+	dir, err := sessionDirOf(logIdentifier)
+	if err != nil {
+		return list, err
+	}
+	paths, _ := filepath.Glob(filepath.Join(dir, "*.png"))
+	sort.Strings(paths)
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil || filepath.Base(path) == avatarFileName {
+			continue
+		}
+		list.Cards = append(list.Cards, models.CardFile{
+			File:       info.Name(),
+			URL:        cardURL(logIdentifier, path),
+			Size:       info.Size(),
+			ModifiedAt: info.ModTime().Format(time.RFC3339),
+		})
+	}
+	return list, nil
+}
+
+// CardPNGPath returns the path of a session's PNG card, given its file name.
+func CardPNGPath(logIdentifier, fileName string) (string, error) {
+	if fileName != filepath.Base(fileName) || !strings.HasSuffix(fileName, ".png") || fileName == avatarFileName {
+		return "", ErrCardNotFound
+	}
+	dir, err := sessionDirOf(logIdentifier)
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, fileName)
+	if info, err := os.Stat(path); err != nil || !info.Mode().IsRegular() {
+		return "", ErrCardNotFound
+	}
+	return path, nil
+}
+
+// sessionDirOf locates a session's directory by log identifier, as loadCheckpoint does.
+func sessionDirOf(logIdentifier string) (string, error) {
+	if !validLogIdentifier.MatchString(logIdentifier) || logIdentifier == "." || logIdentifier == ".." || logIdentifier == workspaceDirName {
+		return "", fmt.Errorf("invalid log identifier '%s'", logIdentifier)
+	}
+	matches, _ := filepath.Glob(filepath.Join(baseJSONSaveDir, "*", logIdentifier))
+	for _, match := range matches {
+		if info, err := os.Stat(match); err == nil && info.IsDir() {
+			return match, nil
+		}
+	}
+	return "", ErrSessionNotFound
+}
@@ -0,0 +1,246 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"workspace/FictionGeminiRewritten/internal/models"
+)
+
+const checkpointFileName = "checkpoint.json"
+
+// ErrCheckpointNotFound is returned when a resume request names a session with no checkpoint on disk.
+var ErrCheckpointNotFound = errors.New("no checkpoint found for this log identifier")
+
+var validLogIdentifier = regexp.MustCompile(`^[a-zA-Z0-9_\-\.]+$`)
+
+// Checkpoint status values.
+const (
+	checkpointSucceeded = "succeeded"
+	checkpointFailed    = "failed"
+)
+
+// checkpoint is the on-disk record of a generation session, saved as checkpoint.json next to
+// the session's artifacts after every step. It holds the original request (minus the API key)
+// and each step's output, so a later request can resume the session, even after a restart,
+// re-running only the steps that failed or never ran.
+type checkpoint struct {
+	path string
+
+	Series          string                     `json:"series"`
+	Option          string                     `json:"option"`
+	Model           string                     `json:"model"`
+	ToolCardPurpose string                     `json:"toolCardPurpose,omitempty"`
+	LorebookMode    string                     `json:"lorebook_mode,omitempty"`
+	CardFormat      string                     `json:"card_format,omitempty"`
+	ContextCards    []string                   `json:"context_cards,omitempty"`
+	CreatedAt       string                     `json:"created_at"`
+	UpdatedAt       string                     `json:"updated_at"`
+	Steps           map[string]*checkpointStep `json:"steps"`
+
+	Generation     *models.GenerationConfig           `json:"generation,omitempty"`
+	StepGeneration map[string]models.GenerationConfig `json:"step_generation,omitempty"`
+	SafetySettings map[string]string                  `json:"safety_settings,omitempty"`
+	StepModels     map[string]models.ModelRoute       `json:"step_models,omitempty"`
+	FallbackModels []string                           `json:"fallback_models,omitempty"`
+
+	RepairAttempts    *int `json:"repair_attempts,omitempty"`
+	MaxContinuations  *int `json:"max_continuations,omitempty"`
+	FanoutConcurrency int  `json:"fanout_concurrency,omitempty"`
+	FanoutBatchSize   int  `json:"fanout_batch_size,omitempty"`
+
+	Prompts *models.PromptAssignment `json:"prompts,omitempty"` // Template versions the session renders
+}
+
+// checkpointStep is the outcome of one step. Output holds the step's parsed result (card,
+// lorebook, summary text or tool suggestions) as JSON.
+type checkpointStep struct {
+	Status     string          `json:"status"`
+	Error      string          `json:"error,omitempty"`
+	Output     json.RawMessage `json:"output,omitempty"`
+	FinishedAt string          `json:"finished_at"`
+}
+
+// checkpointPath returns where the checkpoint for a session is stored.
+func checkpointPath(seriesName, logIdentifier string) string {
+	return filepath.Join(baseJSONSaveDir, SanitizeStringForPath(seriesName, true), logIdentifier, checkpointFileName)
+}
+
+// newCheckpoint starts an empty checkpoint for a fresh session.
+func newCheckpoint(payload models.RequestPayload, logIdentifier string) *checkpoint {
+	now := time.Now().Format(time.RFC3339)
+	return &checkpoint{
+		path:            checkpointPath(payload.Series, logIdentifier),
+		Series:          payload.Series,
+		Option:          payload.Option,
+		Model:           payload.Model,
+		ToolCardPurpose: payload.ToolCardPurpose,
+		LorebookMode:    payload.LorebookMode,
+		CardFormat:      payload.CardFormat,
+		ContextCards:    payload.ContextCards,
+		CreatedAt:       now,
+		UpdatedAt:       now,
+		Steps:           make(map[string]*checkpointStep),
+		Generation:      payload.Generation,
+		StepGeneration:  payload.StepGeneration,
+		SafetySettings:  payload.SafetySettings,
+		StepModels:      payload.StepModels,
+		FallbackModels:  payload.FallbackModels,
+
+		RepairAttempts:    payload.RepairAttempts,
+		MaxContinuations:  payload.MaxContinuations,
+		FanoutConcurrency: payload.FanoutConcurrency,
+		FanoutBatchSize:   payload.FanoutBatchSize,
+	}
+}
+
+// loadCheckpoint reads the checkpoint of an earlier session. The series directory is not known
+// up front, so it is located by log identifier.
+func loadCheckpoint(logIdentifier string) (*checkpoint, error) {
+	if !validLogIdentifier.MatchString(logIdentifier) || logIdentifier == "." || logIdentifier == ".." {
+		return nil, fmt.Errorf("invalid log identifier '%s'", logIdentifier)
+	}
+	matches, err := filepath.Glob(filepath.Join(baseJSONSaveDir, "*", logIdentifier, checkpointFileName))
+	if err != nil || len(matches) == 0 {
+		return nil, ErrCheckpointNotFound
+	}
+
+	data, err := os.ReadFile(matches[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint %s: %w", matches[0], err)
+	}
+	cp := &checkpoint{}
+	if err := json.Unmarshal(data, cp); err != nil {
+		return nil, fmt.Errorf("failed to parse checkpoint %s: %w", matches[0], err)
+	}
+	cp.path = matches[0]
+	if cp.Steps == nil {
+		cp.Steps = make(map[string]*checkpointStep)
+	}
+	return cp, nil
+}
+
+// ResumePayload prepares a request that resumes the session named by payload.ResumeLogIdentifier.
+// Series, option and tool card purpose always come from the checkpoint; the model, lorebook mode, card format,
+// context cards, generation parameters, safety settings, model routes, repair and continuation limits and fan-out
+// settings may be overridden by the request and otherwise default to the ones originally used.
+func ResumePayload(payload models.RequestPayload) (models.RequestPayload, error) {
+	cp, err := loadCheckpoint(payload.ResumeLogIdentifier)
+	if err != nil {
+		return payload, err
+	}
+	payload.Series = cp.Series
+	payload.Option = cp.Option
+	payload.ToolCardPurpose = cp.ToolCardPurpose
+	if payload.Model == "" {
+		payload.Model = cp.Model
+	}
+	if payload.LorebookMode == "" {
+		payload.LorebookMode = cp.LorebookMode
+	}
+	if payload.CardFormat == "" {
+		payload.CardFormat = cp.CardFormat
+	}
+	if payload.ContextCards == nil {
+		payload.ContextCards = cp.ContextCards
+	}
+	if payload.Generation == nil && payload.StepGeneration == nil {
+		payload.Generation = cp.Generation
+		payload.StepGeneration = cp.StepGeneration
+	}
+	if payload.SafetySettings == nil {
+		payload.SafetySettings = cp.SafetySettings
+	}
+	if payload.StepModels == nil && payload.FallbackModels == nil {
+		payload.StepModels = cp.StepModels
+		payload.FallbackModels = cp.FallbackModels
+	}
+	if payload.RepairAttempts == nil {
+		payload.RepairAttempts = cp.RepairAttempts
+	}
+	if payload.MaxContinuations == nil {
+		payload.MaxContinuations = cp.MaxContinuations
+	}
+	if payload.FanoutConcurrency == 0 && payload.FanoutBatchSize == 0 {
+		payload.FanoutConcurrency = cp.FanoutConcurrency
+		payload.FanoutBatchSize = cp.FanoutBatchSize
+	}
+	return payload, nil
+}
+
+// succeeded returns the saved output of key if that step succeeded in an earlier run.
+func (cp *checkpoint) succeeded(key string) (json.RawMessage, bool) {
+	step, ok := cp.Steps[key]
+	if !ok || step.Status != checkpointSucceeded || len(step.Output) == 0 {
+		return nil, false
+	}
+	return step.Output, true
+}
+
+// record stores the outcome of a step and writes the checkpoint to disk. Failing to save is
+// logged but never fails the generation itself.
+func (cp *checkpoint) record(key string, output interface{}, stepErr error) {
+	now := time.Now().Format(time.RFC3339)
+	step := &checkpointStep{Status: checkpointSucceeded, FinishedAt: now}
+	if stepErr != nil {
+		step.Status = checkpointFailed
+		step.Error = stepErr.Error()
+	} else if data, err := json.Marshal(output); err == nil {
+		step.Output = data
+	}
+	cp.Steps[key] = step
+	cp.UpdatedAt = now
+
+	if err := cp.save(); err != nil {
+		log.Printf("Failed to save checkpoint %s: %v", cp.path, err)
+	}
+}
+
+// save writes the checkpoint atomically, so a crash mid-write never leaves a truncated file.
+func (cp *checkpoint) save() error {
+	data, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(cp.path), 0755); err != nil {
+		return err
+	}
+	tmp := cp.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, cp.path)
+}
+
+// runCheckpointed runs one pipeline step through the checkpoint. If reuse is set and an earlier
+// run of the session already completed key, its saved output is returned without calling
+// generate; otherwise generate runs and its outcome is checkpointed. ran reports whether
+// generate was called, so callers can regenerate the steps that depend on it.
+func runCheckpointed[T any](cp *checkpoint, key, label string, reuse bool, events *EventLog, generate func() (T, error)) (out T, ran bool, err error) {
+	if reuse {
+		if saved, ok := cp.succeeded(key); ok {
+			if err := json.Unmarshal(saved, &out); err == nil {
+				events.StepStarted(key, fmt.Sprintf("Step: Reusing %s from the checkpoint of a previous run.\n\n", label))
+				events.StepFinished(key, nil)
+				return out, false, nil
+			}
+			log.Printf("Ignoring unreadable checkpoint output for step %s in %s", key, cp.path)
+		}
+	}
+
+	out, err = generate()
+	cp.record(key, out, err)
+	return out, true, err
+}
+
+// prettyJSON renders v the way artifacts are saved and returned.
+func prettyJSON(v interface{}) string {
+	data, _ := json.MarshalIndent(v, "", "  ")
+	return string(data)
+}
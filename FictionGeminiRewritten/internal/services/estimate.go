@@ -0,0 +1,368 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"workspace/FictionGeminiRewritten/internal/ai"
+	"workspace/FictionGeminiRewritten/internal/models"
+	"workspace/FictionGeminiRewritten/internal/prompts"
+)
+
+const (
+	// expectedOutlineEntities is how many entities a fan-out outline is assumed to list when
+	// no earlier fan-out session has run, to work out the number of batch calls. The outline
+	// prompt asks for 60-120.
+	expectedOutlineEntities = 90
+
+	// countTokensTimeout bounds each count-tokens request made while estimating.
+	countTokensTimeout = 15 * time.Second
+)
+
+// Token counting methods reported in estimates.
+const (
+	countedByProvider  = "provider"
+	countedByEstimator = "estimator"
+)
+
+// defaultOutputTokens projects the output of one call per step when no earlier session has run
+// the step. The ranges are what typical Gemini runs of the stock prompts produce.
+var defaultOutputTokens = map[string]models.TokenRange{
+	models.StepComprehensiveLorebook: {Min: 3000, Max: 8000},
+	models.StepToolCard:              {Min: 1500, Max: 4000},
+	models.StepNarratorCard:          {Min: 1500, Max: 4000},
+	models.StepMasterLorebook:        {Min: 3000, Max: 8000},
+	models.StepContextSummary:        {Min: 300, Max: 900},
+	models.StepToolSuggestion:        {Min: 100, Max: 400},
+	models.StepUtilityCard:           {Min: 1500, Max: 4000},
+}
+
+// Output projections of fan-out calls without history.
+var (
+	defaultOutlineOutputTokens = models.TokenRange{Min: 400, Max: 1200}
+	defaultBatchOutputTokens   = models.TokenRange{Min: 1200, Max: 3500}
+)
+
+// plannedCall is a prompt a generation request would send, rendered ahead of time. Prompts
+// that embed the output of earlier calls are rendered from promptInputs; when estimating, the
+// projected size of that output is added to the prompt.
+type plannedCall struct {
+	step     string
+	label    string
+	calls    int    // How many times the prompt (or one like it) is sent
+	prompt   string // Rendered prompt, or the raw template if it failed to render
+	err      error  // Why the template failed to render
+	context  []int  // Indexes of earlier calls whose output the prompt embeds
+	fanout   bool   // Part of a fan-out lorebook, which has its own output history
+	outlines bool   // A fan-out outline call
+}
+
+// planPrompts renders every prompt the request's option would send, in pipeline order, with
+// inputs standing in for the output of earlier steps.
+func planPrompts(templates prompts.Selection, payload models.RequestPayload, inputs promptInputs) ([]plannedCall, error) {
+	fanout, err := newFanoutSettings(payload)
+	if err != nil {
+		return nil, err
+	}
+	contextCards, err := contextCardsText(payload)
+	if err != nil {
+		return nil, err
+	}
+	series := payload.Series
+	var plan []plannedCall
+	add := func(call plannedCall) int {
+		if call.calls == 0 {
+			call.calls = 1
+		}
+		plan = append(plan, call)
+		return len(plan) - 1
+	}
+	// rendered falls back to the raw template of a prompt that failed to render.
+	rendered := func(name, prompt string, err error) (string, error) {
+		if err != nil {
+			if t, ok := templates.Template(name); ok {
+				prompt = t.Text
+			}
+		}
+		return prompt, err
+	}
+	lorebook := func(step, label, name string, single func() (string, error)) []int {
+		if fanout == nil {
+			prompt, err := single()
+			prompt, err = rendered(name, prompt, err)
+			return []int{add(plannedCall{step: step, label: label, prompt: prompt, err: err})}
+		}
+		prompt, err := lorebookOutlinePrompt(templates, series, contextCards)
+		prompt, err = rendered(prompts.LorebookOutline, prompt, err)
+		outline := add(plannedCall{step: step, label: label + " Outline", prompt: prompt, err: err, fanout: true, outlines: true})
+		entities := make([]string, fanout.batchSize)
+		for i := range entities {
+			entities[i] = fmt.Sprintf("[Entity %d from the outline]", i+1)
+		}
+		prompt, err = lorebookBatchPrompt(templates, series, lorebookBatch{category: "[Outline category]", entities: entities}, inputs.outline, contextCards)
+		prompt, err = rendered(prompts.LorebookBatch, prompt, err)
+		batches := add(plannedCall{
+			step: step, label: label + " Batches", prompt: prompt, err: err, fanout: true,
+			calls:   (expectedOutlineEntities + fanout.batchSize - 1) / fanout.batchSize,
+			context: []int{outline},
+		})
+		return []int{outline, batches}
+	}
+
+	switch payload.Option {
+	case "1":
+		lorebook(models.StepComprehensiveLorebook, "Comprehensive Lorebook", prompts.ComprehensiveLorebook, func() (string, error) {
+			return comprehensiveLorebookPrompt(templates, series, contextCards)
+		})
+	case "3":
+		prompt, err := toolCardPrompt(templates, series, payload.ToolCardPurpose, contextCards)
+		prompt, err = rendered(prompts.ToolCard, prompt, err)
+		add(plannedCall{step: models.StepToolCard, label: "Tool Card", prompt: prompt, err: err})
+	case "2", "4":
+		prompt, err := narratorCardPrompt(templates, series, fmt.Sprintf("The Narrator of %s", series), contextCards)
+		prompt, err = rendered(prompts.NarratorCard, prompt, err)
+		add(plannedCall{step: models.StepNarratorCard, label: "Narrator Card", prompt: prompt, err: err})
+		lorebook(models.StepMasterLorebook, "Master Lorebook", prompts.MasterLorebook, func() (string, error) {
+			return masterLorebookPrompt(templates, series, contextCards)
+		})
+		if payload.Option == "2" {
+			break
+		}
+
+		// The summary quotes fixed-size excerpts of the narrator and lorebook, so its prompt
+		// doesn't grow with them.
+		prompt, err = contextSummaryPrompt(templates, series, inputs.narrator, inputs.lorebook)
+		prompt, err = rendered(prompts.ContextSummary, prompt, err)
+		summary := add(plannedCall{step: models.StepContextSummary, label: "Contextual Summary", prompt: prompt, err: err})
+
+		prompt, err = toolSuggestionPrompt(templates, series, inputs.summary)
+		prompt, err = rendered(prompts.ToolSuggestion, prompt, err)
+		add(plannedCall{step: models.StepToolSuggestion, label: "AI Tool Suggestions", prompt: prompt, err: err, context: []int{summary}})
+
+		for i, tool := range inputs.tools {
+			prompt, err = utilityCardPrompt(templates, series, tool, contextCards)
+			prompt, err = rendered(prompts.ToolCard, prompt, err)
+			add(plannedCall{step: models.StepUtilityCard, label: fmt.Sprintf("Tailored Utility Card %d", i+1), prompt: prompt, err: err})
+		}
+	default:
+		return nil, fmt.Errorf("invalid option: %s", payload.Option)
+	}
+	return plan, nil
+}
+
+// Estimate projects the tokens and cost of a generation request without generating anything.
+// Prompts are rendered as the request would send them and counted with each step's model, or
+// locally when the model can't count (or no API key is given); outputs are projected from the
+// usage of earlier sessions saved under the JSON directory, else from per-step defaults.
+func (s *OrchestratorService) Estimate(ctx context.Context, payload models.RequestPayload, apiKey string) (models.EstimateResponse, error) {
+	estimate := models.EstimateResponse{
+		Series:       payload.Series,
+		Option:       payload.Option,
+		OptionChosen: describeOption(payload),
+		Currency:     priceCurrency,
+		Steps:        []models.StepEstimate{},
+	}
+	selection, _ := s.assignPrompts(payload)
+	plan, err := planPrompts(selection, payload, placeholderInputs())
+	if err != nil {
+		return estimate, err
+	}
+	history := loadUsageHistory(baseJSONSaveDir)
+
+	providers := make(map[string]ai.Provider)
+	var unpriced, uncounted []string
+	for _, call := range plan {
+		spec, _ := s.routing.routeFor(payload, call.step)
+		step := models.StepEstimate{
+			Step:  call.step,
+			Label: call.label,
+			Model: spec,
+			Calls: call.calls,
+		}
+		if call.err != nil {
+			step.TemplateError = call.err.Error()
+		}
+
+		tokens, countedBy := countPromptTokens(ctx, providers, spec, apiKey, call.prompt)
+		step.PromptCountedBy = countedBy
+		if countedBy == countedByEstimator && !slices.Contains(uncounted, spec) {
+			uncounted = append(uncounted, spec)
+		}
+		step.PromptTokens = models.TokenRange{Min: tokens, Max: tokens}
+		for _, i := range call.context {
+			// The prompt embeds the upstream step's whole output.
+			upstream := estimate.Steps[i].OutputTokens
+			step.PromptTokens.Min += upstream.Min
+			step.PromptTokens.Max += upstream.Max
+		}
+
+		perCall, calls, basis := history.project(call)
+		step.Calls, step.OutputBasis = calls, basis
+		step.OutputTokens = models.TokenRange{Min: perCall.Min * step.Calls, Max: perCall.Max * step.Calls}
+		step.PromptTokens = models.TokenRange{Min: step.PromptTokens.Min * step.Calls, Max: step.PromptTokens.Max * step.Calls}
+
+		price, priced := s.prices.lookup(spec)
+		step.Priced = priced
+		if priced {
+			step.EstimatedCost = models.CostRange{
+				Min: (float64(step.PromptTokens.Min)*price.InputPerMillion + float64(step.OutputTokens.Min)*price.OutputPerMillion) / 1e6,
+				Max: (float64(step.PromptTokens.Max)*price.InputPerMillion + float64(step.OutputTokens.Max)*price.OutputPerMillion) / 1e6,
+			}
+		} else if !slices.Contains(unpriced, spec) {
+			unpriced = append(unpriced, spec)
+		}
+
+		estimate.PromptTokens.Min += step.PromptTokens.Min
+		estimate.PromptTokens.Max += step.PromptTokens.Max
+		estimate.OutputTokens.Min += step.OutputTokens.Min
+		estimate.OutputTokens.Max += step.OutputTokens.Max
+		estimate.EstimatedCost.Min += step.EstimatedCost.Min
+		estimate.EstimatedCost.Max += step.EstimatedCost.Max
+		estimate.Steps = append(estimate.Steps, step)
+	}
+
+	estimate.Notes = append(estimate.Notes, "JSON repair and continuation calls are not included; each adds roughly one more call of its step.")
+	if len(uncounted) > 0 {
+		estimate.Notes = append(estimate.Notes, fmt.Sprintf("Prompt tokens were estimated locally (about 4 characters per token) for %s.", strings.Join(uncounted, ", ")))
+	}
+	if len(unpriced) > 0 {
+		estimate.Notes = append(estimate.Notes, fmt.Sprintf("No price is known for %s; its cost counts as zero. Add it to MODEL_PRICES_FILE.", strings.Join(unpriced, ", ")))
+	}
+	for _, step := range estimate.Steps {
+		if step.TemplateError != "" {
+			estimate.Notes = append(estimate.Notes, fmt.Sprintf("%s: the prompt template failed to render, so generation would fail; its raw template was counted instead.", step.Label))
+		}
+	}
+	return estimate, nil
+}
+
+// countPromptTokens counts prompt with the model's provider, falling back to the local
+// estimator when the provider can't be created or the count fails. Providers are cached by spec.
+func countPromptTokens(ctx context.Context, providers map[string]ai.Provider, spec, apiKey, prompt string) (int, string) {
+	provider, ok := providers[spec]
+	if !ok {
+		if !ai.RequiresAPIKey(spec) || apiKey != "" {
+			var err error
+			if provider, _, err = ai.ResolveProvider(spec, apiKey); err != nil {
+				log.Printf("Estimating tokens locally for '%s': %v", spec, err)
+			}
+		}
+		providers[spec] = provider
+	}
+	if provider == nil {
+		return ai.EstimateTokens(prompt), countedByEstimator
+	}
+
+	_, model := ai.ParseModelSpec(spec)
+	countCtx, cancel := context.WithTimeout(ctx, countTokensTimeout)
+	defer cancel()
+	tokens, err := provider.CountTokens(countCtx, model, prompt)
+	if err != nil {
+		log.Printf("Counting tokens with '%s' failed, estimating locally: %v", spec, err)
+		return ai.EstimateTokens(prompt), countedByEstimator
+	}
+	if provider.Name() != ai.DefaultBackend {
+		// OpenAI-compatible backends have no count-tokens endpoint and estimate locally too.
+		return tokens, countedByEstimator
+	}
+	return tokens, countedByProvider
+}
+
+// stepHistory is the output of one step across earlier sessions.
+type stepHistory struct {
+	sessions     int
+	calls        int
+	minPerCall   int
+	maxPerCall   int
+	totalPerCall int
+}
+
+// usageHistory is the per-step output history of earlier sessions, keyed by step and, for
+// fan-out lorebooks, by step plus "/fanout", since their calls are much smaller.
+type usageHistory map[string]*stepHistory
+
+// loadUsageHistory reads the usage reports of every session saved under dir.
+func loadUsageHistory(dir string) usageHistory {
+	history := make(usageHistory)
+	reports, _ := filepath.Glob(filepath.Join(dir, "*", "*", usageFileName))
+	for _, path := range reports {
+		sessionDir := filepath.Dir(path)
+		report := loadUsageReport(sessionDir)
+		if report == nil {
+			continue
+		}
+		fanout := false
+		if data, err := os.ReadFile(filepath.Join(sessionDir, checkpointFileName)); err == nil {
+			var cp checkpoint
+			if json.Unmarshal(data, &cp) == nil {
+				fanout = cp.LorebookMode == models.LorebookModeFanout
+			}
+		}
+		for step, usage := range report.Steps {
+			if usage.Calls == 0 || usage.CandidateTokens == 0 {
+				continue
+			}
+			history.add(historyKey(step, fanout && isLorebookStep(step)), usage)
+		}
+	}
+	return history
+}
+
+func (h usageHistory) add(key string, usage models.TokenUsage) {
+	perCall := usage.CandidateTokens / usage.Calls
+	stats, ok := h[key]
+	if !ok {
+		stats = &stepHistory{minPerCall: perCall, maxPerCall: perCall}
+		h[key] = stats
+	}
+	stats.sessions++
+	stats.calls += usage.Calls
+	stats.totalPerCall += perCall
+	stats.minPerCall = min(stats.minPerCall, perCall)
+	stats.maxPerCall = max(stats.maxPerCall, perCall)
+}
+
+// project returns the projected output per call of a planned call, how many calls it makes,
+// and where the projection came from.
+func (h usageHistory) project(call plannedCall) (models.TokenRange, int, string) {
+	stats, ok := h[historyKey(call.step, call.fanout)]
+	if !ok {
+		switch {
+		case call.outlines:
+			return defaultOutlineOutputTokens, call.calls, "default"
+		case call.fanout:
+			return defaultBatchOutputTokens, call.calls, "default"
+		}
+		return defaultOutputTokens[call.step], call.calls, "default"
+	}
+
+	calls := call.calls
+	if call.fanout && !call.outlines {
+		// A fan-out step's history mixes its outline and batch calls; every call after the
+		// outline is a batch.
+		calls = max(1, stats.calls/stats.sessions-1)
+	}
+	perCall := models.TokenRange{Min: stats.minPerCall, Max: stats.maxPerCall}
+	if stats.sessions == 1 {
+		return perCall, calls, "history of 1 session"
+	}
+	return perCall, calls, fmt.Sprintf("history of %d sessions", stats.sessions)
+}
+
+func historyKey(step string, fanout bool) string {
+	if fanout {
+		return step + "/fanout"
+	}
+	return step
+}
+
+func isLorebookStep(step string) bool {
+	return step == models.StepComprehensiveLorebook || step == models.StepMasterLorebook
+}
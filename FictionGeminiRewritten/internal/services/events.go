@@ -0,0 +1,189 @@
+package services
+
+import (
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"workspace/FictionGeminiRewritten/internal/models"
+)
+
+// EventLog is the event sink for a single generation request. The orchestrator emits
+// progress into it; the message log in the final JSON response and any live subscribers
+// (such as the SSE writer) both consume it. It is safe for concurrent use.
+type EventLog struct {
+	mu          sync.Mutex
+	events      []models.GenerationEvent
+	subscribers []subscriber
+}
+
+type subscriber struct {
+	fn     func(models.GenerationEvent)
+	tokens bool // Also receives token events
+}
+
+// NewEventLog creates an empty EventLog.
+func NewEventLog() *EventLog {
+	return &EventLog{}
+}
+
+// Subscribe registers fn to receive every event emitted from now on, including token events,
+// which are not kept in the history. fn is called synchronously and must not call back into the log.
+func (l *EventLog) Subscribe(fn func(models.GenerationEvent)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.subscribers = append(l.subscribers, subscriber{fn: fn, tokens: true})
+}
+
+// Observe registers fn to receive every event emitted from now on except token events. Unlike
+// Subscribe, it doesn't turn on streaming. fn is called synchronously and must not call back
+// into the log.
+func (l *EventLog) Observe(fn func(models.GenerationEvent)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.subscribers = append(l.subscribers, subscriber{fn: fn})
+}
+
+// Streaming reports whether anyone is listening for tokens, so providers only stream when useful.
+func (l *EventLog) Streaming() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return slices.ContainsFunc(l.subscribers, func(s subscriber) bool { return s.tokens })
+}
+
+// Emit records the event and delivers it to subscribers.
+func (l *EventLog) Emit(event models.GenerationEvent) {
+	if event.Time == "" {
+		event.Time = time.Now().Format(time.RFC3339Nano)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if event.Type != models.EventToken {
+		l.events = append(l.events, event)
+	}
+	for _, s := range l.subscribers {
+		if s.tokens || event.Type != models.EventToken {
+			s.fn(event)
+		}
+	}
+}
+
+// Message appends a line to the human-readable message log.
+func (l *EventLog) Message(text string) {
+	l.Emit(models.GenerationEvent{Type: models.EventMessage, Message: text})
+}
+
+// StepStarted marks the beginning of a pipeline step; text is also added to the message log.
+func (l *EventLog) StepStarted(step, text string) {
+	l.Emit(models.GenerationEvent{Type: models.EventStepStart, Step: step, Message: text})
+}
+
+// StepFinished marks the end of a pipeline step, successful if err is nil.
+func (l *EventLog) StepFinished(step string, err error) {
+	event := models.GenerationEvent{Type: models.EventStepFinish, Step: step}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	l.Emit(event)
+}
+
+// ModelUsed records that spec answered a model call made for step, using usage.
+func (l *EventLog) ModelUsed(step, spec string, usage models.TokenUsage) {
+	l.Emit(models.GenerationEvent{Type: models.EventModel, Step: step, Model: spec, Usage: &usage})
+}
+
+// Usage announces the session's token usage report.
+func (l *EventLog) Usage(report models.UsageReport) {
+	l.Emit(models.GenerationEvent{Type: models.EventUsage, UsageReport: &report})
+}
+
+// Artifact announces a generated artifact as soon as it is available.
+func (l *EventLog) Artifact(step string, artifact models.ArtifactInfo) {
+	l.Emit(models.GenerationEvent{Type: models.EventArtifact, Step: step, Artifact: &artifact})
+}
+
+// Prompts announces the prompt template versions the session renders.
+func (l *EventLog) Prompts(assignment models.PromptAssignment) {
+	l.Emit(models.GenerationEvent{Type: models.EventPrompts, Prompts: &assignment})
+}
+
+// Token forwards a chunk of streamed model output to live subscribers.
+func (l *EventLog) Token(step, text string) {
+	l.Emit(models.GenerationEvent{Type: models.EventToken, Step: step, Text: text})
+}
+
+// Retry announces that a model call for step failed on the given attempt and is sent again.
+// streamed is the token text the failed attempt had sent, which subscribers should drop.
+func (l *EventLog) Retry(step string, attempt int, err error, streamed string) {
+	l.Emit(models.GenerationEvent{Type: models.EventRetry, Step: step, Attempt: attempt, Error: err.Error(), Text: streamed})
+}
+
+// Events returns a copy of the recorded history (everything except token events).
+func (l *EventLog) Events() []models.GenerationEvent {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]models.GenerationEvent(nil), l.events...)
+}
+
+// StepModels returns, per step, the models that answered its calls, in order of first use
+// and joined with ", " when there was more than one. It returns nil if no call succeeded.
+func (l *EventLog) StepModels() map[string]string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var used map[string][]string
+	for _, e := range l.events {
+		if e.Type != models.EventModel || slices.Contains(used[e.Step], e.Model) {
+			continue
+		}
+		if used == nil {
+			used = make(map[string][]string)
+		}
+		used[e.Step] = append(used[e.Step], e.Model)
+	}
+	if used == nil {
+		return nil
+	}
+	out := make(map[string]string, len(used))
+	for step, specs := range used {
+		out[step] = strings.Join(specs, ", ")
+	}
+	return out
+}
+
+// UsageReport returns the most recently announced usage report, or nil if there is none.
+func (l *EventLog) UsageReport() *models.UsageReport {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i := len(l.events) - 1; i >= 0; i-- {
+		if l.events[i].Type == models.EventUsage {
+			return l.events[i].UsageReport
+		}
+	}
+	return nil
+}
+
+// PromptAssignment returns the announced prompt template versions, or nil if there are none.
+func (l *EventLog) PromptAssignment() *models.PromptAssignment {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, e := range l.events {
+		if e.Type == models.EventPrompts {
+			return e.Prompts
+		}
+	}
+	return nil
+}
+
+// Text renders the message log: the text of every message and step start, in order.
+func (l *EventLog) Text() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var b strings.Builder
+	for _, e := range l.events {
+		if e.Type == models.EventMessage || e.Type == models.EventStepStart {
+			b.WriteString(e.Message)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,275 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"workspace/FictionGeminiRewritten/internal/models"
+	"workspace/FictionGeminiRewritten/internal/prompts"
+)
+
+const (
+	promptOutcomesFileName = "prompt_outcomes.json"
+
+	// artifactExtension is the extensions key under which saved cards and lorebooks record
+	// how they were generated.
+	artifactExtension = "fiction_gemini"
+)
+
+// assignPrompts selects the template versions a request renders: the defaults, or in
+// experiment mode the variants picked from the request's seed (a random one if it has none).
+func (s *OrchestratorService) assignPrompts(payload models.RequestPayload) (prompts.Selection, models.PromptAssignment) {
+	var assigned map[string]string
+	var seed *int64
+	if payload.Experiment != nil {
+		seed = payload.Experiment.Seed
+		if seed == nil {
+			random := rand.Int64()
+			seed = &random
+		}
+		assigned = s.templates.Assign(*seed)
+	}
+	selection, _ := s.templates.Select(assigned) // Assign only picks versions that exist
+	return selection, models.PromptAssignment{Versions: selection.Versions(), ExperimentSeed: seed}
+}
+
+// resumedPrompts selects the template versions recorded for a session. Versions that no longer
+// exist are replaced by the defaults, and reported in the error.
+func (s *OrchestratorService) resumedPrompts(recorded models.PromptAssignment) (prompts.Selection, models.PromptAssignment, error) {
+	selection, err := s.templates.Select(recorded.Versions)
+	return selection, models.PromptAssignment{Versions: selection.Versions(), ExperimentSeed: recorded.ExperimentSeed}, err
+}
+
+// stepTemplates lists the templates a step's prompts are rendered from: the step's own (the
+// outline and batch templates for fan-out lorebooks), plus the continuation template and, for
+// JSON output, the repair template its calls may need.
+func stepTemplates(step string, fanout bool, selection prompts.Selection) []string {
+	var names []string
+	switch step {
+	case models.StepComprehensiveLorebook, models.StepMasterLorebook:
+		if fanout {
+			names = []string{prompts.LorebookOutline, prompts.LorebookBatch}
+		} else if step == models.StepComprehensiveLorebook {
+			names = []string{prompts.ComprehensiveLorebook}
+		} else {
+			names = []string{prompts.MasterLorebook}
+		}
+	case models.StepToolCard, models.StepUtilityCard:
+		names = []string{prompts.ToolCard}
+	case models.StepNarratorCard:
+		names = []string{prompts.NarratorCard}
+	case models.StepContextSummary:
+		names = []string{prompts.ContextSummary}
+	case models.StepToolSuggestion:
+		names = []string{prompts.ToolSuggestion}
+	}
+	names = append(names, prompts.Continuation)
+	if t, ok := selection.Template(names[0]); ok && t.OutputKind == prompts.OutputJSON {
+		names = append(names, prompts.JSONRepair)
+	}
+	return names
+}
+
+// promptVersions returns the versions of the templates a step's prompts are rendered from.
+func (a *aiSession) promptVersions(step string) map[string]string {
+	return a.prompts.Versions(stepTemplates(step, a.fanout != nil, a.prompts)...)
+}
+
+// stampPromptVersions records in an artifact's extensions the versions of the templates its
+// step's prompts came from. It returns the extensions, created if ext was nil, and the versions.
+func (a *aiSession) stampPromptVersions(ext models.Extensions, step string) (models.Extensions, map[string]string) {
+	versions := a.promptVersions(step)
+	if ext == nil {
+		ext = make(models.Extensions)
+	}
+	own, ok := ext[artifactExtension].(map[string]interface{})
+	if !ok {
+		own = make(map[string]interface{})
+	}
+	own["prompt_versions"] = versions
+	ext[artifactExtension] = own
+	return ext, versions
+}
+
+// outcomeTracker records how each step's prompts fare during one run of a session, so that
+// template versions can be compared across sessions. It is safe for concurrent use, since
+// fan-out batches report from several goroutines.
+type outcomeTracker struct {
+	versions func(step string) map[string]string
+
+	mu    sync.Mutex
+	steps []*models.StepOutcome
+}
+
+func newOutcomeTracker(versions func(step string) map[string]string) *outcomeTracker {
+	return &outcomeTracker{versions: versions}
+}
+
+// step returns the outcome of step, starting it if needed. The caller must hold t.mu.
+func (t *outcomeTracker) step(step string) *models.StepOutcome {
+	for _, outcome := range t.steps {
+		if outcome.Step == step {
+			return outcome
+		}
+	}
+	outcome := &models.StepOutcome{Step: step, PromptVersions: t.versions(step)}
+	t.steps = append(t.steps, outcome)
+	return outcome
+}
+
+// parsed records a response of step that had to parse as JSON, after repairs model calls, and
+// whether it finally did.
+func (t *outcomeTracker) parsed(step string, repairs int, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	outcome := t.step(step)
+	outcome.ParseAttempts++
+	outcome.RepairAttempts += repairs
+	if err != nil {
+		outcome.ParseFailures++
+	}
+}
+
+// used adds the usage of a successful call of step.
+func (t *outcomeTracker) used(step string, usage models.TokenUsage) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	outcome := t.step(step)
+	outcome.Usage = addUsage(outcome.Usage, usage)
+}
+
+// entries adds n lorebook entries or suggested tools written by step.
+func (t *outcomeTracker) entries(step string, n int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.step(step).Entries += n
+}
+
+// finish adds the run to prompt_outcomes.json in the session directory. Runs that executed no
+// step, such as a resume with nothing left to do, are not recorded.
+func (t *outcomeTracker) finish(sessionDir string, run models.PromptRun) {
+	t.mu.Lock()
+	for _, outcome := range t.steps {
+		run.Steps = append(run.Steps, *outcome)
+	}
+	t.mu.Unlock()
+	if len(run.Steps) == 0 {
+		return
+	}
+	run.FinishedAt = time.Now().Format(time.RFC3339)
+
+	path := filepath.Join(sessionDir, promptOutcomesFileName)
+	runs := loadPromptRuns(path)
+	runs = append(runs, run)
+	if err := os.MkdirAll(sessionDir, 0755); err != nil {
+		log.Printf("Failed to save prompt outcomes %s: %v", path, err)
+	} else if err := os.WriteFile(path, []byte(prettyJSON(runs)), 0644); err != nil {
+		log.Printf("Failed to save prompt outcomes %s: %v", path, err)
+	}
+}
+
+// loadPromptRuns reads a prompt_outcomes.json file, returning nil if it is missing or unreadable.
+func loadPromptRuns(path string) []models.PromptRun {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var runs []models.PromptRun
+	if err := json.Unmarshal(data, &runs); err != nil {
+		log.Printf("Ignoring unreadable prompt outcomes %s: %v", path, err)
+		return nil
+	}
+	return runs
+}
+
+// ExperimentReport sums up the outcomes recorded by every session under the JSON directory,
+// per step and combination of template versions. step, if set, keeps only that step; with
+// experimentsOnly, only runs whose versions were assigned by an experiment are counted, so
+// that variants are compared on randomly assigned runs alone.
+func (s *OrchestratorService) ExperimentReport(step string, experimentsOnly bool) (models.ExperimentReport, error) {
+	if step != "" && !slices.Contains(models.PipelineSteps, step) {
+		return models.ExperimentReport{}, fmt.Errorf("unknown step '%s'", step)
+	}
+	report := models.ExperimentReport{Variants: []models.VariantStats{}}
+	paths, _ := filepath.Glob(filepath.Join(baseJSONSaveDir, "*", "*", promptOutcomesFileName))
+
+	groups := make(map[string]*models.VariantStats)
+	var order []string
+	for _, path := range paths {
+		for _, run := range loadPromptRuns(path) {
+			if experimentsOnly && run.ExperimentSeed == nil {
+				continue
+			}
+			report.Runs++
+			for _, outcome := range run.Steps {
+				if step != "" && outcome.Step != step {
+					continue
+				}
+				key := outcome.Step + " " + versionsKey(outcome.PromptVersions)
+				stats, ok := groups[key]
+				if !ok {
+					stats = &models.VariantStats{Step: outcome.Step, PromptVersions: outcome.PromptVersions, FirstRun: run.FinishedAt}
+					groups[key] = stats
+					order = append(order, key)
+				}
+				addOutcome(stats, run, outcome)
+			}
+		}
+	}
+
+	sort.Strings(order)
+	for _, key := range order {
+		stats := groups[key]
+		runs := float64(stats.Runs)
+		stats.ParseSuccessRate = 1
+		if stats.ParseAttempts > 0 {
+			stats.ParseSuccessRate = float64(stats.ParseAttempts-stats.ParseFailures) / float64(stats.ParseAttempts)
+		}
+		stats.AvgRepairAttempts = float64(stats.RepairAttempts) / runs
+		stats.AvgEntries /= runs
+		stats.AvgTotalTokens /= runs
+		stats.AvgEstimatedCost /= runs
+		report.Variants = append(report.Variants, *stats)
+	}
+	return report, nil
+}
+
+// addOutcome adds one run's outcome of a step to stats. The averages hold sums until the
+// report is complete.
+func addOutcome(stats *models.VariantStats, run models.PromptRun, outcome models.StepOutcome) {
+	stats.Runs++
+	if run.ExperimentSeed != nil {
+		stats.ExperimentRuns++
+	}
+	stats.ParseAttempts += outcome.ParseAttempts
+	stats.ParseFailures += outcome.ParseFailures
+	stats.RepairAttempts += outcome.RepairAttempts
+	stats.AvgEntries += float64(outcome.Entries)
+	stats.AvgTotalTokens += float64(outcome.Usage.TotalTokens)
+	stats.AvgEstimatedCost += outcome.Usage.EstimatedCost
+	if run.FinishedAt < stats.FirstRun {
+		stats.FirstRun = run.FinishedAt
+	}
+	if run.FinishedAt > stats.LastRun {
+		stats.LastRun = run.FinishedAt
+	}
+}
+
+// versionsKey renders template versions in a stable order, e.g. "json_repair=1.0,narrator_card=2.0".
+func versionsKey(versions map[string]string) string {
+	var parts []string
+	for _, name := range slices.Sorted(maps.Keys(versions)) {
+		parts = append(parts, name+"="+versions[name])
+	}
+	return strings.Join(parts, ",")
+}
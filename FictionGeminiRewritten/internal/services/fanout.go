@@ -0,0 +1,316 @@
+package services
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+
+	"workspace/FictionGeminiRewritten/internal/ai"
+	"workspace/FictionGeminiRewritten/internal/models"
+)
+
+const (
+	defaultFanoutConcurrency = 4
+	maxFanoutConcurrency     = 16
+	defaultFanoutBatchSize   = 6
+	maxFanoutBatchSize       = 25
+)
+
+var (
+	lorebookOutlineSchema    = ai.SchemaFor(models.LorebookOutline{})
+	lorebookEntryBatchSchema = ai.SchemaFor(models.LorebookEntryBatch{})
+)
+
+// fanoutSettings configures fan-out lorebook generation.
+type fanoutSettings struct {
+	concurrency int // Batches generated at the same time
+	batchSize   int // Entities per batch prompt
+}
+
+// newFanoutSettings returns the fan-out settings for a request, or nil when the request uses
+// single-prompt lorebooks.
+func newFanoutSettings(payload models.RequestPayload) (*fanoutSettings, error) {
+	switch payload.LorebookMode {
+	case "", models.LorebookModeSingle:
+		return nil, nil
+	case models.LorebookModeFanout:
+	default:
+		return nil, fmt.Errorf("unknown lorebook mode '%s'", payload.LorebookMode)
+	}
+
+	settings := &fanoutSettings{concurrency: defaultFanoutConcurrency, batchSize: defaultFanoutBatchSize}
+	if payload.FanoutConcurrency > 0 {
+		settings.concurrency = min(payload.FanoutConcurrency, maxFanoutConcurrency)
+	}
+	if payload.FanoutBatchSize > 0 {
+		settings.batchSize = min(payload.FanoutBatchSize, maxFanoutBatchSize)
+	}
+	return settings, nil
+}
+
+// lorebookBatch is one batch prompt's worth of entities from a single outline category.
+type lorebookBatch struct {
+	category string
+	entities []string
+}
+
+// generateFanoutLorebook builds a lorebook in stages: a category outline of every entity
+// worth an entry, then one prompt per batch of entities run in parallel, and finally a merge
+// with deduplicated entries and keys. Failed batches are reported and skipped; it only fails
+// if the outline or every batch fails.
+func (s *OrchestratorService) generateFanoutLorebook(ctx context.Context, session *aiSession, step, artifactName, seriesName, logIdentifier string, events *EventLog) (models.Lorebook, error) {
+	settings := session.fanout
+	events.Message(fmt.Sprintf("  Fan-out mode: outlining %s categories first...\n", artifactName))
+
+	outlinePrompt, err := lorebookOutlinePrompt(session.prompts, seriesName, session.contextCards)
+	if err != nil {
+		events.Message(fmt.Sprintf("  ERROR preparing outline prompt: %v\n", err))
+		return models.Lorebook{}, err
+	}
+	aiResponse, err := session.call(ctx, step, outlinePrompt, lorebookOutlineSchema)
+	if err != nil {
+		events.Message(fmt.Sprintf("  ERROR generating %s outline: %v\n", artifactName, err))
+		return models.Lorebook{}, fmt.Errorf("AI generation failed for %s outline: %w", artifactName, err)
+	}
+	var outline models.LorebookOutline
+	aiResponse, parseErr := session.parseWithRepair(ctx, step, artifactName+" Outline", aiResponse, lorebookOutlineSchema, &outline, logIdentifier, events)
+	if parseErr != nil {
+		log.Printf("Failed to unmarshal %s outline (Log ID %s): %v. AI Response: %s", artifactName, logIdentifier, parseErr, aiResponse)
+		events.Message(fmt.Sprintf("  ERROR parsing %s outline. Problems found:\n%s", artifactName, describeParseError(parseErr, aiResponse)))
+		return models.Lorebook{}, fmt.Errorf("failed to parse AI response for %s outline: %w", artifactName, parseErr)
+	}
+
+	batches := planLorebookBatches(outline, settings.batchSize)
+	if len(batches) == 0 {
+		events.Message("  ERROR: the outline listed no categories or entities.\n")
+		return models.Lorebook{}, fmt.Errorf("the %s outline is empty", artifactName)
+	}
+	outlineText := describeOutline(outline)
+	for _, category := range outline.Categories {
+		events.Message(fmt.Sprintf("    Outline category '%s': %d entities\n", category.Name, len(category.Entities)))
+	}
+	events.Message(fmt.Sprintf("  Generating %d batches of entries, %d at a time...\n", len(batches), settings.concurrency))
+
+	results := make([][]models.LorebookEntry, len(batches))
+	failed := make([]bool, len(batches))
+	sem := make(chan struct{}, settings.concurrency)
+	var wg sync.WaitGroup
+	for i, batch := range batches {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			label := fmt.Sprintf("%s batch %d/%d (%s)", artifactName, i+1, len(batches), batch.category)
+			entries, err := s.generateLorebookBatch(ctx, session, step, label, seriesName, batch, outlineText, logIdentifier, events)
+			if err != nil {
+				log.Printf("Fan-out batch failed (Log ID %s): %s: %v", logIdentifier, label, err)
+				events.Message(fmt.Sprintf("  ERROR in %s, skipping it: %v\n", label, err))
+				failed[i] = true
+				return
+			}
+			results[i] = entries
+			events.Message(fmt.Sprintf("  %s: %d entries.\n", label, len(entries)))
+		}()
+	}
+	wg.Wait()
+
+	failures := 0
+	for _, f := range failed {
+		if f {
+			failures++
+		}
+	}
+	if failures == len(batches) {
+		return models.Lorebook{}, fmt.Errorf("all %d %s batches failed", len(batches), artifactName)
+	}
+
+	lorebook := models.Lorebook{
+		Description:       fmt.Sprintf("An in-depth collection of lore for the world of '%s', covering %s.", seriesName, strings.Join(outlineCategoryNames(outline), ", ")),
+		ScanDepth:         35,
+		TokenBudget:       5000,
+		RecursiveScanning: true,
+	}
+	var conflicts []string
+	lorebook.Entries, conflicts = mergeLorebookEntries(results)
+	for _, conflict := range conflicts {
+		events.Message(fmt.Sprintf("  WARNING: %s.\n", conflict))
+	}
+	events.Message(fmt.Sprintf("  Merged %d entries from %d of %d batches.\n", len(lorebook.Entries), len(batches)-failures, len(batches)))
+	return lorebook, nil
+}
+
+// generateLorebookBatch writes the entries for one batch of outline entities.
+func (s *OrchestratorService) generateLorebookBatch(ctx context.Context, session *aiSession, step, label, seriesName string, batch lorebookBatch, outlineText, logIdentifier string, events *EventLog) ([]models.LorebookEntry, error) {
+	prompt, err := lorebookBatchPrompt(session.prompts, seriesName, batch, outlineText, session.contextCards)
+	if err != nil {
+		return nil, err
+	}
+
+	aiResponse, err := session.call(ctx, step, prompt, lorebookEntryBatchSchema)
+	if err != nil {
+		return nil, err
+	}
+	var result models.LorebookEntryBatch
+	aiResponse, parseErr := session.parseWithRepair(ctx, step, label, aiResponse, lorebookEntryBatchSchema, &result, logIdentifier, events)
+	if parseErr != nil {
+		log.Printf("Failed to unmarshal %s (Log ID %s): %v. AI Response: %s", label, logIdentifier, parseErr, aiResponse)
+		return nil, fmt.Errorf("failed to parse AI response: %w", parseErr)
+	}
+	return result.Entries, nil
+}
+
+// planLorebookBatches splits the outline into batches of at most batchSize entities, in outline
+// order. Entities listed twice (in any category) are only kept the first time; a category
+// without entities becomes a single batch about the category itself.
+func planLorebookBatches(outline models.LorebookOutline, batchSize int) []lorebookBatch {
+	seen := make(map[string]bool)
+	var batches []lorebookBatch
+	for _, category := range outline.Categories {
+		name := strings.TrimSpace(category.Name)
+		var entities []string
+		for _, entity := range category.Entities {
+			entity = strings.TrimSpace(entity)
+			id := strings.ToLower(entity)
+			if entity == "" || seen[id] {
+				continue
+			}
+			seen[id] = true
+			entities = append(entities, entity)
+		}
+		if len(entities) == 0 {
+			if name == "" {
+				continue
+			}
+			entities = []string{name}
+		}
+		for start := 0; start < len(entities); start += batchSize {
+			end := min(start+batchSize, len(entities))
+			batches = append(batches, lorebookBatch{category: name, entities: entities[start:end]})
+		}
+	}
+	return batches
+}
+
+// describeOutline renders the outline as context for batch prompts.
+func describeOutline(outline models.LorebookOutline) string {
+	var b strings.Builder
+	for _, category := range outline.Categories {
+		fmt.Fprintf(&b, "  %s: %s\n", category.Name, strings.Join(category.Entities, ", "))
+	}
+	return b.String()
+}
+
+func outlineCategoryNames(outline models.LorebookOutline) []string {
+	names := make([]string, 0, len(outline.Categories))
+	for _, category := range outline.Categories {
+		names = append(names, strings.ToLower(category.Name))
+	}
+	return names
+}
+
+// mergeLorebookEntries combines batch results, in batch order, into one entry list. Each
+// entry's keys are trimmed and deduplicated case-insensitively; entries whose first key names
+// an entity that already has an entry are folded into it (keys and secondary keys merged,
+// longer content kept along with its comment, settings the entry leaves unset filled in). Settings the two set differently keep the first
+// entry's value, and are returned as conflicts. Insertion order is renumbered 1..n and ids
+// 0..n-1, so both are unique and follow the outline even though each batch numbered its own.
+func mergeLorebookEntries(batches [][]models.LorebookEntry) (merged []models.LorebookEntry, conflicts []string) {
+	byPrimaryKey := make(map[string]int)
+	for _, entries := range batches {
+		for _, entry := range entries {
+			entry.Keys = dedupeKeys(entry.Keys)
+			if len(entry.Keys) == 0 || strings.TrimSpace(entry.Content) == "" {
+				continue
+			}
+			primary := strings.ToLower(entry.Keys[0])
+			if i, ok := byPrimaryKey[primary]; ok {
+				existing := &merged[i]
+				existing.Keys = dedupeKeys(slices.Concat(existing.Keys, entry.Keys))
+				if len(entry.SecondaryKeys) > 0 {
+					existing.SecondaryKeys = dedupeKeys(slices.Concat(existing.SecondaryKeys, entry.SecondaryKeys))
+				}
+				if len(entry.Content) > len(existing.Content) {
+					existing.Content = entry.Content
+					existing.Comment = cmp.Or(entry.Comment, existing.Comment)
+				}
+				existing.Priority = max(existing.Priority, entry.Priority)
+				conflicts = append(conflicts, mergeEntrySettings(existing, entry)...)
+				continue
+			}
+			byPrimaryKey[primary] = len(merged)
+			merged = append(merged, entry)
+		}
+	}
+	for i := range merged {
+		id := i
+		merged[i].InsertionOrder = i + 1
+		merged[i].ID = &id
+	}
+	return merged, conflicts
+}
+
+// mergeEntrySettings fills the SillyTavern settings existing leaves unset from duplicate, an
+// entry for the same entity, and describes those both set differently.
+func mergeEntrySettings(existing *models.LorebookEntry, duplicate models.LorebookEntry) []string {
+	var conflicts []string
+	conflict := func(name string, kept, dropped any) {
+		conflicts = append(conflicts, fmt.Sprintf("entry '%s': kept %s %v, dropped %v from a duplicate entry", existing.Keys[0], name, kept, dropped))
+	}
+	mergeSetting(&existing.Name, duplicate.Name, "name", conflict)
+	mergeSetting(&existing.SelectiveLogic, duplicate.SelectiveLogic, "selectiveLogic", conflict)
+	mergeSetting(&existing.Constant, duplicate.Constant, "constant", conflict)
+	mergeSetting(&existing.CaseSensitive, duplicate.CaseSensitive, "case_sensitive", conflict)
+	mergeSetting(&existing.UseRegex, duplicate.UseRegex, "use_regex", conflict)
+	mergeSetting(&existing.Position, duplicate.Position, "position", conflict)
+	mergeSetting(&existing.Group, duplicate.Group, "group", conflict)
+	mergeSetting(&existing.GroupWeight, duplicate.GroupWeight, "group_weight", conflict)
+	mergeSetting(&existing.Probability, duplicate.Probability, "probability", conflict)
+	mergeSetting(&existing.Sticky, duplicate.Sticky, "sticky", conflict)
+	mergeSetting(&existing.Cooldown, duplicate.Cooldown, "cooldown", conflict)
+	mergeSetting(&existing.Delay, duplicate.Delay, "delay", conflict)
+	mergeSetting(&existing.ExcludeRecursion, duplicate.ExcludeRecursion, "exclude_recursion", conflict)
+	mergeSetting(&existing.PreventRecursion, duplicate.PreventRecursion, "prevent_recursion", conflict)
+	mergeSetting(&existing.MatchWholeWords, duplicate.MatchWholeWords, "match_whole_words", conflict)
+	mergeSetting(&existing.Vectorized, duplicate.Vectorized, "vectorized", conflict)
+	switch {
+	case existing.Depth == nil:
+		existing.Depth = duplicate.Depth
+	case duplicate.Depth != nil && *duplicate.Depth != *existing.Depth:
+		conflict("depth", *existing.Depth, *duplicate.Depth)
+	}
+	return conflicts
+}
+
+// mergeSetting sets *into to from if it is unset (zero), and calls conflict if both are set
+// and differ.
+func mergeSetting[T comparable](into *T, from T, name string, conflict func(name string, kept, dropped any)) {
+	var zero T
+	switch {
+	case *into == zero:
+		*into = from
+	case from != zero && from != *into:
+		conflict(name, *into, from)
+	}
+}
+
+// dedupeKeys trims keys and drops empty and case-insensitively repeated ones, keeping the first spelling.
+func dedupeKeys(keys []string) []string {
+	seen := make(map[string]bool, len(keys))
+	out := make([]string, 0, len(keys))
+	for _, key := range keys {
+		key = strings.TrimSpace(key)
+		id := strings.ToLower(key)
+		if key == "" || seen[id] {
+			continue
+		}
+		seen[id] = true
+		out = append(out, key)
+	}
+	return out
+}
@@ -0,0 +1,108 @@
+package services
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+
+	"workspace/FictionGeminiRewritten/internal/models"
+)
+
+func TestPlanLorebookBatches(t *testing.T) {
+	outline := models.LorebookOutline{Categories: []models.LorebookOutlineCategory{
+		{Name: "Characters", Entities: []string{"Paul", " Jessica ", "", "Leto", "Duncan", "Gurney"}},
+		{Name: "Places", Entities: []string{"Arrakis", "paul"}},
+		{Name: "Religion"},
+		{Name: " ", Entities: nil},
+	}}
+	got := planLorebookBatches(outline, 2)
+	want := []lorebookBatch{
+		{category: "Characters", entities: []string{"Paul", "Jessica"}},
+		{category: "Characters", entities: []string{"Leto", "Duncan"}},
+		{category: "Characters", entities: []string{"Gurney"}},
+		{category: "Places", entities: []string{"Arrakis"}},
+		{category: "Religion", entities: []string{"Religion"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("planLorebookBatches = %+v, want %+v", got, want)
+	}
+}
+
+func TestDedupeKeys(t *testing.T) {
+	tests := []struct {
+		keys []string
+		want []string
+	}{
+		{nil, []string{}},
+		{[]string{"spice", " Spice ", "melange", "", "  ", "SPICE"}, []string{"spice", "melange"}},
+		{[]string{" Paul Atreides", "Muad'Dib", "paul atreides "}, []string{"Paul Atreides", "Muad'Dib"}},
+	}
+	for _, tt := range tests {
+		if got := dedupeKeys(tt.keys); !slices.Equal(got, tt.want) || got == nil {
+			t.Errorf("dedupeKeys(%q) = %q, want %q", tt.keys, got, tt.want)
+		}
+	}
+}
+
+func TestMergeLorebookEntries(t *testing.T) {
+	depth := 2
+	batches := [][]models.LorebookEntry{
+		{
+			{Keys: []string{"Spice", "melange"}, Content: "The spice.", Comment: "Spice", InsertionOrder: 1, Priority: 10,
+				SecondaryKeys: []string{"Arrakis"}, Position: models.LorebookPositionAtDepth, Probability: 50},
+			{Keys: []string{" "}, Content: "No keys."},
+			{Keys: []string{"Fremen"}, Content: "  "},
+		},
+		{
+			{Keys: []string{"spice", "Mélange"}, Content: "The spice extends life and expands consciousness.", Comment: "Spice, in full",
+				InsertionOrder: 1, Priority: 30, SecondaryKeys: []string{"arrakis", "Dune"}, SelectiveLogic: models.SelectiveLogicNotAny,
+				Constant: true, CaseSensitive: true, Position: models.LorebookPositionBeforeChar, Probability: 80, Depth: &depth},
+			{Keys: []string{"Fremen"}, Content: "Desert people.", InsertionOrder: 1},
+		},
+	}
+	merged, conflicts := mergeLorebookEntries(batches)
+	if len(merged) != 2 {
+		t.Fatalf("merged %d entries, want 2: %+v", len(merged), merged)
+	}
+
+	spice := merged[0]
+	if !slices.Equal(spice.Keys, []string{"Spice", "melange", "Mélange"}) || !slices.Equal(spice.SecondaryKeys, []string{"Arrakis", "Dune"}) {
+		t.Errorf("spice keys = %q, secondary keys = %q", spice.Keys, spice.SecondaryKeys)
+	}
+	if spice.Content != batches[1][0].Content || spice.Comment != "Spice, in full" || spice.Priority != 30 {
+		t.Errorf("spice content, comment, priority = %q, %q, %d; want the duplicate's", spice.Content, spice.Comment, spice.Priority)
+	}
+	if spice.SelectiveLogic != models.SelectiveLogicNotAny || !spice.Constant || !spice.CaseSensitive || spice.Depth == nil || *spice.Depth != 2 {
+		t.Errorf("settings the first entry left unset were not filled in: %+v", spice)
+	}
+	if spice.Position != models.LorebookPositionAtDepth || spice.Probability != 50 {
+		t.Errorf("position, probability = %q, %d; want the first entry's", spice.Position, spice.Probability)
+	}
+	want := []string{
+		"entry 'Spice': kept position at_depth, dropped before_char from a duplicate entry",
+		"entry 'Spice': kept probability 50, dropped 80 from a duplicate entry",
+	}
+	if !slices.Equal(conflicts, want) {
+		t.Errorf("conflicts = %q, want %q", conflicts, want)
+	}
+
+	for i, entry := range merged {
+		if entry.InsertionOrder != i+1 || entry.ID == nil || *entry.ID != i {
+			t.Errorf("entry %d: insertion order, id = %d, %v; want %d, %d", i, entry.InsertionOrder, entry.ID, i+1, i)
+		}
+	}
+	if batches[0][0].InsertionOrder != 1 || batches[0][0].ID != nil || len(batches[0][0].SecondaryKeys) != 1 {
+		t.Errorf("batch entry modified: %+v", batches[0][0])
+	}
+}
+
+func TestMergeLorebookEntriesComments(t *testing.T) {
+	merged, _ := mergeLorebookEntries([][]models.LorebookEntry{
+		{{Keys: []string{"Fremen"}, Content: "Desert people of Arrakis.", Comment: "Fremen"}},
+		{{Keys: []string{"fremen"}, Content: "Desert people.", Comment: "Desert dwellers"}},
+		{{Keys: []string{"FREMEN"}, Content: "The desert people of Arrakis.", Comment: ""}},
+	})
+	if len(merged) != 1 || merged[0].Content != "The desert people of Arrakis." || merged[0].Comment != "Fremen" {
+		t.Errorf("merged = %+v; want the longest content, and the first comment as the longest has none", merged)
+	}
+}
@@ -71,3 +71,37 @@ func SaveJSONToFile(baseDir, seriesName, subDirType, itemName, logIdentifier str
 	return fullPath, nil
 }
 
+// SavePNGNextToFile saves pngData beside a file saved by SaveJSONToFile, under the same name
+// with a .png extension.
+func SavePNGNextToFile(jsonPath string, pngData []byte) (string, error) {
+	fullPath := strings.TrimSuffix(jsonPath, filepath.Ext(jsonPath)) + ".png"
+
+	log.Printf("Attempting to save PNG to: %s", fullPath)
+	if err := os.WriteFile(fullPath, pngData, 0644); err != nil {
+		log.Printf("Error writing file %s: %v", fullPath, err)
+		return "", fmt.Errorf("failed to write file %s: %w", fullPath, err)
+	}
+
+	log.Printf("Successfully saved PNG to: %s", fullPath)
+	return fullPath, nil
+}
+
+// SaveWorldInfoNextToFile saves worldData in a worlds directory beside a file saved by
+// SaveJSONToFile, under the same name, so the directory can be copied into SillyTavern as is.
+func SaveWorldInfoNextToFile(jsonPath string, worldData []byte) (string, error) {
+	dirPath := filepath.Join(filepath.Dir(jsonPath), worldsDirName)
+	if err := os.MkdirAll(dirPath, 0755); err != nil {
+		log.Printf("Error creating directory %s: %v", dirPath, err)
+		return "", fmt.Errorf("failed to create directory %s: %w", dirPath, err)
+	}
+	fullPath := filepath.Join(dirPath, filepath.Base(jsonPath))
+
+	log.Printf("Attempting to save World Info to: %s", fullPath)
+	if err := os.WriteFile(fullPath, worldData, 0644); err != nil {
+		log.Printf("Error writing file %s: %v", fullPath, err)
+		return "", fmt.Errorf("failed to write file %s: %w", fullPath, err)
+	}
+
+	log.Printf("Successfully saved World Info to: %s", fullPath)
+	return fullPath, nil
+}
@@ -0,0 +1,272 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"workspace/FictionGeminiRewritten/internal/models"
+)
+
+// ErrJobQueueFull is returned by Submit when no more jobs can be queued.
+var ErrJobQueueFull = fmt.Errorf("job queue is full, try again later")
+
+// JobResult holds the orchestrator's return values for a finished job.
+type JobResult struct {
+	GeneratedJSON  string
+	MessageLog     string
+	OptionText     string
+	Err            error
+	APIKeyReceived bool // The key itself is discarded once the job finishes
+}
+
+// Job is one asynchronous generation request. Its state is updated from the request's
+// EventLog while it runs.
+type Job struct {
+	mu         sync.Mutex
+	id         string
+	payload    models.RequestPayload
+	logID      string
+	status     string
+	createdAt  time.Time
+	startedAt  time.Time
+	finishedAt time.Time
+	steps      []models.JobStep
+	artifacts  []models.ArtifactInfo
+	events     *EventLog
+	result     *JobResult
+}
+
+// ID returns the job's identifier.
+func (j *Job) ID() string { return j.id }
+
+// Payload returns the request the job was submitted with. The API key is cleared once the job has finished.
+func (j *Job) Payload() models.RequestPayload {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.payload
+}
+
+// Events returns the job's event log.
+func (j *Job) Events() *EventLog { return j.events }
+
+// Result returns the orchestrator's results once the job has finished, or nil while it is still queued or running.
+func (j *Job) Result() *JobResult {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.result
+}
+
+// Snapshot returns the job's current externally visible state. Artifact content is only
+// included when includeContent is set, to keep job listings small. Result is left for the
+// caller to fill in from Result().
+func (j *Job) Snapshot(includeContent bool) models.JobStatus {
+	// Read the message log before taking j.mu: EventLog calls track with its own lock held.
+	messageLog := j.events.Text()
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	status := models.JobStatus{
+		ID:            j.id,
+		Status:        j.status,
+		LogIdentifier: j.logID,
+		Series:        j.payload.Series,
+		Option:        j.payload.Option,
+		Model:         j.payload.Model,
+		CreatedAt:     j.createdAt.Format(time.RFC3339),
+		Steps:         append([]models.JobStep(nil), j.steps...),
+		Message:       messageLog,
+	}
+	if !j.startedAt.IsZero() {
+		status.StartedAt = j.startedAt.Format(time.RFC3339)
+	}
+	if !j.finishedAt.IsZero() {
+		status.FinishedAt = j.finishedAt.Format(time.RFC3339)
+	}
+	if n := len(j.steps); n > 0 && j.steps[n-1].Status == "running" {
+		status.CurrentStep = j.steps[n-1].Step
+	}
+	for _, artifact := range j.artifacts {
+		if !includeContent {
+			artifact.Content = ""
+		}
+		status.Artifacts = append(status.Artifacts, artifact)
+	}
+	return status
+}
+
+// track updates the job's step progress and artifacts from a generation event.
+func (j *Job) track(event models.GenerationEvent) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	switch event.Type {
+	case models.EventStepStart:
+		j.steps = append(j.steps, models.JobStep{Step: event.Step, Status: "running", StartedAt: event.Time})
+	case models.EventStepFinish:
+		for i := len(j.steps) - 1; i >= 0; i-- {
+			if j.steps[i].Step == event.Step && j.steps[i].Status == "running" {
+				j.steps[i].Status = "succeeded"
+				if event.Error != "" {
+					j.steps[i].Status = "failed"
+					j.steps[i].Error = event.Error
+				}
+				j.steps[i].FinishedAt = event.Time
+				break
+			}
+		}
+	case models.EventModel:
+		// Calls happen inside the step that is currently running.
+		if n := len(j.steps); n > 0 && j.steps[n-1].Status == "running" {
+			j.steps[n-1].Model = event.Model
+		}
+	case models.EventArtifact:
+		if event.Artifact != nil {
+			j.artifacts = append(j.artifacts, *event.Artifact)
+		}
+	}
+}
+
+// JobService runs generation requests on a fixed pool of workers, detached from the HTTP
+// request that submitted them, and keeps finished jobs for a retention period.
+type JobService struct {
+	orchestrator *OrchestratorService
+	retention    time.Duration
+	queue        chan *Job
+
+	mu   sync.Mutex
+	jobs map[string]*Job
+}
+
+// NewJobService starts workers goroutines executing queued jobs. Finished jobs are
+// forgotten once they are older than retention.
+func NewJobService(orchestrator *OrchestratorService, workers int, queueSize int, retention time.Duration) *JobService {
+	if workers < 1 {
+		workers = 1
+	}
+	js := &JobService{
+		orchestrator: orchestrator,
+		retention:    retention,
+		queue:        make(chan *Job, queueSize),
+		jobs:         make(map[string]*Job),
+	}
+	for i := 0; i < workers; i++ {
+		go js.worker()
+	}
+	go js.janitor()
+	return js
+}
+
+// Submit queues a generation request and returns its Job immediately.
+func (js *JobService) Submit(payload models.RequestPayload, logIdentifier string) (*Job, error) {
+	job := &Job{
+		id:        newJobID(),
+		payload:   payload,
+		logID:     logIdentifier,
+		status:    models.JobQueued,
+		createdAt: time.Now(),
+		events:    NewEventLog(),
+	}
+	job.events.Observe(job.track)
+
+	js.mu.Lock()
+	js.jobs[job.id] = job
+	js.mu.Unlock()
+
+	select {
+	case js.queue <- job:
+	default:
+		js.mu.Lock()
+		delete(js.jobs, job.id)
+		js.mu.Unlock()
+		return nil, ErrJobQueueFull
+	}
+	log.Printf("Queued job %s (Log ID: %s)", job.id, logIdentifier)
+	return job, nil
+}
+
+// Get returns the job with the given ID, if it is still retained.
+func (js *JobService) Get(id string) (*Job, bool) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	job, ok := js.jobs[id]
+	return job, ok
+}
+
+// List returns every retained job, newest first.
+func (js *JobService) List() []*Job {
+	js.mu.Lock()
+	jobs := make([]*Job, 0, len(js.jobs))
+	for _, job := range js.jobs {
+		jobs = append(jobs, job)
+	}
+	js.mu.Unlock()
+
+	sort.Slice(jobs, func(a, b int) bool { return jobs[a].createdAt.After(jobs[b].createdAt) })
+	return jobs
+}
+
+func (js *JobService) worker() {
+	for job := range js.queue {
+		js.run(job)
+	}
+}
+
+func (js *JobService) run(job *Job) {
+	job.mu.Lock()
+	job.status = models.JobRunning
+	job.startedAt = time.Now()
+	payload := job.payload
+	job.mu.Unlock()
+
+	log.Printf("Starting job %s (Log ID: %s)", job.id, job.logID)
+	generatedJSON, messageLog, optionText, err := js.orchestrator.ProcessGenerationRequest(
+		context.Background(), payload, job.logID, payload.APIKey, job.events)
+
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	job.result = &JobResult{GeneratedJSON: generatedJSON, MessageLog: messageLog, OptionText: optionText, Err: err, APIKeyReceived: payload.APIKey != ""}
+	job.status = models.JobSucceeded
+	if err != nil {
+		job.status = models.JobFailed
+	}
+	job.finishedAt = time.Now()
+	job.payload.APIKey = "" // Don't keep credentials around for the retention period
+	log.Printf("Finished job %s (Log ID: %s) with status %s", job.id, job.logID, job.status)
+}
+
+// janitor periodically drops finished jobs older than the retention period.
+func (js *JobService) janitor() {
+	interval := js.retention / 2
+	if interval > time.Minute || interval <= 0 {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		cutoff := time.Now().Add(-js.retention)
+		js.mu.Lock()
+		for id, job := range js.jobs {
+			job.mu.Lock()
+			expired := !job.finishedAt.IsZero() && job.finishedAt.Before(cutoff)
+			job.mu.Unlock()
+			if expired {
+				delete(js.jobs, id)
+			}
+		}
+		js.mu.Unlock()
+	}
+}
+
+func newJobID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("job_%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
@@ -1,13 +1,14 @@
 package services
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"path/filepath"
 	"strings"
-	"text/template"
+	"sync"
 
 	// No longer need "github.com/google/generative-ai-go/genai" directly here
 	"workspace/FictionGeminiRewritten/internal/ai"
@@ -18,298 +19,821 @@ import (
 
 const (
 	baseJSONSaveDir = "./jsons"
+
+	// defaultRepairAttempts is used when the request doesn't set RepairAttempts.
+	defaultRepairAttempts = 2
+
+	// defaultMaxContinuations is used when the request doesn't set MaxContinuations.
+	defaultMaxContinuations = 3
+
+	// maxReportedViolations caps how many schema violations are listed in the message log per artifact.
+	maxReportedViolations = 20
+)
+
+// Response schemas derived from the model types, sent with every structured call.
+var (
+	characterCardSchema   = ai.SchemaFor(models.CharacterCardV2{})
+	lorebookSchema        = ai.SchemaFor(models.Lorebook{})
+	toolSuggestionsSchema = ai.SchemaFor(models.AIToolSuggestions{})
 )
 
 // OrchestratorService handles the core logic of generating content based on options.
+// It talks to LLM backends exclusively through the ai.Provider interface.
 type OrchestratorService struct {
-	// geminiClient removed
+	routing ModelRouting // Server-wide per-step models and fallbacks
+	prices  PriceTable   // Per-model prices for usage cost estimates
+
+	templates *prompts.Registry // Prompt templates, reloaded as their overrides change
+
+	mu      sync.Mutex
+	running map[string]bool // Log identifiers of sessions currently being generated
 }
 
-// NewOrchestratorService creates a new OrchestratorService.
-func NewOrchestratorService() *OrchestratorService { // geminiClient parameter removed
-	return &OrchestratorService{}
+// NewOrchestratorService creates a new OrchestratorService. routing sets the server's default
+// per-step models and fallbacks; requests may override it. prices are used to estimate what
+// each session costs. Every prompt is rendered from templates.
+func NewOrchestratorService(routing ModelRouting, prices PriceTable, templates *prompts.Registry) *OrchestratorService {
+	return &OrchestratorService{routing: routing, prices: prices, templates: templates, running: make(map[string]bool)}
 }
 
 // ProcessGenerationRequest orchestrates the content generation based on the request payload.
+// Every step is checkpointed under the logIdentifier directory; when payload.ResumeLogIdentifier
+// is set, steps that succeeded in the earlier run are reused instead of regenerated.
+// Progress is emitted into events as it happens (pass NewEventLog() if nobody listens live).
 // It returns the final generated JSON string (can be multiple, concatenated), a detailed message log,
 // the chosen option text for the response, and an error if something went critically wrong.
 func (s *OrchestratorService) ProcessGenerationRequest(
 	ctx context.Context,
 	payload models.RequestPayload,
 	logIdentifier string,
-	apiKey string, // Added apiKey
+	apiKey string, // Forwarded to the provider selected by payload.Model
+	events *EventLog,
 ) (generatedJSONString string, messageLog string, optionText string, err error) {
+	defer func() {
+		if err != nil {
+			log.Printf("Error processing request (Log ID: %s): %v", logIdentifier, err)
+		}
+	}()
 
-	// model := s.geminiClient.GenerativeModel(payload.Model) // Removed
-	// model.GenerationConfig.ResponseMIMEType = "application/json" // As in original, but commented out.
+	provider, modelName, err := ai.ResolveProvider(payload.Model, apiKey)
+	if err != nil {
+		events.Message(fmt.Sprintf("ERROR selecting AI backend for model '%s': %v\n", payload.Model, err))
+		return "", events.Text(), "", fmt.Errorf("failed to select AI provider: %w", err)
+	}
+	events.Message(fmt.Sprintf("Using AI backend '%s' with model '%s'.\n", provider.Name(), modelName))
+	chains, err := s.routing.resolveModelChains(payload, apiKey)
+	if err != nil {
+		events.Message(fmt.Sprintf("ERROR selecting per-step models: %v\n", err))
+		return "", events.Text(), "", fmt.Errorf("failed to select AI provider: %w", err)
+	}
+	for _, line := range s.routing.describeRoutes(payload, chains) {
+		events.Message(line)
+	}
 
-	var messages []string // To accumulate log messages for the user
+	session := &aiSession{
+		chains:           chains,
+		repairAttempts:   defaultRepairAttempts,
+		maxContinuations: defaultMaxContinuations,
+		events:           events,
+	}
+	if payload.RepairAttempts != nil && *payload.RepairAttempts >= 0 {
+		session.repairAttempts = *payload.RepairAttempts
+	}
+	if payload.MaxContinuations != nil && *payload.MaxContinuations >= 0 {
+		session.maxContinuations = *payload.MaxContinuations
+	}
+	if payload.Generation != nil {
+		session.generation = *payload.Generation
+	}
+	session.stepGeneration = payload.StepGeneration
+	session.cardFormat = payload.CardFormat
+	session.safety = payload.SafetySettings
+	if len(session.safety) > 0 && provider.Name() != ai.DefaultBackend {
+		events.Message(fmt.Sprintf("Note: safety_settings only apply to the gemini backend; '%s' ignores them.\n", provider.Name()))
+	}
+	if usesSeed(payload) && provider.Name() == ai.DefaultBackend {
+		events.Message("Note: the gemini backend does not support a sampling seed; 'seed' is ignored.\n")
+	}
+	if session.fanout, err = newFanoutSettings(payload); err != nil {
+		events.Message(fmt.Sprintf("ERROR: %v\n", err))
+		return "", events.Text(), "", err
+	}
+	if events.Streaming() {
+		session.onToken = events.Token
+	}
 
-	switch payload.Option {
-	case "1":
-		optionText = "Lorebook Only (Comprehensive)"
-		messages = append(messages, fmt.Sprintf("Processing Option 1: Comprehensive Lorebook for '%s'.\n", payload.Series))
-		
-		promptString := fmt.Sprintf(prompts.ComprehensiveLorebookPrompt,
-			payload.Series, payload.Series, payload.Series, payload.Series, payload.Series, payload.Series)
-
-		// Call AI (updated)
-		aiResponse, aiErr := ai.CallGeminiAPI(ctx, apiKey, payload.Model, promptString)
-		if aiErr != nil {
-			messages = append(messages, fmt.Sprintf("  ERROR generating Comprehensive Lorebook: %v\n", aiErr))
-			return "", strings.Join(messages, ""), optionText, fmt.Errorf("AI generation failed for Comprehensive Lorebook: %w", aiErr)
+	var cp *checkpoint
+	if payload.ResumeLogIdentifier != "" {
+		if cp, err = loadCheckpoint(payload.ResumeLogIdentifier); err != nil {
+			events.Message(fmt.Sprintf("ERROR loading checkpoint for '%s': %v\n", payload.ResumeLogIdentifier, err))
+			return "", events.Text(), "", err
 		}
+		events.Message(fmt.Sprintf("Resuming session %s: steps that already succeeded will be reused.\n", logIdentifier))
+	} else {
+		cp = newCheckpoint(payload, logIdentifier)
+	}
 
-		var loreBook models.Lorebook
-		if err := json.Unmarshal([]byte(aiResponse), &loreBook); err != nil {
-			log.Printf("Failed to unmarshal Comprehensive Lorebook (Log ID %s): %v. AI Response: %s", logIdentifier, err, aiResponse)
-			messages = append(messages, fmt.Sprintf("  ERROR parsing AI response for Comprehensive Lorebook. Raw AI output (check logs for ID %s for details): %s\n", logIdentifier, aiResponse[:util.Min(600, len(aiResponse))]))
-			return "", strings.Join(messages, ""), optionText, fmt.Errorf("failed to parse AI response for Comprehensive Lorebook: %w", err)
-		}
+	if !s.claim(logIdentifier) {
+		events.Message(fmt.Sprintf("ERROR: session %s is already running.\n", logIdentifier))
+		return "", events.Text(), "", fmt.Errorf("session %s is already running", logIdentifier)
+	}
+	defer s.release(logIdentifier)
 
-		loreBook.Enabled = true
-		if loreBook.Name == "" {
-			loreBook.Name = fmt.Sprintf("Comprehensive Lore for %s", payload.Series)
-		}
-		for i := range loreBook.Entries {
-			loreBook.Entries[i].Enabled = true
+	sessionDir := filepath.Dir(cp.path)
+	session.cardDate = sessionCreated(cp)
+	if session.avatar, err = sessionAvatar(payload, sessionDir); err != nil {
+		events.Message(fmt.Sprintf("ERROR: invalid avatar: %v\n", err))
+		return "", events.Text(), "", err
+	}
+	if session.contextCards, err = contextCardsText(payload); err != nil {
+		events.Message(fmt.Sprintf("ERROR: cannot load context cards: %v\n", err))
+		return "", events.Text(), "", err
+	}
+	var previousUsage *models.UsageReport
+	if payload.ResumeLogIdentifier != "" {
+		previousUsage = loadUsageReport(sessionDir)
+	}
+	session.usage = newUsageTracker(s.prices, previousUsage)
+	defer func() {
+		report := session.usage.finish(sessionDir, events)
+		log.Printf("Token usage for %s: %d calls, %d tokens, estimated cost %.4f %s",
+			logIdentifier, report.Total.Calls, report.Total.TotalTokens, report.Total.EstimatedCost, report.Currency)
+	}()
+
+	// A resumed session keeps rendering the template versions it started with.
+	var assignment models.PromptAssignment
+	if cp.Prompts != nil {
+		var selectErr error
+		if session.prompts, assignment, selectErr = s.resumedPrompts(*cp.Prompts); selectErr != nil {
+			events.Message(fmt.Sprintf("Note: prompt versions of the original run are gone, using the current defaults instead:\n%v\n", selectErr))
 		}
+	} else {
+		session.prompts, assignment = s.assignPrompts(payload)
+		cp.Prompts = &assignment
+	}
+	if assignment.ExperimentSeed != nil {
+		events.Message(fmt.Sprintf("Experiment mode (seed %d): prompt versions %s.\n", *assignment.ExperimentSeed, versionsKey(assignment.Versions)))
+	}
+	events.Prompts(assignment)
+	session.outcomes = newOutcomeTracker(session.promptVersions)
+	defer session.outcomes.finish(sessionDir, models.PromptRun{
+		LogIdentifier:  logIdentifier,
+		Series:         payload.Series,
+		Option:         payload.Option,
+		ExperimentSeed: assignment.ExperimentSeed,
+	})
 
-		jsonData, _ := json.MarshalIndent(loreBook, "", "  ")
-		generatedJSONString = string(jsonData)
+	switch payload.Option {
+	case "1":
+		optionText = describeOption(payload)
+		events.Message(fmt.Sprintf("Processing Option 1: Comprehensive Lorebook for '%s'.\n", payload.Series))
 
-		filePath, saveErr := SaveJSONToFile(baseJSONSaveDir, payload.Series, "lorebook_comprehensive", loreBook.Name, logIdentifier, jsonData)
-		if saveErr != nil {
-			messages = append(messages, fmt.Sprintf("  Successfully generated Comprehensive Lorebook JSON, but FAILED to save to server file system. Error: %s\n", saveErr.Error()))
-			log.Printf("Failed to save Comprehensive Lorebook JSON to file (Log ID %s): %v", logIdentifier, saveErr)
-		} else {
-			messages = append(messages, fmt.Sprintf("  Successfully generated Comprehensive Lorebook JSON and saved to: %s\n", filePath))
+		loreBook, _, errLorebook := runCheckpointed(cp, models.StepComprehensiveLorebook, "Comprehensive Lorebook", true, events, func() (models.Lorebook, error) {
+			lorebook, _, err := s.generateComprehensiveLorebook(ctx, session, payload.Series, logIdentifier, events)
+			return lorebook, err
+		})
+		if errLorebook != nil {
+			return "", events.Text(), optionText, errLorebook
 		}
-		messages = append(messages, "Comprehensive Lorebook generation complete.\n")
-		messageLog = strings.Join(messages, "")
-		return generatedJSONString, messageLog, optionText, nil
+		return prettyJSON(loreBook), events.Text(), optionText, nil
 
 	case "3": // Utility/Tool Card
-		optionText = fmt.Sprintf("Utility/Tool Card Creator (%s)", payload.ToolCardPurpose)
-		messages = append(messages, fmt.Sprintf("Processing Option 3: Utility/Tool Card ('%s') for series '%s'.\n", payload.ToolCardPurpose, payload.Series))
+		optionText = describeOption(payload)
+		events.Message(fmt.Sprintf("Processing Option 3: Utility/Tool Card ('%s') for series '%s'.\n", payload.ToolCardPurpose, payload.Series))
 
 		if strings.TrimSpace(payload.ToolCardPurpose) == "" {
-			messages = append(messages, "  ERROR: Tool Card Purpose is missing for Option 3.\n")
-			return "", strings.Join(messages, ""), optionText, fmt.Errorf("missing Tool Card Purpose for Option 3")
-		}
-		
-		promptData := struct {
-			SeriesName  string
-			ToolPurpose string
-		}{
-			SeriesName:  payload.Series,
-			ToolPurpose: payload.ToolCardPurpose,
-		}
-
-		var filledPrompt bytes.Buffer
-		tmpl, err := template.New("toolCardPrompt").Parse(prompts.ToolCardPromptTemplate)
-		if err != nil {
-			messages = append(messages, fmt.Sprintf("  ERROR: Failed to parse tool card prompt template: %v\n", err))
-			return "", strings.Join(messages, ""), optionText, fmt.Errorf("failed to parse tool card prompt template: %w", err)
-		}
-		if err := tmpl.Execute(&filledPrompt, promptData); err != nil {
-			messages = append(messages, fmt.Sprintf("  ERROR: Failed to execute tool card prompt template: %v\n", err))
-			return "", strings.Join(messages, ""), optionText, fmt.Errorf("failed to execute tool card prompt template: %w", err)
-		}
-		actualPrompt := filledPrompt.String()
-
-		// Call AI (updated)
-		aiResponse, aiErr := ai.CallGeminiAPI(ctx, apiKey, payload.Model, actualPrompt)
-		if aiErr != nil {
-			messages = append(messages, fmt.Sprintf("  ERROR generating Tool Card ('%s'): %v\n", payload.ToolCardPurpose, aiErr))
-			return "", strings.Join(messages, ""), optionText, fmt.Errorf("AI generation failed for Tool Card ('%s'): %w", payload.ToolCardPurpose, aiErr)
-		}
-
-		var toolCard models.CharacterCardV2
-		if err := json.Unmarshal([]byte(aiResponse), &toolCard); err != nil {
-			log.Printf("Failed to unmarshal Tool Card (Log ID %s): %v. AI Response: %s", logIdentifier, err, aiResponse)
-			messages = append(messages, fmt.Sprintf("  ERROR parsing AI response for Tool Card ('%s'). Raw AI output (check logs for ID %s for details): %s\n", payload.ToolCardPurpose, logIdentifier, aiResponse[:util.Min(600, len(aiResponse))]))
-			return "", strings.Join(messages, ""), optionText, fmt.Errorf("failed to parse AI response for Tool Card ('%s'): %w", payload.ToolCardPurpose, err)
-		}
-
-		if toolCard.Spec == "" {toolCard.Spec = "chara_card_v2"}
-		if toolCard.SpecVersion == "" {toolCard.SpecVersion = "2.0"}
-		if toolCard.Data.Name == "" {
-			toolCard.Data.Name = fmt.Sprintf("%s for %s", payload.ToolCardPurpose, payload.Series)
+			events.Message("  ERROR: Tool Card Purpose is missing for Option 3.\n")
+			return "", events.Text(), optionText, fmt.Errorf("missing Tool Card Purpose for Option 3")
 		}
-		toolCard.Data.CharacterBook = nil 
-
-		jsonData, _ := json.MarshalIndent(toolCard, "", "  ")
-		generatedJSONString = string(jsonData)
 
-		filePath, saveErr := SaveJSONToFile(baseJSONSaveDir, payload.Series, "tool_card", toolCard.Data.Name, logIdentifier, jsonData)
-		if saveErr != nil {
-			messages = append(messages, fmt.Sprintf("  Successfully generated Tool Card ('%s'), but FAILED to save. Error: %s\n", payload.ToolCardPurpose, saveErr.Error()))
-		} else {
-			messages = append(messages, fmt.Sprintf("  Successfully generated and saved Tool Card ('%s') to: %s\n", payload.ToolCardPurpose, filePath))
+		toolCard, _, errToolCard := runCheckpointed(cp, models.StepToolCard, "Tool Card", true, events, func() (models.CharacterCardV2, error) {
+			card, _, err := s.generateToolCard(ctx, session, payload.Series, payload.ToolCardPurpose, logIdentifier, events)
+			return card, err
+		})
+		if errToolCard != nil {
+			return "", events.Text(), optionText, errToolCard
 		}
-		messages = append(messages, fmt.Sprintf("Option 3: Utility/Tool Card ('%s') generation complete.\n", payload.ToolCardPurpose))
-		messageLog = strings.Join(messages, "")
-		return generatedJSONString, messageLog, optionText, nil
+		events.Message(fmt.Sprintf("Option 3: Utility/Tool Card ('%s') generation complete.\n", payload.ToolCardPurpose))
+		return prettyJSON(session.exportCard(toolCard)), events.Text(), optionText, nil
 
 	case "2": // Narrator Card + Master Lorebook
-		optionText = "Narrator Card + Master Lorebook (Refined)"
-		messages = append(messages, fmt.Sprintf("Processing Option 2: Narrator Card + Master Lorebook for '%s'. This is a multi-step process.\n\n", payload.Series))
+		optionText = describeOption(payload)
+		events.Message(fmt.Sprintf("Processing Option 2: Narrator Card + Master Lorebook for '%s'. This is a multi-step process.\n\n", payload.Series))
 		var allGeneratedJSONsOpt2 []string
 
-		// Step 1: Generate Narrator Character Card (updated call)
-		_, narratorJSON, errNarrator := s.generateNarratorCard(ctx, apiKey, payload.Model, payload.Series, logIdentifier, &messages)
+		// Step 1: Generate Narrator Character Card
+		narratorCard, _, errNarrator := s.narratorCardStep(ctx, session, cp, payload.Series, logIdentifier, events)
 		if errNarrator != nil {
-			return "", strings.Join(messages, ""), optionText, errNarrator
+			return "", events.Text(), optionText, errNarrator
 		}
-		allGeneratedJSONsOpt2 = append(allGeneratedJSONsOpt2, narratorJSON)
+		allGeneratedJSONsOpt2 = append(allGeneratedJSONsOpt2, prettyJSON(session.exportCard(narratorCard)))
 
-		// Step 2: Generate Master Lorebook (updated call)
-		_, lorebookJSON, errLorebook := s.generateMasterLorebook(ctx, apiKey, payload.Model, payload.Series, logIdentifier, &messages)
+		// Step 2: Generate Master Lorebook
+		masterLorebook, _, errLorebook := s.masterLorebookStep(ctx, session, cp, payload.Series, logIdentifier, events)
 		if errLorebook != nil {
 			log.Printf("Error in Option 2, Step 2 (Master Lorebook) but Narrator Card might be okay. Log ID: %s, Err: %v", logIdentifier, errLorebook)
 		} else {
-			allGeneratedJSONsOpt2 = append(allGeneratedJSONsOpt2, lorebookJSON)
+			allGeneratedJSONsOpt2 = append(allGeneratedJSONsOpt2, prettyJSON(masterLorebook))
 		}
 
 		generatedJSONString = strings.Join(allGeneratedJSONsOpt2, "\n\n"+models.CHARACTER_CARD_SEPARATOR+"\n\n")
-		messages = append(messages, "Option 2 (Narrator Card + Master Lorebook) processing finished.\n")
-		messageLog = strings.Join(messages, "")
-		return generatedJSONString, messageLog, optionText, nil 
+		events.Message("Option 2 (Narrator Card + Master Lorebook) processing finished.\n")
+		return generatedJSONString, events.Text(), optionText, nil
 
 	case "4": // Ultimate Pack (Narrator + Lorebook + 2 AI-Suggested Tools)
-		optionText = "Narrator + Lorebook + Tailored Utils (Ultimate Pack)"
-		messages = append(messages, fmt.Sprintf("Processing Option 4: ULTIMATE PACK for '%s'. This is a multi-step process and will take time.\n\n", payload.Series))
+		optionText = describeOption(payload)
+		events.Message(fmt.Sprintf("Processing Option 4: ULTIMATE PACK for '%s'. This is a multi-step process and will take time.\n\n", payload.Series))
 		var allGeneratedJSONsOpt4 []string
-		var errOption4 error 
 
-		// Step 1: Generate Narrator Character Card (updated call)
-		narratorCard, narratorJSON, errNarrator := s.generateNarratorCard(ctx, apiKey, payload.Model, payload.Series, logIdentifier, &messages)
+		// Step 1: Generate Narrator Character Card
+		narratorCard, narratorRan, errNarrator := s.narratorCardStep(ctx, session, cp, payload.Series, logIdentifier, events)
 		if errNarrator != nil {
-			errOption4 = errNarrator
-			return "", strings.Join(messages, ""), optionText, errOption4
+			return "", events.Text(), optionText, errNarrator
 		}
-		allGeneratedJSONsOpt4 = append(allGeneratedJSONsOpt4, narratorJSON)
+		allGeneratedJSONsOpt4 = append(allGeneratedJSONsOpt4, prettyJSON(session.exportCard(narratorCard)))
 
-		// Step 2: Generate Master Lorebook (updated call)
-		masterLorebook, lorebookJSON, errLorebook := s.generateMasterLorebook(ctx, apiKey, payload.Model, payload.Series, logIdentifier, &messages)
+		// Step 2: Generate Master Lorebook
+		masterLorebook, lorebookRan, errLorebook := s.masterLorebookStep(ctx, session, cp, payload.Series, logIdentifier, events)
 		if errLorebook != nil {
 			log.Printf("Error in Option 4, Step 2 (Master Lorebook) but continuing. Log ID: %s, Err: %v", logIdentifier, errLorebook)
 		} else {
-			allGeneratedJSONsOpt4 = append(allGeneratedJSONsOpt4, lorebookJSON)
+			allGeneratedJSONsOpt4 = append(allGeneratedJSONsOpt4, prettyJSON(masterLorebook))
 		}
 
-		// Step 3: Generate Contextual Summary (updated call)
-		worldContextSummary, _ := s.generateContextualSummary(ctx, apiKey, payload.Model, payload.Series, narratorCard.Data, masterLorebook, logIdentifier, &messages)
-		
-		// Step 4: AI Suggest Utility Tools (updated call)
-		suggestedTools, errSuggest := s.suggestUtilityTools(ctx, apiKey, payload.Model, payload.Series, worldContextSummary, logIdentifier, &messages)
+		// Steps 3-5 build on everything before them, so once an earlier step is regenerated
+		// their checkpointed outputs are stale and they run again too.
+		upstreamRan := narratorRan || lorebookRan
+
+		// Step 3: Generate Contextual Summary
+		worldContextSummary, summaryRan, _ := runCheckpointed(cp, models.StepContextSummary, "Contextual Summary", !upstreamRan, events, func() (string, error) {
+			return s.generateContextualSummary(ctx, session, payload.Series, narratorCard.Data, masterLorebook, logIdentifier, events)
+		})
+		upstreamRan = upstreamRan || summaryRan
+
+		// Step 4: AI Suggest Utility Tools
+		suggestedTools, suggestRan, errSuggest := runCheckpointed(cp, models.StepToolSuggestion, "AI Tool Suggestions", !upstreamRan, events, func() ([]models.AISuggestedTool, error) {
+			return s.suggestUtilityTools(ctx, session, payload.Series, worldContextSummary, logIdentifier, events)
+		})
 		if errSuggest != nil {
 			log.Printf("Error in Option 4, Step 4 (Suggest Tools) but continuing. Log ID: %s, Err: %v", logIdentifier, errSuggest)
-			suggestedTools = []models.AISuggestedTool{} 
+			suggestedTools = []models.AISuggestedTool{}
 		}
+		upstreamRan = upstreamRan || suggestRan
 
 		// Step 5: Generate Each Suggested Utility Card (if suggestions were successful)
 		if len(suggestedTools) == 2 {
 			for i, toolSuggestion := range suggestedTools {
-				// Updated call
-				utilityJSON, errTool := s.generateTailoredUtilityCard(ctx, apiKey, payload.Model, payload.Series, toolSuggestion, narratorCard.Data, masterLorebook, worldContextSummary, logIdentifier, i, &messages)
+				key := fmt.Sprintf("%s_%d", models.StepUtilityCard, i+1)
+				label := fmt.Sprintf("Tailored Utility Card '%s'", toolSuggestion.ToolName)
+				utilityJSON, _, errTool := runCheckpointed(cp, key, label, !upstreamRan, events, func() (string, error) {
+					return s.generateTailoredUtilityCard(ctx, session, payload.Series, toolSuggestion, narratorCard.Data, masterLorebook, worldContextSummary, logIdentifier, i, events)
+				})
 				if errTool != nil {
 					log.Printf("Error in Option 4, Step 5 (Generate Tool %d: %s) but continuing. Log ID: %s, Err: %v", i+1, toolSuggestion.ToolName, logIdentifier, errTool)
 				} else {
 					allGeneratedJSONsOpt4 = append(allGeneratedJSONsOpt4, utilityJSON)
 				}
 			}
-			messages = append(messages, "Tailored utility card generation attempts complete.\n\n")
+			events.Message("Tailored utility card generation attempts complete.\n\n")
 		} else if errSuggest == nil {
-			messages = append(messages, "Skipped generation of tailored utility tools as AI suggestions were not successfully processed (wrong count).\n\n")
+			events.Message("Skipped generation of tailored utility tools as AI suggestions were not successfully processed (wrong count).\n\n")
 		}
 
 		generatedJSONString = strings.Join(allGeneratedJSONsOpt4, "\n\n"+models.CHARACTER_CARD_SEPARATOR+"\n\n")
-		messages = append(messages, fmt.Sprintf("Option 4: ULTIMATE PACK for '%s' processing finished. Check all generated files and messages.\n", payload.Series))
-		messageLog = strings.Join(messages, "")
-		return generatedJSONString, messageLog, optionText, nil 
+		events.Message(fmt.Sprintf("Option 4: ULTIMATE PACK for '%s' processing finished. Check all generated files and messages.\n", payload.Series))
+		return generatedJSONString, events.Text(), optionText, nil
 
 	default:
-		return "", "Invalid option selected in orchestrator.", "Unknown Option", fmt.Errorf("invalid option: %s", payload.Option)
+		events.Message("Invalid option selected in orchestrator.")
+		return "", events.Text(), "Unknown Option", fmt.Errorf("invalid option: %s", payload.Option)
+	}
+}
+
+// describeOption returns the display name of the request's option, as echoed in responses.
+func describeOption(payload models.RequestPayload) string {
+	switch payload.Option {
+	case "1":
+		return "Lorebook Only (Comprehensive)"
+	case "2":
+		return "Narrator Card + Master Lorebook (Refined)"
+	case "3":
+		return fmt.Sprintf("Utility/Tool Card Creator (%s)", payload.ToolCardPurpose)
+	case "4":
+		return "Narrator + Lorebook + Tailored Utils (Ultimate Pack)"
+	}
+	return "Unknown Option"
+}
+
+// claim marks a session as running, returning false if it already is (e.g. the same session
+// resumed twice at once), since both runs would write the same checkpoint and artifacts.
+func (s *OrchestratorService) claim(logIdentifier string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running[logIdentifier] {
+		return false
+	}
+	s.running[logIdentifier] = true
+	return true
+}
+
+func (s *OrchestratorService) release(logIdentifier string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.running, logIdentifier)
+}
+
+// narratorCardStep runs the checkpointed Narrator Card step shared by Options 2 and 4.
+func (s *OrchestratorService) narratorCardStep(ctx context.Context, session *aiSession, cp *checkpoint, seriesName, logIdentifier string, events *EventLog) (models.CharacterCardV2, bool, error) {
+	return runCheckpointed(cp, models.StepNarratorCard, "Narrator Card", true, events, func() (models.CharacterCardV2, error) {
+		card, _, err := s.generateNarratorCard(ctx, session, seriesName, logIdentifier, events)
+		return card, err
+	})
+}
+
+// masterLorebookStep runs the checkpointed Master Lorebook step shared by Options 2 and 4.
+func (s *OrchestratorService) masterLorebookStep(ctx context.Context, session *aiSession, cp *checkpoint, seriesName, logIdentifier string, events *EventLog) (models.Lorebook, bool, error) {
+	return runCheckpointed(cp, models.StepMasterLorebook, "Master Lorebook", true, events, func() (models.Lorebook, error) {
+		lorebook, _, err := s.generateMasterLorebook(ctx, session, seriesName, logIdentifier, events)
+		return lorebook, err
+	})
+}
+
+// aiSession carries the per-step models and the per-request AI settings shared by every pipeline step.
+type aiSession struct {
+	chains           map[string][]modelTarget // Primary model and fallbacks per step
+	repairAttempts   int // Follow-up model calls allowed per artifact when its JSON doesn't parse
+	maxContinuations int // Follow-up model calls allowed per call when output hits the token limit
+	fanout           *fanoutSettings // Set when lorebooks are generated in fan-out mode
+	events           *EventLog
+	usage            *usageTracker // Token usage and cost of every successful call
+	prompts          prompts.Selection // Template versions the session renders
+	cardFormat       string            // models.CardFormatV2 or models.CardFormatV3; empty means V2
+	cardDate         int64             // Creation date of V3 cards, in Unix seconds
+	avatar           []byte            // PNG that cards are embedded into; nil uses the default
+	contextCards     string            // Excerpts of the request's workspace cards, quoted in card and lorebook prompts
+	outcomes         *outcomeTracker   // How each step's prompts fared, for comparing versions
+
+	generation     models.GenerationConfig            // Sampling parameters for every call
+	stepGeneration map[string]models.GenerationConfig // Per-step overrides of generation
+	safety         map[string]string                  // Safety thresholds per harm category
+
+	// onToken, when set, receives streamed model output tagged with the step that produced it.
+	onToken func(step, chunk string)
+}
+
+// call sends a prompt to the step's model, falling back to the next model in the step's
+// chain whenever a model fails or is blocked. The model that answered is recorded in events.
+func (a *aiSession) call(ctx context.Context, step string, prompt string, schema *ai.Schema) (string, error) {
+	chain := a.chains[step]
+	for i, target := range chain {
+		text, usage, err := a.callModel(ctx, target, step, prompt, schema)
+		if err == nil {
+			a.events.ModelUsed(step, target.spec, usage)
+			a.outcomes.used(step, usage)
+			return text, nil
+		}
+		if i == len(chain)-1 || ctx.Err() != nil {
+			return "", err
+		}
+		log.Printf("Model %s failed for step %s, falling back to %s: %v", target.spec, step, chain[i+1].spec, err)
+		a.events.Message(fmt.Sprintf("  Model %s failed for %s (%v); falling back to %s.\n", target.spec, step, err, chain[i+1].spec))
+	}
+	return "", fmt.Errorf("no model configured for step %s", step)
+}
+
+// callModel sends a prompt through one model. A non-nil schema requests JSON output
+// constrained to that schema; a nil schema requests plain text. Output cut off at the token
+// limit is continued with follow-up calls, up to maxContinuations, and stitched together.
+// It returns the text and the usage of all the calls it made.
+func (a *aiSession) callModel(ctx context.Context, target modelTarget, step string, prompt string, schema *ai.Schema) (string, models.TokenUsage, error) {
+	var usage models.TokenUsage
+	req := ai.Request{Model: target.model, Prompt: prompt, Schema: schema, Config: a.configFor(step), Safety: a.safety}
+	var streamed strings.Builder // Token text of the current attempt, reported if it is retried
+	if a.onToken != nil {
+		req.OnToken = func(chunk string) {
+			streamed.WriteString(chunk)
+			a.onToken(step, chunk)
+		}
+		req.OnRetry = func(attempt int, err error) {
+			a.events.Retry(step, attempt, err, streamed.String())
+			streamed.Reset()
+		}
+	}
+	var resp *ai.Response
+	var err error
+	if schema != nil {
+		resp, err = target.provider.GenerateJSON(ctx, req)
+	} else {
+		resp, err = target.provider.GenerateText(ctx, req)
+	}
+	if err != nil {
+		a.reportSafetyBlock(step, err)
+		return "", usage, err
+	}
+	usage = addUsage(usage, a.usage.add(step, target.spec, resp.Usage))
+
+	text := resp.Text
+	truncated := resp.Truncated
+	rounds := 0
+	for truncated && rounds < a.maxContinuations {
+		rounds++
+		a.events.Message(fmt.Sprintf("  Output for %s hit the token limit; continuation round %d/%d...\n", step, rounds, a.maxContinuations))
+
+		continuationPrompt, err := continuationPrompt(a.prompts, prompt, text)
+		if err != nil {
+			return "", usage, err
+		}
+		// The fragment on its own isn't valid JSON, so continuations are always requested as text.
+		streamed.Reset()
+		next, err := target.provider.GenerateText(ctx, ai.Request{Model: target.model, Prompt: continuationPrompt, Config: req.Config, Safety: req.Safety, OnToken: req.OnToken, OnRetry: req.OnRetry})
+		if err != nil {
+			a.events.Message(fmt.Sprintf("  Continuation round %d for %s failed: %v\n", rounds, step, err))
+			break
+		}
+		usage = addUsage(usage, a.usage.add(step, target.spec, next.Usage))
+		text = ai.StitchContinuation(text, next.Text)
+		truncated = next.Truncated
+	}
+
+	if rounds > 0 {
+		log.Printf("Output for %s needed %d continuation round(s) (truncated: %v)", step, rounds, truncated)
+		status := "complete"
+		if truncated {
+			status = "still cut off"
+		}
+		if schema != nil {
+			if violations, verr := ai.ValidateJSON([]byte(text), schema); verr != nil {
+				status += ", not yet valid JSON"
+			} else if len(violations) > 0 {
+				status += fmt.Sprintf(", %d schema violations", len(violations))
+			} else {
+				status += ", valid JSON"
+			}
+		}
+		a.events.Message(fmt.Sprintf("  Output for %s needed %d continuation round(s); stitched result is %s.\n", step, rounds, status))
+	}
+	return text, usage, nil
+}
+
+// reportSafetyBlock records which step a safety block happened in and explains it in the message log.
+func (a *aiSession) reportSafetyBlock(step string, err error) {
+	diag := ai.SafetyDiagnosticOf(err)
+	if diag == nil {
+		return
+	}
+	if diag.Step == "" {
+		diag.Step = step
 	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "  Blocked by safety filters in step %s (%s", step, diag.Source)
+	if diag.BlockReason != "" {
+		fmt.Fprintf(&b, ", block reason: %s", diag.BlockReason)
+	}
+	if diag.FinishReason != "" {
+		fmt.Fprintf(&b, ", finish reason: %s", diag.FinishReason)
+	}
+	b.WriteString(").\n")
+	for _, r := range diag.Ratings {
+		if r.Blocked {
+			fmt.Fprintf(&b, "    - %s: probability %s\n", r.Category, r.Probability)
+		}
+	}
+	if diag.Hint != "" {
+		fmt.Fprintf(&b, "  %s\n", diag.Hint)
+	}
+	a.events.Message(b.String())
+}
+
+// configFor returns the generation parameters for a step: the request-wide ones with the
+// step's overrides applied on top.
+func (a *aiSession) configFor(step string) models.GenerationConfig {
+	cfg := a.generation
+	override, ok := a.stepGeneration[step]
+	if !ok {
+		return cfg
+	}
+	if override.Temperature != nil {
+		cfg.Temperature = override.Temperature
+	}
+	if override.TopP != nil {
+		cfg.TopP = override.TopP
+	}
+	if override.TopK != nil {
+		cfg.TopK = override.TopK
+	}
+	if override.MaxOutputTokens != nil {
+		cfg.MaxOutputTokens = override.MaxOutputTokens
+	}
+	if override.StopSequences != nil {
+		cfg.StopSequences = override.StopSequences
+	}
+	if override.CandidateCount != nil {
+		cfg.CandidateCount = override.CandidateCount
+	}
+	if override.Seed != nil {
+		cfg.Seed = override.Seed
+	}
+	return cfg
+}
+
+// usesSeed reports whether the request sets a sampling seed anywhere.
+func usesSeed(payload models.RequestPayload) bool {
+	if payload.Generation != nil && payload.Generation.Seed != nil {
+		return true
+	}
+	for _, cfg := range payload.StepGeneration {
+		if cfg.Seed != nil {
+			return true
+		}
+	}
+	return false
 }
 
-// Helper function to execute a text/template (no changes needed here)
-func executeTemplate(templateName string, templateStr string, data interface{}) (string, error) {
-	var filledPrompt bytes.Buffer
-	tmpl, err := template.New(templateName).Parse(templateStr)
+// parseWithRepair decodes aiResponse into target. When it doesn't parse, the local fixer runs
+// first, then up to repairAttempts follow-up model calls that send back the error and the broken
+// JSON. Every repair attempt is recorded in events, and the result in the step's outcome. It
+// returns the last response text tried, for logging, along with the final parse error if all
+// attempts failed.
+func (a *aiSession) parseWithRepair(ctx context.Context, step string, artifactName string, aiResponse string, schema *ai.Schema, target interface{}, logIdentifier string, events *EventLog) (_ string, err error) {
+	repairs := 0
+	defer func() { a.outcomes.parsed(step, repairs, err) }()
+
+	parseErr := parseStructuredResponse(aiResponse, schema, target)
+	if parseErr == nil {
+		return aiResponse, nil
+	}
+
+	if fixed, fixes := ai.RepairJSON(aiResponse); len(fixes) > 0 {
+		err := parseStructuredResponse(fixed, schema, target)
+		if err == nil {
+			events.Message(fmt.Sprintf("  Repaired %s locally (%s).\n", artifactName, strings.Join(fixes, ", ")))
+			return fixed, nil
+		}
+		// The model is shown what it wrote, not a local repair that may have cut the wrong parts.
+		events.Message(fmt.Sprintf("  Local repair of %s (%s) was not enough: %v\n", artifactName, strings.Join(fixes, ", "), err))
+	}
+
+	for attempt := 1; attempt <= a.repairAttempts; attempt++ {
+		events.Message(fmt.Sprintf("  Repair attempt %d/%d for %s: sending the parse error back to the model...\n", attempt, a.repairAttempts, artifactName))
+		log.Printf("Repair attempt %d/%d for %s (Log ID %s): %v", attempt, a.repairAttempts, artifactName, logIdentifier, parseErr)
+
+		repairPrompt, err := jsonRepairPrompt(a.prompts, artifactName, describeParseError(parseErr, aiResponse), aiResponse)
+		if err != nil {
+			return aiResponse, parseErr
+		}
+
+		repairs++
+		repaired, err := a.call(ctx, step, repairPrompt, schema)
+		if err != nil {
+			events.Message(fmt.Sprintf("  Repair attempt %d/%d for %s failed: %v\n", attempt, a.repairAttempts, artifactName, err))
+			return aiResponse, parseErr
+		}
+		if fixed, fixes := ai.RepairJSON(repaired); len(fixes) > 0 {
+			repaired = fixed
+		}
+
+		aiResponse = repaired
+		if parseErr = parseStructuredResponse(aiResponse, schema, target); parseErr == nil {
+			events.Message(fmt.Sprintf("  Repair attempt %d/%d for %s succeeded.\n", attempt, a.repairAttempts, artifactName))
+			return aiResponse, nil
+		}
+		events.Message(fmt.Sprintf("  Repair attempt %d/%d for %s still invalid: %v\n", attempt, a.repairAttempts, artifactName, parseErr))
+	}
+	return aiResponse, parseErr
+}
+
+// parseStructuredResponse validates aiResponse against schema before decoding it into target,
+// so that a malformed artifact is reported field-by-field rather than as a single decode error.
+func parseStructuredResponse(aiResponse string, schema *ai.Schema, target interface{}) error {
+	violations, err := ai.ValidateJSON([]byte(aiResponse), schema)
 	if err != nil {
-		return "", fmt.Errorf("failed to parse template '%s': %w", templateName, err)
+		return err
 	}
-	if err := tmpl.Execute(&filledPrompt, data); err != nil {
-		return "", fmt.Errorf("failed to execute template '%s': %w", templateName, err)
+	if len(violations) > 0 {
+		return &ai.SchemaError{Violations: violations}
 	}
-	return filledPrompt.String(), nil
+	return json.Unmarshal([]byte(aiResponse), target)
 }
 
+// describeParseError renders a parseStructuredResponse error for the user-facing message log.
+func describeParseError(err error, aiResponse string) string {
+	var schemaErr *ai.SchemaError
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &schemaErr):
+		var b strings.Builder
+		for i, v := range schemaErr.Violations {
+			if i == maxReportedViolations {
+				fmt.Fprintf(&b, "    ...and %d more schema violations\n", len(schemaErr.Violations)-i)
+				break
+			}
+			fmt.Fprintf(&b, "    - %s\n", v)
+		}
+		return b.String()
+	case errors.As(err, &syntaxErr):
+		start := int(syntaxErr.Offset) - 60
+		if start < 0 {
+			start = 0
+		}
+		end := util.Min(int(syntaxErr.Offset)+60, len(aiResponse))
+		return fmt.Sprintf("    - invalid JSON at offset %d: %v\n      near: ...%s...\n", syntaxErr.Offset, syntaxErr, aiResponse[start:end])
+	case errors.As(err, &typeErr):
+		return fmt.Sprintf("    - $.%s: expected %s, got %s\n", typeErr.Field, typeErr.Type, typeErr.Value)
+	default:
+		return fmt.Sprintf("    - %v\n", err)
+	}
+}
 
 // --- Helper functions for multi-step generation processes ---
 
-// generateNarratorCard (signature and AI call updated)
-func (s *OrchestratorService) generateNarratorCard(ctx context.Context, apiKey string, modelName string, seriesName, logIdentifier string, currentMessages *[]string) (models.CharacterCardV2, string, error) {
-	*currentMessages = append(*currentMessages, "Step: Generating highly detailed Narrator Character Card...\n")
+// generateComprehensiveLorebook generates and saves the Option 1 Comprehensive Lorebook.
+func (s *OrchestratorService) generateComprehensiveLorebook(ctx context.Context, session *aiSession, seriesName, logIdentifier string, events *EventLog) (_ models.Lorebook, _ string, err error) {
+	events.StepStarted(models.StepComprehensiveLorebook, "Step: Generating Comprehensive Lorebook...\n")
+	defer func() { events.StepFinished(models.StepComprehensiveLorebook, err) }()
+
+	var loreBook models.Lorebook
+	if session.fanout != nil {
+		if loreBook, err = s.generateFanoutLorebook(ctx, session, models.StepComprehensiveLorebook, "Comprehensive Lorebook", seriesName, logIdentifier, events); err != nil {
+			return models.Lorebook{}, "", err
+		}
+	} else {
+		promptString, promptErr := comprehensiveLorebookPrompt(session.prompts, seriesName, session.contextCards)
+		if promptErr != nil {
+			events.Message(fmt.Sprintf("  ERROR: Failed to prepare Comprehensive Lorebook prompt: %v\n", promptErr))
+			return models.Lorebook{}, "", promptErr
+		}
+
+		aiResponse, aiErr := session.call(ctx, models.StepComprehensiveLorebook, promptString, lorebookSchema)
+		if aiErr != nil {
+			events.Message(fmt.Sprintf("  ERROR generating Comprehensive Lorebook: %v\n", aiErr))
+			return models.Lorebook{}, "", fmt.Errorf("AI generation failed for Comprehensive Lorebook: %w", aiErr)
+		}
+
+		aiResponse, parseErr := session.parseWithRepair(ctx, models.StepComprehensiveLorebook, "Comprehensive Lorebook", aiResponse, lorebookSchema, &loreBook, logIdentifier, events)
+		if parseErr != nil {
+			log.Printf("Failed to unmarshal Comprehensive Lorebook (Log ID %s): %v. AI Response: %s", logIdentifier, parseErr, aiResponse)
+			events.Message(fmt.Sprintf("  ERROR parsing AI response for Comprehensive Lorebook. Full AI output is in the logs for ID %s. Problems found:\n%s", logIdentifier, describeParseError(parseErr, aiResponse)))
+			return models.Lorebook{}, "", fmt.Errorf("failed to parse AI response for Comprehensive Lorebook: %w", parseErr)
+		}
+	}
+
+	loreBook.Enabled = true
+	if loreBook.Name == "" {
+		loreBook.Name = fmt.Sprintf("Comprehensive Lore for %s", seriesName)
+	}
+	for i := range loreBook.Entries {
+		loreBook.Entries[i].Enabled = true
+	}
+
+	session.outcomes.entries(models.StepComprehensiveLorebook, len(loreBook.Entries))
+	var promptVersions map[string]string
+	loreBook.Extensions, promptVersions = session.stampPromptVersions(loreBook.Extensions, models.StepComprehensiveLorebook)
+
+	jsonData, _ := json.MarshalIndent(loreBook, "", "  ")
+	jsonStr := string(jsonData)
+
+	var worldPath string
+	filePath, saveErr := SaveJSONToFile(baseJSONSaveDir, seriesName, "lorebook_comprehensive", loreBook.Name, logIdentifier, jsonData)
+	if saveErr != nil {
+		events.Message(fmt.Sprintf("  Successfully generated Comprehensive Lorebook JSON, but FAILED to save to server file system. Error: %s\n", saveErr.Error()))
+		log.Printf("Failed to save Comprehensive Lorebook JSON to file (Log ID %s): %v", logIdentifier, saveErr)
+	} else {
+		events.Message(fmt.Sprintf("  Successfully generated Comprehensive Lorebook JSON and saved to: %s\n", filePath))
+		worldPath = session.saveWorldInfo(loreBook, filePath)
+	}
+	events.Artifact(models.StepComprehensiveLorebook, models.ArtifactInfo{Kind: "lorebook_comprehensive", Name: loreBook.Name, FilePath: filePath, Content: jsonStr, PromptVersions: promptVersions, WorldInfoFilePath: worldPath})
+	events.Message("Comprehensive Lorebook generation complete.\n")
+	return loreBook, jsonStr, nil
+}
+
+// generateToolCard generates and saves the Option 3 utility/tool card for a user-supplied purpose.
+func (s *OrchestratorService) generateToolCard(ctx context.Context, session *aiSession, seriesName, toolPurpose, logIdentifier string, events *EventLog) (_ models.CharacterCardV2, _ string, err error) {
+	events.StepStarted(models.StepToolCard, fmt.Sprintf("Step: Generating Utility/Tool Card ('%s')...\n", toolPurpose))
+	defer func() { events.StepFinished(models.StepToolCard, err) }()
+
+	actualPrompt, err := toolCardPrompt(session.prompts, seriesName, toolPurpose, session.contextCards)
+	if err != nil {
+		events.Message(fmt.Sprintf("  ERROR: Failed to prepare tool card prompt: %v\n", err))
+		return models.CharacterCardV2{}, "", err
+	}
+
+	aiResponse, aiErr := session.call(ctx, models.StepToolCard, actualPrompt, characterCardSchema)
+	if aiErr != nil {
+		events.Message(fmt.Sprintf("  ERROR generating Tool Card ('%s'): %v\n", toolPurpose, aiErr))
+		return models.CharacterCardV2{}, "", fmt.Errorf("AI generation failed for Tool Card ('%s'): %w", toolPurpose, aiErr)
+	}
+
+	var toolCard models.CharacterCardV2
+	aiResponse, parseErr := session.parseWithRepair(ctx, models.StepToolCard, fmt.Sprintf("Tool Card ('%s')", toolPurpose), aiResponse, characterCardSchema, &toolCard, logIdentifier, events)
+	if parseErr != nil {
+		log.Printf("Failed to unmarshal Tool Card (Log ID %s): %v. AI Response: %s", logIdentifier, parseErr, aiResponse)
+		events.Message(fmt.Sprintf("  ERROR parsing AI response for Tool Card ('%s'). Full AI output is in the logs for ID %s. Problems found:\n%s", toolPurpose, logIdentifier, describeParseError(parseErr, aiResponse)))
+		return models.CharacterCardV2{}, "", fmt.Errorf("failed to parse AI response for Tool Card ('%s'): %w", toolPurpose, parseErr)
+	}
+
+	if toolCard.Spec == "" {toolCard.Spec = "chara_card_v2"}
+	if toolCard.SpecVersion == "" {toolCard.SpecVersion = "2.0"}
+	if toolCard.Data.Name == "" {
+		toolCard.Data.Name = fmt.Sprintf("%s for %s", toolPurpose, seriesName)
+	}
+	toolCard.Data.CharacterBook = nil
+	var promptVersions map[string]string
+	toolCard.Data.Extensions, promptVersions = session.stampPromptVersions(toolCard.Data.Extensions, models.StepToolCard)
+
+	jsonData, _ := json.MarshalIndent(session.exportCard(toolCard), "", "  ")
+	jsonStr := string(jsonData)
+
+	filePath, saveErr := SaveJSONToFile(baseJSONSaveDir, seriesName, "tool_card", toolCard.Data.Name, logIdentifier, jsonData)
+	var pngPath string
+	if saveErr != nil {
+		events.Message(fmt.Sprintf("  Successfully generated Tool Card ('%s'), but FAILED to save. Error: %s\n", toolPurpose, saveErr.Error()))
+	} else {
+		events.Message(fmt.Sprintf("  Successfully generated and saved Tool Card ('%s') to: %s\n", toolPurpose, filePath))
+		pngPath = session.saveCardPNG(toolCard, filePath)
+	}
+	events.Artifact(models.StepToolCard, models.ArtifactInfo{Kind: "tool_card", Name: toolCard.Data.Name, FilePath: filePath, Content: jsonStr, PromptVersions: promptVersions, PNGFilePath: pngPath, PNGURL: cardURL(logIdentifier, pngPath)})
+	return toolCard, jsonStr, nil
+}
+
+// generateNarratorCard generates and saves the Narrator Framework card.
+func (s *OrchestratorService) generateNarratorCard(ctx context.Context, session *aiSession, seriesName, logIdentifier string, events *EventLog) (_ models.CharacterCardV2, _ string, err error) {
+	events.StepStarted(models.StepNarratorCard, "Step: Generating highly detailed Narrator Character Card...\n")
+	defer func() { events.StepFinished(models.StepNarratorCard, err) }()
 	narratorName := fmt.Sprintf("The Narrator of %s", seriesName)
 
-	promptStr := fmt.Sprintf(prompts.NarratorCardPrompt,
-		seriesName, seriesName, narratorName, seriesName, seriesName, seriesName, seriesName, seriesName, seriesName, seriesName, 
-		seriesName, seriesName, seriesName, seriesName, seriesName, seriesName, seriesName, seriesName, seriesName, seriesName, 
-		seriesName, seriesName, seriesName)
+	promptStr, err := narratorCardPrompt(session.prompts, seriesName, narratorName, session.contextCards)
+	if err != nil {
+		events.Message(fmt.Sprintf("  ERROR: Failed to prepare Narrator Card prompt: %v\n", err))
+		return models.CharacterCardV2{}, "", err
+	}
 
-	aiResponse, err := ai.CallGeminiAPI(ctx, apiKey, modelName, promptStr) // Updated call
+	aiResponse, err := session.call(ctx, models.StepNarratorCard, promptStr, characterCardSchema)
 	if err != nil {
-		*currentMessages = append(*currentMessages, fmt.Sprintf("  ERROR generating Narrator Card: %v\n", err))
+		events.Message(fmt.Sprintf("  ERROR generating Narrator Card: %v\n", err))
 		return models.CharacterCardV2{}, "", fmt.Errorf("AI generation failed for Narrator Card: %w", err)
 	}
 
 	var card models.CharacterCardV2
-	if err := json.Unmarshal([]byte(aiResponse), &card); err != nil {
-		log.Printf("Failed to unmarshal Narrator Card (Log ID %s): %v. AI Response: %s", logIdentifier, err, aiResponse)
-		*currentMessages = append(*currentMessages, fmt.Sprintf("  ERROR parsing Narrator Card. Raw AI output (check logs for ID %s for details): %s\n", logIdentifier, aiResponse[:util.Min(600, len(aiResponse))]))
-		return models.CharacterCardV2{}, "", fmt.Errorf("failed to parse AI response for Narrator Card: %w", err)
+	aiResponse, parseErr := session.parseWithRepair(ctx, models.StepNarratorCard, "Narrator Card", aiResponse, characterCardSchema, &card, logIdentifier, events)
+	if parseErr != nil {
+		log.Printf("Failed to unmarshal Narrator Card (Log ID %s): %v. AI Response: %s", logIdentifier, parseErr, aiResponse)
+		events.Message(fmt.Sprintf("  ERROR parsing Narrator Card. Full AI output is in the logs for ID %s. Problems found:\n%s", logIdentifier, describeParseError(parseErr, aiResponse)))
+		return models.CharacterCardV2{}, "", fmt.Errorf("failed to parse AI response for Narrator Card: %w", parseErr)
 	}
 
 	if card.Spec == "" {card.Spec = "chara_card_v2"}
 	if card.SpecVersion == "" {card.SpecVersion = "2.0"}
 	if card.Data.Name == "" {card.Data.Name = narratorName}
 	card.Data.CharacterBook = nil 
+	var promptVersions map[string]string
+	card.Data.Extensions, promptVersions = session.stampPromptVersions(card.Data.Extensions, models.StepNarratorCard)
 
-	jsonData, _ := json.MarshalIndent(card, "", "  ")
+	jsonData, _ := json.MarshalIndent(session.exportCard(card), "", "  ")
 	jsonStr := string(jsonData)
 
 	filePath, saveErr := SaveJSONToFile(baseJSONSaveDir, seriesName, "narrator_card", card.Data.Name, logIdentifier, jsonData)
+	var pngPath string
 	if saveErr != nil {
-		*currentMessages = append(*currentMessages, fmt.Sprintf("  Successfully generated Narrator Card JSON, but FAILED to save. Error: %s\n", saveErr.Error()))
+		events.Message(fmt.Sprintf("  Successfully generated Narrator Card JSON, but FAILED to save. Error: %s\n", saveErr.Error()))
 	} else {
-		*currentMessages = append(*currentMessages, fmt.Sprintf("  Successfully generated and saved Narrator Card to: %s\n", filePath))
+		events.Message(fmt.Sprintf("  Successfully generated and saved Narrator Card to: %s\n", filePath))
+		pngPath = session.saveCardPNG(card, filePath)
 	}
-	*currentMessages = append(*currentMessages, "Narrator Card generation complete.\n\n")
+	events.Artifact(models.StepNarratorCard, models.ArtifactInfo{Kind: "narrator_card", Name: card.Data.Name, FilePath: filePath, Content: jsonStr, PromptVersions: promptVersions, PNGFilePath: pngPath, PNGURL: cardURL(logIdentifier, pngPath)})
+	events.Message("Narrator Card generation complete.\n\n")
 	return card, jsonStr, nil
 }
 
-// generateMasterLorebook (signature and AI call updated)
-func (s *OrchestratorService) generateMasterLorebook(ctx context.Context, apiKey string, modelName string, seriesName, logIdentifier string, currentMessages *[]string) (models.Lorebook, string, error) {
-	*currentMessages = append(*currentMessages, "Step: Generating Master Lorebook (Refined)...\n")
+// generateMasterLorebook generates and saves the refined Master Lorebook.
+func (s *OrchestratorService) generateMasterLorebook(ctx context.Context, session *aiSession, seriesName, logIdentifier string, events *EventLog) (_ models.Lorebook, _ string, err error) {
+	events.StepStarted(models.StepMasterLorebook, "Step: Generating Master Lorebook (Refined)...\n")
+	defer func() { events.StepFinished(models.StepMasterLorebook, err) }()
 	
-	promptStr := fmt.Sprintf(prompts.MasterLorebookPrompt,
-		seriesName, seriesName, seriesName, seriesName, seriesName, seriesName, seriesName, seriesName, seriesName, seriesName, seriesName)
+	var lorebook models.Lorebook
+	if session.fanout != nil {
+		if lorebook, err = s.generateFanoutLorebook(ctx, session, models.StepMasterLorebook, "Master Lorebook", seriesName, logIdentifier, events); err != nil {
+			return models.Lorebook{}, "", err
+		}
+	} else {
+		promptStr, err := masterLorebookPrompt(session.prompts, seriesName, session.contextCards)
+		if err != nil {
+			events.Message(fmt.Sprintf("  ERROR: Failed to prepare Master Lorebook prompt: %v\n", err))
+			return models.Lorebook{}, "", err
+		}
 
-	aiResponse, err := ai.CallGeminiAPI(ctx, apiKey, modelName, promptStr) // Updated call
-	if err != nil {
-		*currentMessages = append(*currentMessages, fmt.Sprintf("  ERROR generating Master Lorebook: %v\n", err))
-		return models.Lorebook{}, "", fmt.Errorf("AI generation failed for Master Lorebook: %w", err)
-	}
+		aiResponse, err := session.call(ctx, models.StepMasterLorebook, promptStr, lorebookSchema)
+		if err != nil {
+			events.Message(fmt.Sprintf("  ERROR generating Master Lorebook: %v\n", err))
+			return models.Lorebook{}, "", fmt.Errorf("AI generation failed for Master Lorebook: %w", err)
+		}
 
-	var lorebook models.Lorebook
-	if err := json.Unmarshal([]byte(aiResponse), &lorebook); err != nil {
-		log.Printf("Failed to unmarshal Master Lorebook (Log ID %s): %v. AI Response: %s", logIdentifier, err, aiResponse)
-		*currentMessages = append(*currentMessages, fmt.Sprintf("  ERROR parsing Master Lorebook. Raw AI output (check logs for ID %s for details): %s\n", logIdentifier, aiResponse[:util.Min(600, len(aiResponse))]))
-		return models.Lorebook{}, "", fmt.Errorf("failed to parse AI response for Master Lorebook: %w", err)
+		aiResponse, parseErr := session.parseWithRepair(ctx, models.StepMasterLorebook, "Master Lorebook", aiResponse, lorebookSchema, &lorebook, logIdentifier, events)
+		if parseErr != nil {
+			log.Printf("Failed to unmarshal Master Lorebook (Log ID %s): %v. AI Response: %s", logIdentifier, parseErr, aiResponse)
+			events.Message(fmt.Sprintf("  ERROR parsing Master Lorebook. Full AI output is in the logs for ID %s. Problems found:\n%s", logIdentifier, describeParseError(parseErr, aiResponse)))
+			return models.Lorebook{}, "", fmt.Errorf("failed to parse AI response for Master Lorebook: %w", parseErr)
+		}
 	}
 
 	lorebook.Enabled = true
@@ -317,46 +841,40 @@ func (s *OrchestratorService) generateMasterLorebook(ctx context.Context, apiKey
 	for i := range lorebook.Entries {
 		lorebook.Entries[i].Enabled = true
 	}
+	session.outcomes.entries(models.StepMasterLorebook, len(lorebook.Entries))
+	var promptVersions map[string]string
+	lorebook.Extensions, promptVersions = session.stampPromptVersions(lorebook.Extensions, models.StepMasterLorebook)
 
 	jsonData, _ := json.MarshalIndent(lorebook, "", "  ")
 	jsonStr := string(jsonData)
 
+	var worldPath string
 	filePath, saveErr := SaveJSONToFile(baseJSONSaveDir, seriesName, "master_lorebook", lorebook.Name, logIdentifier, jsonData)
 	if saveErr != nil {
-		*currentMessages = append(*currentMessages, fmt.Sprintf("  Successfully generated Master Lorebook JSON, but FAILED to save. Error: %s\n", saveErr.Error()))
+		events.Message(fmt.Sprintf("  Successfully generated Master Lorebook JSON, but FAILED to save. Error: %s\n", saveErr.Error()))
 	} else {
-		*currentMessages = append(*currentMessages, fmt.Sprintf("  Successfully generated and saved Master Lorebook to: %s\n", filePath))
+		events.Message(fmt.Sprintf("  Successfully generated and saved Master Lorebook to: %s\n", filePath))
+		worldPath = session.saveWorldInfo(lorebook, filePath)
 	}
-	*currentMessages = append(*currentMessages, "Master Lorebook generation complete.\n\n")
+	events.Artifact(models.StepMasterLorebook, models.ArtifactInfo{Kind: "master_lorebook", Name: lorebook.Name, FilePath: filePath, Content: jsonStr, PromptVersions: promptVersions, WorldInfoFilePath: worldPath})
+	events.Message("Master Lorebook generation complete.\n\n")
 	return lorebook, jsonStr, nil
 }
 
-// generateContextualSummary (signature and AI call updated)
-func (s *OrchestratorService) generateContextualSummary(ctx context.Context, apiKey string, modelName string, seriesName string, narratorData models.CardData, lorebookData models.Lorebook, logIdentifier string, currentMessages *[]string) (string, error) {
-	*currentMessages = append(*currentMessages, "Step: Generating Contextual Summary for AI Tool Suggestion...\n")
-
-	narratorJSON, _ := json.MarshalIndent(narratorData, "", "  ")
-	lorebookJSON, _ := json.MarshalIndent(lorebookData, "", "  ")
+// generateContextualSummary condenses the narrator card and lorebook into a plain-text world summary.
+func (s *OrchestratorService) generateContextualSummary(ctx context.Context, session *aiSession, seriesName string, narratorData models.CardData, lorebookData models.Lorebook, logIdentifier string, events *EventLog) (_ string, err error) {
+	events.StepStarted(models.StepContextSummary, "Step: Generating Contextual Summary for AI Tool Suggestion...\n")
+	defer func() { events.StepFinished(models.StepContextSummary, err) }()
 
-	promptData := struct {
-		SeriesName   string
-		NarratorJSON string
-		LorebookJSON string
-	}{
-		SeriesName:   seriesName,
-		NarratorJSON: string(narratorJSON),
-		LorebookJSON: string(lorebookJSON),
-	}
-	
-	actualPrompt, err := executeTemplate("contextSummaryPrompt", prompts.ContextualSummaryPrompt, promptData)
+	actualPrompt, err := contextSummaryPrompt(session.prompts, seriesName, narratorData, lorebookData)
 	if err != nil {
-		*currentMessages = append(*currentMessages, fmt.Sprintf("  ERROR preparing prompt for Contextual Summary: %v\n", err))
+		events.Message(fmt.Sprintf("  ERROR preparing prompt for Contextual Summary: %v\n", err))
 		return "", err // Return error as this step is crucial for next
 	}
 
-	aiResponse, err := ai.CallGeminiAPI(ctx, apiKey, modelName, actualPrompt) // Updated call
+	aiResponse, err := session.call(ctx, models.StepContextSummary, actualPrompt, nil)
 	if err != nil {
-		*currentMessages = append(*currentMessages, fmt.Sprintf("  ERROR generating Contextual Summary: %v\n", err))
+		events.Message(fmt.Sprintf("  ERROR generating Contextual Summary: %v\n", err))
 		return "", err // Return error
 	}
 	
@@ -364,117 +882,91 @@ func (s *OrchestratorService) generateContextualSummary(ctx context.Context, api
 	// No unmarshalling, just return the string.
 	// Basic validation: ensure it's not empty.
 	if strings.TrimSpace(aiResponse) == "" {
-		*currentMessages = append(*currentMessages, "  WARNING: AI returned an empty Contextual Summary.\n")
+		events.Message("  WARNING: AI returned an empty Contextual Summary.\n")
 		return "", fmt.Errorf("AI returned an empty contextual summary")
 	}
 
-	*currentMessages = append(*currentMessages, "Contextual Summary generated.\n\n")
+	events.Message("Contextual Summary generated.\n\n")
 	return aiResponse, nil
 }
 
-// suggestUtilityTools (signature and AI call updated)
-func (s *OrchestratorService) suggestUtilityTools(ctx context.Context, apiKey string, modelName string, seriesName string, worldContextSummary string, logIdentifier string, currentMessages *[]string) ([]models.AISuggestedTool, error) {
-	*currentMessages = append(*currentMessages, "Step: AI Suggesting 2 Tailored Utility Tools...\n")
+// suggestUtilityTools asks the AI to propose two utility tools that fit the world summary.
+func (s *OrchestratorService) suggestUtilityTools(ctx context.Context, session *aiSession, seriesName string, worldContextSummary string, logIdentifier string, events *EventLog) (_ []models.AISuggestedTool, err error) {
+	events.StepStarted(models.StepToolSuggestion, "Step: AI Suggesting 2 Tailored Utility Tools...\n")
+	defer func() { events.StepFinished(models.StepToolSuggestion, err) }()
 
 	if strings.TrimSpace(worldContextSummary) == "" {
-		*currentMessages = append(*currentMessages, "  Skipping AI tool suggestion: World Context Summary is empty.\n")
+		events.Message("  Skipping AI tool suggestion: World Context Summary is empty.\n")
 		return nil, fmt.Errorf("world context summary is empty, cannot suggest tools")
 	}
 	
-	promptData := struct {
-		SeriesName          string
-		WorldContextSummary string
-	}{
-		SeriesName:          seriesName,
-		WorldContextSummary: worldContextSummary,
-	}
-
-	actualPrompt, err := executeTemplate("toolSuggestionPrompt", prompts.ToolSuggestionPrompt, promptData)
+	actualPrompt, err := toolSuggestionPrompt(session.prompts, seriesName, worldContextSummary)
 	if err != nil {
-		*currentMessages = append(*currentMessages, fmt.Sprintf("  ERROR preparing prompt for Tool Suggestion: %v\n", err))
+		events.Message(fmt.Sprintf("  ERROR preparing prompt for Tool Suggestion: %v\n", err))
 		return nil, err
 	}
 
-	aiResponse, err := ai.CallGeminiAPI(ctx, apiKey, modelName, actualPrompt) // Updated call
+	aiResponse, err := session.call(ctx, models.StepToolSuggestion, actualPrompt, toolSuggestionsSchema)
 	if err != nil {
-		*currentMessages = append(*currentMessages, fmt.Sprintf("  ERROR from AI during Tool Suggestion: %v\n", err))
+		events.Message(fmt.Sprintf("  ERROR from AI during Tool Suggestion: %v\n", err))
 		return nil, err
 	}
 
-	var suggestions struct {
-		Tools []models.AISuggestedTool `json:"suggested_tools"`
-	}
-	if err := json.Unmarshal([]byte(aiResponse), &suggestions); err != nil {
-		log.Printf("Failed to unmarshal AI Tool Suggestions (Log ID %s): %v. AI Response: %s", logIdentifier, err, aiResponse)
-		*currentMessages = append(*currentMessages, fmt.Sprintf("  ERROR parsing AI Tool Suggestions. Raw AI output (check logs for ID %s for details): %s\n", logIdentifier, aiResponse[:util.Min(600, len(aiResponse))]))
-		return nil, fmt.Errorf("failed to parse AI response for tool suggestions: %w", err)
+	var suggestions models.AIToolSuggestions
+	aiResponse, parseErr := session.parseWithRepair(ctx, models.StepToolSuggestion, "Tool Suggestions", aiResponse, toolSuggestionsSchema, &suggestions, logIdentifier, events)
+	if parseErr != nil {
+		log.Printf("Failed to unmarshal AI Tool Suggestions (Log ID %s): %v. AI Response: %s", logIdentifier, parseErr, aiResponse)
+		events.Message(fmt.Sprintf("  ERROR parsing AI Tool Suggestions. Full AI output is in the logs for ID %s. Problems found:\n%s", logIdentifier, describeParseError(parseErr, aiResponse)))
+		return nil, fmt.Errorf("failed to parse AI response for tool suggestions: %w", parseErr)
 	}
 
+	session.outcomes.entries(models.StepToolSuggestion, len(suggestions.Tools))
 	if len(suggestions.Tools) != 2 {
-		*currentMessages = append(*currentMessages, fmt.Sprintf("  WARNING: AI suggested %d tools instead of 2. Proceeding with what was given, but this might impact utility card generation.\n", len(suggestions.Tools)))
+		events.Message(fmt.Sprintf("  WARNING: AI suggested %d tools instead of 2. Proceeding with what was given, but this might impact utility card generation.\n", len(suggestions.Tools)))
 		// Depending on strictness, could return an error here. For now, allow it but log.
 	}
 	
 	if len(suggestions.Tools) > 0 {
 		for i, tool := range suggestions.Tools {
-			*currentMessages = append(*currentMessages, fmt.Sprintf("  AI Suggested Tool %d: Type='%s', Name='%s', Justification='%s'\n", i+1, tool.ToolType, tool.ToolName, tool.ToolJustification))
+			events.Message(fmt.Sprintf("  AI Suggested Tool %d: Type='%s', Name='%s', Justification='%s'\n", i+1, tool.ToolType, tool.ToolName, tool.ToolJustification))
 		}
 	} else {
-		*currentMessages = append(*currentMessages, "  AI did not suggest any tools.\n")
+		events.Message("  AI did not suggest any tools.\n")
 	}
-	*currentMessages = append(*currentMessages, "AI Tool Suggestion phase complete.\n\n")
+	events.Message("AI Tool Suggestion phase complete.\n\n")
 	return suggestions.Tools, nil
 }
 
-// generateTailoredUtilityCard (signature and AI call updated)
+// generateTailoredUtilityCard generates and saves one AI-suggested utility card.
 func (s *OrchestratorService) generateTailoredUtilityCard(
-	ctx context.Context, apiKey string, modelName string, seriesName string,
+	ctx context.Context, session *aiSession, seriesName string,
 	toolSuggestion models.AISuggestedTool,
 	narratorData models.CardData, // Used for context
 	lorebookData models.Lorebook, // Used for context
 	worldContextSummary string, // Used for context
-	logIdentifier string, toolIndex int, currentMessages *[]string,
-) (string, error) {
-	*currentMessages = append(*currentMessages, fmt.Sprintf("Step: Generating Tailored Utility Card %d: '%s' (Type: '%s')...\n", toolIndex+1, toolSuggestion.ToolName, toolSuggestion.ToolType))
-
-	narratorJSON, _ := json.MarshalIndent(narratorData, "", "  ")
-	lorebookJSON, _ := json.MarshalIndent(lorebookData, "", "  ")
-
-	promptData := struct {
-		SeriesName          string
-		ToolName            string
-		ToolType            string
-		ToolJustification   string
-		NarratorCardJSON    string
-		MasterLorebookJSON  string
-		WorldContextSummary string
-	}{
-		SeriesName:          seriesName,
-		ToolName:            toolSuggestion.ToolName,
-		ToolType:            toolSuggestion.ToolType,
-		ToolJustification:   toolSuggestion.ToolJustification,
-		NarratorCardJSON:    string(narratorJSON),
-		MasterLorebookJSON:  string(lorebookJSON),
-		WorldContextSummary: worldContextSummary,
-	}
-
-	actualPrompt, err := executeTemplate("tailoredToolCardPrompt", prompts.ToolCardPromptTemplate, promptData)
+	logIdentifier string, toolIndex int, events *EventLog,
+) (_ string, err error) {
+	events.StepStarted(models.StepUtilityCard, fmt.Sprintf("Step: Generating Tailored Utility Card %d: '%s' (Type: '%s')...\n", toolIndex+1, toolSuggestion.ToolName, toolSuggestion.ToolType))
+	defer func() { events.StepFinished(models.StepUtilityCard, err) }()
+
+	actualPrompt, err := utilityCardPrompt(session.prompts, seriesName, toolSuggestion, session.contextCards)
 	if err != nil {
-		*currentMessages = append(*currentMessages, fmt.Sprintf("  ERROR preparing prompt for Tailored Utility Card '%s': %v\n", toolSuggestion.ToolName, err))
+		events.Message(fmt.Sprintf("  ERROR preparing prompt for Tailored Utility Card '%s': %v\n", toolSuggestion.ToolName, err))
 		return "", err
 	}
 	
-	aiResponse, err := ai.CallGeminiAPI(ctx, apiKey, modelName, actualPrompt) // Updated call
+	aiResponse, err := session.call(ctx, models.StepUtilityCard, actualPrompt, characterCardSchema)
 	if err != nil {
-		*currentMessages = append(*currentMessages, fmt.Sprintf("  ERROR from AI generating Tailored Utility Card '%s': %v\n", toolSuggestion.ToolName, err))
+		events.Message(fmt.Sprintf("  ERROR from AI generating Tailored Utility Card '%s': %v\n", toolSuggestion.ToolName, err))
 		return "", err
 	}
 
 	var card models.CharacterCardV2
-	if err := json.Unmarshal([]byte(aiResponse), &card); err != nil {
-		log.Printf("Failed to unmarshal Tailored Utility Card '%s' (Log ID %s): %v. AI Response: %s", toolSuggestion.ToolName, logIdentifier, err, aiResponse)
-		*currentMessages = append(*currentMessages, fmt.Sprintf("  ERROR parsing Tailored Utility Card '%s'. Raw AI output (check logs for ID %s for details): %s\n", toolSuggestion.ToolName, logIdentifier, aiResponse[:util.Min(600, len(aiResponse))]))
-		return "", fmt.Errorf("failed to parse AI response for tailored utility card '%s': %w", toolSuggestion.ToolName, err)
+	aiResponse, parseErr := session.parseWithRepair(ctx, models.StepUtilityCard, fmt.Sprintf("Tailored Utility Card '%s'", toolSuggestion.ToolName), aiResponse, characterCardSchema, &card, logIdentifier, events)
+	if parseErr != nil {
+		log.Printf("Failed to unmarshal Tailored Utility Card '%s' (Log ID %s): %v. AI Response: %s", toolSuggestion.ToolName, logIdentifier, parseErr, aiResponse)
+		events.Message(fmt.Sprintf("  ERROR parsing Tailored Utility Card '%s'. Full AI output is in the logs for ID %s. Problems found:\n%s", toolSuggestion.ToolName, logIdentifier, describeParseError(parseErr, aiResponse)))
+		return "", fmt.Errorf("failed to parse AI response for tailored utility card '%s': %w", toolSuggestion.ToolName, parseErr)
 	}
 
 	// Validate/Defaults
@@ -482,17 +974,22 @@ func (s *OrchestratorService) generateTailoredUtilityCard(
 	if card.SpecVersion == "" {card.SpecVersion = "2.0"}
 	if card.Data.Name == "" { card.Data.Name = toolSuggestion.ToolName } // Default to suggested name
 	card.Data.CharacterBook = nil // No embedded lorebook for utility cards
+	var promptVersions map[string]string
+	card.Data.Extensions, promptVersions = session.stampPromptVersions(card.Data.Extensions, models.StepUtilityCard)
 
-	jsonData, _ := json.MarshalIndent(card, "", "  ")
+	jsonData, _ := json.MarshalIndent(session.exportCard(card), "", "  ")
 	jsonStr := string(jsonData)
 
 	fileName := fmt.Sprintf("utility_card_ai_suggested_%d", toolIndex+1)
 	filePath, saveErr := SaveJSONToFile(baseJSONSaveDir, seriesName, fileName, card.Data.Name, logIdentifier, jsonData)
+	var pngPath string
 	if saveErr != nil {
-		*currentMessages = append(*currentMessages, fmt.Sprintf("  Successfully generated Tailored Utility Card '%s' JSON, but FAILED to save. Error: %s\n", toolSuggestion.ToolName, saveErr.Error()))
+		events.Message(fmt.Sprintf("  Successfully generated Tailored Utility Card '%s' JSON, but FAILED to save. Error: %s\n", toolSuggestion.ToolName, saveErr.Error()))
 	} else {
-		*currentMessages = append(*currentMessages, fmt.Sprintf("  Successfully generated and saved Tailored Utility Card '%s' to: %s\n", toolSuggestion.ToolName, filePath))
+		events.Message(fmt.Sprintf("  Successfully generated and saved Tailored Utility Card '%s' to: %s\n", toolSuggestion.ToolName, filePath))
+		pngPath = session.saveCardPNG(card, filePath)
 	}
-	*currentMessages = append(*currentMessages, fmt.Sprintf("Tailored Utility Card '%s' generation complete.\n\n", toolSuggestion.ToolName))
+	events.Artifact(models.StepUtilityCard, models.ArtifactInfo{Kind: fileName, Name: card.Data.Name, FilePath: filePath, Content: jsonStr, PromptVersions: promptVersions, PNGFilePath: pngPath, PNGURL: cardURL(logIdentifier, pngPath)})
+	events.Message(fmt.Sprintf("Tailored Utility Card '%s' generation complete.\n\n", toolSuggestion.ToolName))
 	return jsonStr, nil
 }
@@ -0,0 +1,166 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"workspace/FictionGeminiRewritten/internal/models"
+)
+
+// promptInputs stands in for the output of earlier steps when prompts are rendered without
+// running the pipeline.
+type promptInputs struct {
+	narrator models.CardData
+	lorebook models.Lorebook
+	summary  string
+	tools    []models.AISuggestedTool
+	outline  string // Fan-out outline, never checkpointed
+
+	placeholders bool // Whether any of the above is a placeholder rather than real output
+}
+
+// placeholderInputs returns bracketed placeholders naming the step each input comes from.
+func placeholderInputs() promptInputs {
+	inputs := promptInputs{
+		narrator: models.CardData{
+			Name:        "[Narrator name from step narrator_card]",
+			Description: "[Narrator description from step narrator_card]",
+			Personality: "[Narrator personality from step narrator_card]",
+		},
+		lorebook: models.Lorebook{
+			Name:        "[Lorebook name from step master_lorebook]",
+			Description: "[Lorebook description from step master_lorebook]",
+		},
+		summary:      "[World summary from step context_summary]",
+		outline:      "  [Outline of every category and entity, from the outline call]\n",
+		placeholders: true,
+	}
+	for i := 1; i <= 3; i++ {
+		inputs.lorebook.Entries = append(inputs.lorebook.Entries, models.LorebookEntry{
+			Comment: fmt.Sprintf("[Comment of entry %d from step master_lorebook]", i),
+			Content: fmt.Sprintf("[Content of entry %d from step master_lorebook]", i),
+		})
+	}
+	for i := 1; i <= 2; i++ {
+		inputs.tools = append(inputs.tools, models.AISuggestedTool{
+			ToolType:          fmt.Sprintf("[Type of tool %d from step tool_suggestion]", i),
+			ToolName:          fmt.Sprintf("[Name of tool %d from step tool_suggestion]", i),
+			ToolJustification: fmt.Sprintf("[Justification of tool %d from step tool_suggestion]", i),
+		})
+	}
+	return inputs
+}
+
+// checkpointInputs replaces placeholders with the output of steps that succeeded in a session.
+func checkpointInputs(cp *checkpoint) promptInputs {
+	inputs := placeholderInputs()
+
+	found := 0
+	load := func(key string, target interface{}) {
+		saved, ok := cp.succeeded(key)
+		if !ok {
+			return
+		}
+		if err := json.Unmarshal(saved, target); err != nil {
+			log.Printf("Ignoring unreadable checkpoint output for step %s in %s", key, cp.path)
+			return
+		}
+		found++
+	}
+	// Outputs are decoded into fresh values, so no placeholder field survives in them.
+	var narrator models.CharacterCardV2
+	var lorebook *models.Lorebook
+	var summary *string
+	var tools []models.AISuggestedTool
+	load(models.StepNarratorCard, &narrator)
+	load(models.StepMasterLorebook, &lorebook)
+	load(models.StepContextSummary, &summary)
+	load(models.StepToolSuggestion, &tools)
+	if narrator.Data.Name != "" {
+		inputs.narrator = narrator.Data
+	}
+	if lorebook != nil {
+		inputs.lorebook = *lorebook
+	}
+	if summary != nil {
+		inputs.summary = *summary
+	}
+	if len(tools) == 2 {
+		inputs.tools = tools
+	}
+	inputs.placeholders = found < 4
+	return inputs
+}
+
+// Preview renders every prompt the request's option would send, without calling a model.
+// Earlier steps' output, and the template versions, come from the checkpoint of the session
+// being resumed, if any; otherwise experiment requests are shown with the variants they would
+// be assigned.
+// Template problems, including overrides rejected on reload, are reported rather than
+// returned as an error.
+func (s *OrchestratorService) Preview(payload models.RequestPayload) (models.PreviewResponse, error) {
+	preview := models.PreviewResponse{
+		Series:       payload.Series,
+		Option:       payload.Option,
+		OptionChosen: describeOption(payload),
+		Prompts:      []models.PromptPreview{},
+	}
+	if err := s.templates.ReloadError(); err != nil {
+		preview.TemplateErrors = splitErrors(err)
+	}
+
+	inputs := placeholderInputs()
+	selection, assignment := s.assignPrompts(payload)
+	if payload.ResumeLogIdentifier != "" {
+		cp, err := loadCheckpoint(payload.ResumeLogIdentifier)
+		if err != nil {
+			return preview, err
+		}
+		inputs = checkpointInputs(cp)
+		if cp.Prompts != nil {
+			selection, assignment, _ = s.resumedPrompts(*cp.Prompts)
+		}
+	}
+	preview.PromptVersions = &assignment
+	plan, err := planPrompts(selection, payload, inputs)
+	if err != nil {
+		return preview, err
+	}
+
+	for _, call := range plan {
+		spec, _ := s.routing.routeFor(payload, call.step)
+		prompt := models.PromptPreview{
+			Step:   call.step,
+			Label:  call.label,
+			Model:  spec,
+			Calls:  call.calls,
+			Prompt: call.prompt,
+		}
+		if call.err != nil {
+			prompt.TemplateError = call.err.Error()
+		}
+		preview.Prompts = append(preview.Prompts, prompt)
+		if (call.fanout && !call.outlines) || (inputs.placeholders && isDownstreamStep(call.step)) {
+			preview.Placeholders = true
+		}
+	}
+	return preview, nil
+}
+
+// isDownstreamStep reports whether a step's prompt embeds the output of earlier steps.
+func isDownstreamStep(step string) bool {
+	return step == models.StepContextSummary || step == models.StepToolSuggestion || step == models.StepUtilityCard
+}
+
+// splitErrors lists the errors joined in err.
+func splitErrors(err error) []string {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		var out []string
+		for _, e := range joined.Unwrap() {
+			out = append(out, e.Error())
+		}
+		return out
+	}
+	return []string{err.Error()}
+}
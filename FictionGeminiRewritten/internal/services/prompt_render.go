@@ -0,0 +1,95 @@
+package services
+
+import (
+	"strings"
+
+	"workspace/FictionGeminiRewritten/internal/models"
+	"workspace/FictionGeminiRewritten/internal/prompts"
+)
+
+// The prompt of every pipeline step is rendered here, so generation, estimates and previews
+// send, count and show exactly the same text. contextCards is the request's context cards as
+// rendered by contextCardsText.
+
+// snippetLength caps the excerpts of earlier artifacts quoted in prompts, in characters.
+const snippetLength = 300
+
+func comprehensiveLorebookPrompt(templates prompts.Selection, seriesName, contextCards string) (string, error) {
+	return templates.Render(prompts.ComprehensiveLorebook, prompts.SeriesData{SeriesName: seriesName, ContextCards: contextCards})
+}
+
+func toolCardPrompt(templates prompts.Selection, seriesName, toolPurpose, contextCards string) (string, error) {
+	return templates.Render(prompts.ToolCard, prompts.ToolCardData{SeriesName: seriesName, ToolPurpose: toolPurpose, ContextCards: contextCards})
+}
+
+func narratorCardPrompt(templates prompts.Selection, seriesName, narratorName, contextCards string) (string, error) {
+	return templates.Render(prompts.NarratorCard, prompts.NarratorCardData{SeriesName: seriesName, NarratorName: narratorName, ContextCards: contextCards})
+}
+
+func masterLorebookPrompt(templates prompts.Selection, seriesName, contextCards string) (string, error) {
+	return templates.Render(prompts.MasterLorebook, prompts.SeriesData{SeriesName: seriesName, ContextCards: contextCards})
+}
+
+// contextSummaryPrompt quotes the narrator card and the first three lorebook entries.
+func contextSummaryPrompt(templates prompts.Selection, seriesName string, narratorData models.CardData, lorebookData models.Lorebook) (string, error) {
+	data := prompts.ContextSummaryData{
+		SeriesName:                 seriesName,
+		NarratorName:               narratorData.Name,
+		NarratorDescSnippet:        snippet(narratorData.Description),
+		NarratorPersonalitySnippet: snippet(narratorData.Personality),
+		LorebookName:               lorebookData.Name,
+		LorebookDescSnippet:        snippet(lorebookData.Description),
+	}
+	entries := []struct{ comment, content *string }{
+		{&data.LoreEntry1Comment, &data.LoreEntry1ContentSnippet},
+		{&data.LoreEntry2Comment, &data.LoreEntry2ContentSnippet},
+		{&data.LoreEntry3Comment, &data.LoreEntry3ContentSnippet},
+	}
+	for i, entry := range entries {
+		if i < len(lorebookData.Entries) {
+			*entry.comment = lorebookData.Entries[i].Comment
+			*entry.content = snippet(lorebookData.Entries[i].Content)
+		}
+	}
+	return templates.Render(prompts.ContextSummary, data)
+}
+
+func toolSuggestionPrompt(templates prompts.Selection, seriesName, worldContextSummary string) (string, error) {
+	return templates.Render(prompts.ToolSuggestion, prompts.ToolSuggestionData{SeriesName: seriesName, WorldContextSummary: worldContextSummary})
+}
+
+// utilityCardPrompt renders the tool card prompt for a suggested tool, whose type is its purpose.
+func utilityCardPrompt(templates prompts.Selection, seriesName string, toolSuggestion models.AISuggestedTool, contextCards string) (string, error) {
+	return templates.Render(prompts.ToolCard, prompts.ToolCardData{SeriesName: seriesName, ToolPurpose: toolSuggestion.ToolType, ContextCards: contextCards})
+}
+
+func jsonRepairPrompt(templates prompts.Selection, artifactName, parseError, brokenJSON string) (string, error) {
+	return templates.Render(prompts.JSONRepair, prompts.JSONRepairData{ArtifactName: artifactName, ParseError: parseError, BrokenJSON: brokenJSON})
+}
+
+func continuationPrompt(templates prompts.Selection, originalPrompt, partialOutput string) (string, error) {
+	return templates.Render(prompts.Continuation, prompts.ContinuationData{OriginalPrompt: originalPrompt, PartialOutput: partialOutput})
+}
+
+func lorebookOutlinePrompt(templates prompts.Selection, seriesName, contextCards string) (string, error) {
+	return templates.Render(prompts.LorebookOutline, prompts.SeriesData{SeriesName: seriesName, ContextCards: contextCards})
+}
+
+func lorebookBatchPrompt(templates prompts.Selection, seriesName string, batch lorebookBatch, outlineText, contextCards string) (string, error) {
+	return templates.Render(prompts.LorebookBatch, prompts.LorebookBatchData{
+		SeriesName:   seriesName,
+		Category:     batch.category,
+		Entities:     batch.entities,
+		Outline:      outlineText,
+		ContextCards: contextCards,
+	})
+}
+
+// snippet trims text to snippetLength characters, marking the cut with "...".
+func snippet(text string) string {
+	text = strings.TrimSpace(text)
+	if runes := []rune(text); len(runes) > snippetLength {
+		return string(runes[:snippetLength]) + "..."
+	}
+	return text
+}
@@ -0,0 +1,146 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"workspace/FictionGeminiRewritten/internal/ai"
+	"workspace/FictionGeminiRewritten/internal/models"
+)
+
+// ModelRouting is the server's default model routing, loaded from the file named by
+// MODEL_ROUTES_FILE. Requests override it per step.
+type ModelRouting struct {
+	StepModels     map[string]models.ModelRoute `json:"step_models,omitempty"`
+	FallbackModels []string                     `json:"fallback_models,omitempty"`
+}
+
+// LoadModelRouting reads a ModelRouting from a JSON file and checks its step names.
+func LoadModelRouting(path string) (ModelRouting, error) {
+	var routing ModelRouting
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return routing, fmt.Errorf("failed to read model routes %s: %w", path, err)
+	}
+	if err := json.Unmarshal(data, &routing); err != nil {
+		return routing, fmt.Errorf("failed to parse model routes %s: %w", path, err)
+	}
+	for step := range routing.StepModels {
+		if !slices.Contains(models.PipelineSteps, step) {
+			return routing, fmt.Errorf("unknown step '%s' in model routes %s", step, path)
+		}
+	}
+	return routing, nil
+}
+
+// modelTarget is one resolved entry of a step's model chain.
+type modelTarget struct {
+	spec     string // "backend:model" as configured, used in logs and output
+	provider ai.Provider
+	model    string // Bare model name sent to provider
+}
+
+// routeFor returns the model spec and ordered fallback specs for a step. The request's route
+// for the step wins over the server's; an unset model falls back to the request's model, and
+// unset fallbacks to the request's fallback_models, then the server's.
+func (r ModelRouting) routeFor(payload models.RequestPayload, step string) (string, []string) {
+	requested, configured := payload.StepModels[step], r.StepModels[step]
+
+	model := payload.Model
+	if requested.Model != "" {
+		model = requested.Model
+	} else if configured.Model != "" {
+		model = configured.Model
+	}
+
+	var fallbacks []string
+	switch {
+	case requested.Fallbacks != nil:
+		fallbacks = requested.Fallbacks
+	case payload.FallbackModels != nil:
+		fallbacks = payload.FallbackModels
+	case configured.Fallbacks != nil:
+		fallbacks = configured.Fallbacks
+	default:
+		fallbacks = r.FallbackModels
+	}
+	return model, fallbacks
+}
+
+// resolveModelChains resolves the model chain of every pipeline step. Providers are shared
+// between steps using the same spec; a chain never lists the same spec twice.
+func (r ModelRouting) resolveModelChains(payload models.RequestPayload, apiKey string) (map[string][]modelTarget, error) {
+	resolved := make(map[string]modelTarget)
+	resolve := func(spec string) (modelTarget, error) {
+		if target, ok := resolved[spec]; ok {
+			return target, nil
+		}
+		provider, model, err := ai.ResolveProvider(spec, apiKey)
+		if err != nil {
+			return modelTarget{}, fmt.Errorf("model '%s': %w", spec, err)
+		}
+		target := modelTarget{spec: spec, provider: provider, model: model}
+		resolved[spec] = target
+		return target, nil
+	}
+
+	chains := make(map[string][]modelTarget, len(models.PipelineSteps))
+	for _, step := range models.PipelineSteps {
+		model, fallbacks := r.routeFor(payload, step)
+		var chain []modelTarget
+		for _, spec := range uniqueSpecs(append([]string{model}, fallbacks...)) {
+			target, err := resolve(spec)
+			if err != nil {
+				return nil, fmt.Errorf("step %s: %w", step, err)
+			}
+			chain = append(chain, target)
+		}
+		if len(chain) == 0 {
+			return nil, fmt.Errorf("step %s: no model given", step)
+		}
+		chains[step] = chain
+	}
+	return chains, nil
+}
+
+// describeRoutes renders the request's model routing for the message log: the fallbacks
+// shared by every step, then each step whose chain differs from the request's model and those
+// fallbacks. It returns nothing when no routing or fallbacks are in effect.
+func (r ModelRouting) describeRoutes(payload models.RequestPayload, chains map[string][]modelTarget) []string {
+	model, fallbacks := r.routeFor(payload, "")
+	defaultSpecs := uniqueSpecs(append([]string{model}, fallbacks...))
+
+	var lines []string
+	if len(defaultSpecs) > 1 {
+		lines = append(lines, fmt.Sprintf("Fallback models: %s.\n", strings.Join(defaultSpecs[1:], ", ")))
+	}
+	for _, step := range models.PipelineSteps {
+		var specs []string
+		for _, target := range chains[step] {
+			specs = append(specs, target.spec)
+		}
+		if slices.Equal(specs, defaultSpecs) {
+			continue
+		}
+		text := fmt.Sprintf("  Step %s uses %s", step, specs[0])
+		if len(specs) > 1 {
+			text += fmt.Sprintf(" (fallbacks: %s)", strings.Join(specs[1:], ", "))
+		}
+		lines = append(lines, text+".\n")
+	}
+	return lines
+}
+
+// uniqueSpecs drops empty and repeated specs, keeping the first occurrence.
+func uniqueSpecs(specs []string) []string {
+	var out []string
+	for _, spec := range specs {
+		if spec != "" && !slices.Contains(out, spec) {
+			out = append(out, spec)
+		}
+	}
+	return out
+}
@@ -0,0 +1,206 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"workspace/FictionGeminiRewritten/internal/ai"
+	"workspace/FictionGeminiRewritten/internal/models"
+)
+
+const (
+	usageFileName = "usage.json"
+	priceCurrency = "USD"
+)
+
+// ModelPrice is what a model costs, in USD per million tokens.
+type ModelPrice struct {
+	InputPerMillion  float64 `json:"input_per_million"`
+	OutputPerMillion float64 `json:"output_per_million"`
+}
+
+// PriceTable maps model specs to prices. Keys are "backend:model" specs (bare names are Gemini
+// models, as in requests); a key also prices every model whose name it prefixes, such as dated
+// versions, and "backend:*" prices every model of a backend.
+type PriceTable map[string]ModelPrice
+
+// DefaultPriceTable holds Gemini's published list prices at the time of writing, for prompts up
+// to 128k tokens. Local backends are free. Override or extend it with MODEL_PRICES_FILE.
+var DefaultPriceTable = PriceTable{
+	"gemini:gemini-1.5-pro":      {InputPerMillion: 1.25, OutputPerMillion: 5.00},
+	"gemini:gemini-1.5-flash":    {InputPerMillion: 0.075, OutputPerMillion: 0.30},
+	"gemini:gemini-1.5-flash-8b": {InputPerMillion: 0.0375, OutputPerMillion: 0.15},
+	"gemini:gemini-2.0-flash":    {InputPerMillion: 0.10, OutputPerMillion: 0.40},
+	"gemini:gemini-2.5-pro":      {InputPerMillion: 1.25, OutputPerMillion: 10.00},
+	"gemini:gemini-2.5-flash":    {InputPerMillion: 0.30, OutputPerMillion: 2.50},
+	"ollama:*":                   {},
+}
+
+// LoadPriceTable reads prices from a JSON file, laid out like PriceTable, on top of the defaults.
+func LoadPriceTable(path string) (PriceTable, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read model prices %s: %w", path, err)
+	}
+	var overrides PriceTable
+	if err := json.Unmarshal(data, &overrides); err != nil {
+		return nil, fmt.Errorf("failed to parse model prices %s: %w", path, err)
+	}
+	prices := make(PriceTable, len(DefaultPriceTable)+len(overrides))
+	for spec, price := range DefaultPriceTable {
+		prices[spec] = price
+	}
+	for spec, price := range overrides {
+		prices[spec] = price
+	}
+	return prices, nil
+}
+
+// lookup returns the price of a model spec: the key that prefixes the most of its name, else
+// the backend's wildcard.
+func (t PriceTable) lookup(spec string) (ModelPrice, bool) {
+	backend, model := ai.ParseModelSpec(spec)
+	var best ModelPrice
+	bestLen, found := -1, false
+	for key, price := range t {
+		keyBackend, keyModel := ai.ParseModelSpec(key)
+		switch {
+		case keyBackend != backend:
+		case keyModel == "*":
+			if !found {
+				best, found = price, true // Any named match still wins, since bestLen stays -1
+			}
+		case strings.HasPrefix(model, keyModel) && len(keyModel) > bestLen:
+			best, bestLen, found = price, len(keyModel), true
+		}
+	}
+	return best, found
+}
+
+// cost returns the estimated cost of usage on spec, and whether the model has a known price.
+func (t PriceTable) cost(spec string, usage ai.Usage) (float64, bool) {
+	price, ok := t.lookup(spec)
+	if !ok {
+		return 0, false
+	}
+	return (float64(usage.PromptTokens)*price.InputPerMillion + float64(usage.CandidateTokens)*price.OutputPerMillion) / 1e6, true
+}
+
+// usageTracker accumulates a session's token usage. It is safe for concurrent use, since
+// fan-out batches report from several goroutines.
+type usageTracker struct {
+	prices PriceTable
+
+	mu     sync.Mutex
+	report models.UsageReport
+}
+
+// newUsageTracker starts tracking on top of previous, the report of earlier runs of a resumed
+// session, or from zero if previous is nil.
+func newUsageTracker(prices PriceTable, previous *models.UsageReport) *usageTracker {
+	t := &usageTracker{prices: prices}
+	if previous != nil {
+		t.report = *previous
+	}
+	t.report.Currency = priceCurrency
+	if t.report.Steps == nil {
+		t.report.Steps = make(map[string]models.TokenUsage)
+	}
+	if t.report.Models == nil {
+		t.report.Models = make(map[string]models.TokenUsage)
+	}
+	return t
+}
+
+// add records one call of step answered by spec and returns what it cost.
+func (t *usageTracker) add(step, spec string, usage ai.Usage) models.TokenUsage {
+	call := models.TokenUsage{
+		Calls:           1,
+		PromptTokens:    usage.PromptTokens,
+		CandidateTokens: usage.CandidateTokens,
+		TotalTokens:     usage.TotalTokens,
+	}
+	if call.TotalTokens == 0 {
+		call.TotalTokens = call.PromptTokens + call.CandidateTokens
+	}
+	cost, priced := t.prices.cost(spec, usage)
+	call.EstimatedCost = cost
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.report.Total = addUsage(t.report.Total, call)
+	t.report.Steps[step] = addUsage(t.report.Steps[step], call)
+	t.report.Models[spec] = addUsage(t.report.Models[spec], call)
+	if !priced && !slices.Contains(t.report.UnpricedModels, spec) {
+		t.report.UnpricedModels = append(t.report.UnpricedModels, spec)
+	}
+	return call
+}
+
+// snapshot returns a copy of the report so far.
+func (t *usageTracker) snapshot() models.UsageReport {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	report := t.report
+	report.Steps = make(map[string]models.TokenUsage, len(t.report.Steps))
+	for step, usage := range t.report.Steps {
+		report.Steps[step] = usage
+	}
+	report.Models = make(map[string]models.TokenUsage, len(t.report.Models))
+	for spec, usage := range t.report.Models {
+		report.Models[spec] = usage
+	}
+	report.UnpricedModels = slices.Clone(t.report.UnpricedModels)
+	return report
+}
+
+// finish stamps the report, saves it as usage.json in the session directory and announces it
+// in events. Sessions that made no model calls leave no file.
+func (t *usageTracker) finish(sessionDir string, events *EventLog) models.UsageReport {
+	t.mu.Lock()
+	t.report.UpdatedAt = time.Now().Format(time.RFC3339)
+	t.mu.Unlock()
+	report := t.snapshot()
+
+	if report.Total.Calls > 0 {
+		path := filepath.Join(sessionDir, usageFileName)
+		if err := os.MkdirAll(sessionDir, 0755); err != nil {
+			log.Printf("Failed to save usage report %s: %v", path, err)
+		} else if err := os.WriteFile(path, []byte(prettyJSON(report)), 0644); err != nil {
+			log.Printf("Failed to save usage report %s: %v", path, err)
+		}
+	}
+	events.Usage(report)
+	return report
+}
+
+// loadUsageReport reads the usage report saved in a session directory, if there is one.
+func loadUsageReport(sessionDir string) *models.UsageReport {
+	data, err := os.ReadFile(filepath.Join(sessionDir, usageFileName))
+	if err != nil {
+		return nil
+	}
+	var report models.UsageReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		log.Printf("Ignoring unreadable usage report in %s: %v", sessionDir, err)
+		return nil
+	}
+	return &report
+}
+
+func addUsage(a, b models.TokenUsage) models.TokenUsage {
+	return models.TokenUsage{
+		Calls:           a.Calls + b.Calls,
+		PromptTokens:    a.PromptTokens + b.PromptTokens,
+		CandidateTokens: a.CandidateTokens + b.CandidateTokens,
+		TotalTokens:     a.TotalTokens + b.TotalTokens,
+		EstimatedCost:   a.EstimatedCost + b.EstimatedCost,
+	}
+}
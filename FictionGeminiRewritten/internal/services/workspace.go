@@ -0,0 +1,166 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"workspace/FictionGeminiRewritten/internal/cards"
+	"workspace/FictionGeminiRewritten/internal/models"
+)
+
+// A series workspace holds what the user brought to the series rather than what was generated
+// for it: imported cards, saved as jsons/<series>/workspace/cards/<name>.json. Requests pick
+// them by name in context_cards, and every card and lorebook prompt then quotes them.
+
+// workspaceDirName is the directory beside a series' sessions that holds its workspace.
+const workspaceDirName = "workspace"
+
+var (
+	// ErrWorkspaceCardNotFound is returned when a series workspace has no card of the requested name.
+	ErrWorkspaceCardNotFound = errors.New("no imported card of this name in the series workspace")
+
+	// ErrInvalidCard is returned when an imported file can't be read as a character card.
+	ErrInvalidCard = errors.New("invalid card")
+)
+
+// workspaceCardPath returns where a workspace card is stored.
+func workspaceCardPath(series, name string) string {
+	return filepath.Join(baseJSONSaveDir, SanitizeStringForPath(series, true), workspaceDirName, "cards", name+".json")
+}
+
+// ImportCard reads a PNG or JSON card and normalizes it. If series is set, the card is stored
+// in the series workspace under name, or under its own name if name is empty, replacing any
+// card stored under that name before.
+func ImportCard(data []byte, series, name string) (models.ImportedCard, error) {
+	card, report, err := cards.Import(data)
+	imported := models.ImportedCard{
+		Series:     series,
+		ImportedAt: time.Now().Format(time.RFC3339),
+		Card:       card,
+		Report:     report,
+	}
+	if err != nil {
+		return imported, fmt.Errorf("%w: %v", ErrInvalidCard, err)
+	}
+	if strings.TrimSpace(series) == "" {
+		return imported, nil
+	}
+
+	if strings.TrimSpace(name) == "" {
+		name = card.Data.Name
+	}
+	imported.Name = SanitizeStringForPath(name, true)
+	jsonData, err := json.MarshalIndent(imported, "", "  ")
+	if err != nil {
+		return imported, fmt.Errorf("failed to encode imported card: %w", err)
+	}
+	path := workspaceCardPath(series, imported.Name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return imported, fmt.Errorf("failed to save imported card: %w", err)
+	}
+	if err := os.WriteFile(path, jsonData, 0644); err != nil {
+		return imported, fmt.Errorf("failed to save imported card: %w", err)
+	}
+	log.Printf("Imported card '%s' into the workspace of '%s': %s", card.Data.Name, series, path)
+	return imported, nil
+}
+
+// WorkspaceCards lists the cards imported into a series workspace, by name.
+func WorkspaceCards(series string) (models.ImportedCardList, error) {
+	list := models.ImportedCardList{Series: series, Cards: []models.ImportedCardInfo{}}
+	paths, _ := filepath.Glob(workspaceCardPath(series, "*"))
+	sort.Strings(paths)
+	for _, path := range paths {
+		imported, err := readWorkspaceCard(path)
+		if err != nil {
+			log.Printf("Skipping unreadable workspace card %s: %v", path, err)
+			continue
+		}
+		list.Cards = append(list.Cards, models.ImportedCardInfo{
+			Name:       imported.Name,
+			CardName:   imported.Card.Data.Name,
+			Source:     imported.Report.Source,
+			Spec:       imported.Report.Spec,
+			ImportedAt: imported.ImportedAt,
+		})
+	}
+	return list, nil
+}
+
+// LoadWorkspaceCards returns the named cards of a series workspace, in the order given.
+func LoadWorkspaceCards(series string, names []string) ([]models.ImportedCard, error) {
+	loaded := make([]models.ImportedCard, 0, len(names))
+	for _, name := range names {
+		if name != SanitizeStringForPath(name, true) {
+			return nil, fmt.Errorf("card '%s': %w", name, ErrWorkspaceCardNotFound)
+		}
+		imported, err := readWorkspaceCard(workspaceCardPath(series, name))
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("card '%s': %w", name, ErrWorkspaceCardNotFound)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("card '%s': %w", name, err)
+		}
+		loaded = append(loaded, imported)
+	}
+	return loaded, nil
+}
+
+func readWorkspaceCard(path string) (models.ImportedCard, error) {
+	var imported models.ImportedCard
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return imported, err
+	}
+	if err := json.Unmarshal(data, &imported); err != nil {
+		return imported, fmt.Errorf("unreadable workspace card: %w", err)
+	}
+	return imported, nil
+}
+
+// contextCardsText renders the request's context cards for prompts: each card's name and
+// excerpts of its description, personality and scenario, and the titles of its lorebook
+// entries. It returns "" if the request names none.
+func contextCardsText(payload models.RequestPayload) (string, error) {
+	loaded, err := LoadWorkspaceCards(payload.Series, payload.ContextCards)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	for _, imported := range loaded {
+		data := imported.Card.Data
+		fmt.Fprintf(&b, "  - %s\n", data.Name)
+		for _, field := range []struct{ label, text string }{
+			{"Description", data.Description},
+			{"Personality", data.Personality},
+			{"Scenario", data.Scenario},
+		} {
+			if text := snippet(field.text); text != "" {
+				fmt.Fprintf(&b, "    %s: %s\n", field.label, text)
+			}
+		}
+		if book := data.CharacterBook; book != nil && len(book.Entries) > 0 {
+			var titles []string
+			for _, entry := range book.Entries {
+				title := entry.Comment
+				if title == "" && len(entry.Keys) > 0 {
+					title = entry.Keys[0]
+				}
+				if title != "" {
+					titles = append(titles, title)
+				}
+			}
+			if len(titles) > 0 {
+				fmt.Fprintf(&b, "    Lorebook entries: %s\n", snippet(strings.Join(titles, "; ")))
+			}
+		}
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,29 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"workspace/FictionGeminiRewritten/internal/models"
+	"workspace/FictionGeminiRewritten/internal/worldinfo"
+)
+
+// worldsDirName is the directory of a session that holds its lorebooks as SillyTavern World
+// Info files, named like SillyTavern's own.
+const worldsDirName = "worlds"
+
+// saveWorldInfo converts lorebook to SillyTavern's World Info layout and saves it in the
+// session's worlds directory, under the name of the lorebook's JSON file. It returns the
+// file's path, or "" if it could not be saved, which is reported but doesn't fail the step.
+func (a *aiSession) saveWorldInfo(lorebook models.Lorebook, jsonPath string) string {
+	data, err := json.MarshalIndent(worldinfo.FromLorebook(lorebook), "", "  ")
+	if err == nil {
+		var path string
+		if path, err = SaveWorldInfoNextToFile(jsonPath, data); err == nil {
+			a.events.Message(fmt.Sprintf("  Saved World Info file to: %s\n", path))
+			return path
+		}
+	}
+	a.events.Message(fmt.Sprintf("  WARNING: failed to save World Info file for '%s': %v\n", lorebook.Name, err))
+	return ""
+}
@@ -0,0 +1,468 @@
+// Package worldinfo converts lorebooks between the character_book layout of models.Lorebook and
+// the layout of SillyTavern's standalone World Info files.
+//
+// The mapping is SillyTavern's own: World Info fields that a character_book entry has no place
+// for live in the entry's extensions, under the names SillyTavern uses when it embeds a world
+// in a card, so a converted lorebook can be embedded in a card and read back by SillyTavern
+// unchanged. Lorebook fields World Info has no place for (an entry's priority, regex flag and
+// other extensions, the lorebook's name and settings) are kept in members SillyTavern ignores, so
+// converting a lorebook to a world and back loses nothing.
+package worldinfo
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"workspace/FictionGeminiRewritten/internal/models"
+)
+
+// Entry extensions under which SillyTavern keeps World Info fields in a character_book.
+const (
+	extPosition            = "position"
+	extExcludeRecursion    = "exclude_recursion"
+	extDisplayIndex        = "display_index"
+	extProbability         = "probability"
+	extUseProbability      = "useProbability"
+	extDepth               = "depth"
+	extSelectiveLogic      = "selectiveLogic"
+	extGroup               = "group"
+	extGroupOverride       = "group_override"
+	extGroupWeight         = "group_weight"
+	extPreventRecursion    = "prevent_recursion"
+	extDelayUntilRecursion = "delay_until_recursion"
+	extScanDepth           = "scan_depth"
+	extMatchWholeWords     = "match_whole_words"
+	extUseGroupScoring     = "use_group_scoring"
+	extCaseSensitive       = "case_sensitive"
+	extAutomationID        = "automation_id"
+	extRole                = "role"
+	extVectorized          = "vectorized"
+	extSticky              = "sticky"
+	extCooldown            = "cooldown"
+	extDelay               = "delay"
+)
+
+// worldExtensions lists every extension FromLorebook reads into a World Info field.
+var worldExtensions = []string{
+	extPosition, extExcludeRecursion, extDisplayIndex, extProbability, extUseProbability, extDepth,
+	extSelectiveLogic, extGroup, extGroupOverride, extGroupWeight, extPreventRecursion,
+	extDelayUntilRecursion, extScanDepth, extMatchWholeWords, extUseGroupScoring, extCaseSensitive,
+	extAutomationID, extRole, extVectorized, extSticky, extCooldown, extDelay,
+}
+
+// Defaults of a new World Info entry in SillyTavern.
+const (
+	defaultDepth       = 4
+	defaultProbability = 100
+	defaultGroupWeight = 100
+)
+
+// FromLorebook converts a lorebook to a World Info file. Entries keep their id as their UID;
+// entries without one, or whose id is taken, get the lowest free UID. Keys of entries that use
+// regular expressions are written as "/pattern/" keys, with the "i" flag unless the entry is
+// case-sensitive, and ToLorebook turns them back into patterns. The lorebook passed in is not
+// modified.
+func FromLorebook(book models.Lorebook) models.WorldInfo {
+	world := models.WorldInfo{Entries: make(map[string]models.WorldInfoEntry, len(book.Entries))}
+	settings := book
+	settings.Entries = []models.LorebookEntry{}
+	world.OriginalData = &settings
+
+	uids := assignUIDs(book.Entries)
+	for i, entry := range book.Entries {
+		converted := fromEntry(entry, uids[i], i)
+		world.Entries[strconv.Itoa(converted.UID)] = converted
+	}
+	return world
+}
+
+// ToLorebook converts a World Info file to a lorebook named name, or, if name is empty, after
+// the lorebook the world was converted from. Entries are ordered as SillyTavern's editor shows
+// them, by display index.
+func ToLorebook(world models.WorldInfo, name string) models.Lorebook {
+	book := models.Lorebook{Enabled: true}
+	if world.OriginalData != nil {
+		book = *world.OriginalData
+		book.Enabled = true
+	}
+	if name != "" {
+		book.Name = name
+	}
+
+	entries := slices.Collect(maps.Values(world.Entries))
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].DisplayIndex != entries[j].DisplayIndex {
+			return entries[i].DisplayIndex < entries[j].DisplayIndex
+		}
+		return entries[i].UID < entries[j].UID
+	})
+	book.Entries = make([]models.LorebookEntry, 0, len(entries))
+	for _, entry := range entries {
+		book.Entries = append(book.Entries, toEntry(entry))
+	}
+	return book
+}
+
+// assignUIDs picks the UID of each entry: its id if it has a free one, else the lowest free UID.
+func assignUIDs(entries []models.LorebookEntry) []int {
+	uids := make([]int, len(entries))
+	taken := map[int]bool{}
+	for i, entry := range entries {
+		uids[i] = -1
+		if id := entry.ID; id != nil && *id >= 0 && !taken[*id] {
+			uids[i] = *id
+			taken[*id] = true
+		}
+	}
+	next := 0
+	for i := range uids {
+		if uids[i] >= 0 {
+			continue
+		}
+		for taken[next] {
+			next++
+		}
+		uids[i] = next
+		taken[next] = true
+	}
+	return uids
+}
+
+// fromEntry converts the lorebook entry at index to a World Info entry with the given UID.
+// Settings the entry has a field for come from that field. The extensions SillyTavern keeps the
+// same settings in only fill in those the field leaves unset, as in cards SillyTavern exported,
+// which have them nowhere else; a flag or timer cleared on the entry can't be told from one
+// that was never set, so the extensions of such cards still apply to it.
+func fromEntry(entry models.LorebookEntry, uid, index int) models.WorldInfoEntry {
+	ext := entry.Extensions
+	unknown := maps.Clone(entry.Unknown)
+
+	converted := models.WorldInfoEntry{
+		UID:                 uid,
+		Key:                 worldKeys(entry.Keys, entry.UseRegex, entry.CaseSensitive),
+		KeySecondary:        worldKeys(entry.SecondaryKeys, entry.UseRegex, entry.CaseSensitive),
+		Comment:             entry.Comment,
+		Content:             entry.Content,
+		Constant:            entry.Constant,
+		Vectorized:          entry.Vectorized || extBool(ext, extVectorized, false),
+		Selective:           true,
+		SelectiveLogic:      extInt(ext, extSelectiveLogic, models.WorldInfoAndAny),
+		AddMemo:             true,
+		Order:               entry.InsertionOrder,
+		Position:            worldPosition(entry.Position, ext),
+		Disable:             !entry.Enabled,
+		ExcludeRecursion:    entry.ExcludeRecursion || extBool(ext, extExcludeRecursion, false),
+		PreventRecursion:    entry.PreventRecursion || extBool(ext, extPreventRecursion, false),
+		DelayUntilRecursion: false,
+		Probability:         extInt(ext, extProbability, defaultProbability),
+		UseProbability:      extBool(ext, extUseProbability, true),
+		Depth:               extInt(ext, extDepth, defaultDepth),
+		Group:               cmp.Or(entry.Group, extString(ext, extGroup)),
+		GroupOverride:       extBool(ext, extGroupOverride, false),
+		GroupWeight:         extInt(ext, extGroupWeight, defaultGroupWeight),
+		ScanDepth:           extIntPtr(ext, extScanDepth),
+		CaseSensitive:       extBoolPtr(ext, extCaseSensitive),
+		MatchWholeWords:     extBoolPtr(ext, extMatchWholeWords),
+		UseGroupScoring:     extBoolPtr(ext, extUseGroupScoring),
+		AutomationID:        extString(ext, extAutomationID),
+		Role:                extIntPtr(ext, extRole),
+		Sticky:              positiveOr(entry.Sticky, extIntPtr(ext, extSticky)),
+		Cooldown:            positiveOr(entry.Cooldown, extIntPtr(ext, extCooldown)),
+		Delay:               positiveOr(entry.Delay, extIntPtr(ext, extDelay)),
+		DisplayIndex:        extInt(ext, extDisplayIndex, index),
+	}
+	if value, ok := ext[extDelayUntilRecursion]; ok && value != nil {
+		converted.DelayUntilRecursion = value
+	}
+	if logic := slices.Index(models.SelectiveLogics, strings.ToUpper(entry.SelectiveLogic)); logic >= 0 {
+		converted.SelectiveLogic = logic
+	}
+	if entry.Probability > 0 {
+		converted.Probability = entry.Probability
+	}
+	if entry.Depth != nil {
+		converted.Depth = *entry.Depth
+	}
+	if entry.GroupWeight > 0 {
+		converted.GroupWeight = entry.GroupWeight
+	}
+	if entry.CaseSensitive {
+		converted.CaseSensitive = &entry.CaseSensitive
+	}
+	if entry.MatchWholeWords {
+		converted.MatchWholeWords = &entry.MatchWholeWords
+	}
+	// The V2 spec's own field, which SillyTavern writes when it embeds a world in a card.
+	var selective bool
+	if takeUnknown(unknown, "selective", &selective) {
+		converted.Selective = selective
+	}
+
+	// What World Info has no field for.
+	takeUnknown(unknown, "addMemo", &converted.AddMemo)
+	if entry.Name != "" {
+		unknown = putUnknown(unknown, "name", entry.Name)
+	}
+	if entry.Priority != 0 {
+		unknown = putUnknown(unknown, "priority", entry.Priority)
+	}
+	if entry.UseRegex {
+		unknown = putUnknown(unknown, "use_regex", true)
+	}
+	if rest := maps.Clone(ext); len(rest) > 0 {
+		for _, key := range worldExtensions {
+			delete(rest, key)
+		}
+		if len(rest) > 0 {
+			unknown = putUnknown(unknown, "extensions", rest)
+		}
+	}
+	if len(unknown) > 0 {
+		converted.Unknown = unknown
+	}
+	if converted.Key == nil {
+		converted.Key = []string{}
+	}
+	if converted.KeySecondary == nil {
+		converted.KeySecondary = []string{}
+	}
+	return converted
+}
+
+// toEntry converts a World Info entry to a lorebook entry, as SillyTavern embeds it in a card.
+func toEntry(entry models.WorldInfoEntry) models.LorebookEntry {
+	unknown := maps.Clone(entry.Unknown)
+	var priority int
+	takeUnknown(unknown, "priority", &priority)
+	var name string
+	takeUnknown(unknown, "name", &name)
+	var useRegex bool
+	takeUnknown(unknown, "use_regex", &useRegex)
+	var ext models.Extensions
+	takeUnknown(unknown, "extensions", &ext)
+	if ext == nil {
+		ext = models.Extensions{}
+	}
+
+	if !entry.AddMemo {
+		unknown = putUnknown(unknown, "addMemo", false)
+	}
+	unknown = putUnknown(unknown, "selective", entry.Selective)
+
+	// Settings without a field of their own are kept in the extensions, as SillyTavern does.
+	// Those with one are only kept in the field, so editing it is enough to change them.
+	ext[extDisplayIndex] = entry.DisplayIndex
+	ext[extUseProbability] = entry.UseProbability
+	ext[extGroupOverride] = entry.GroupOverride
+	ext[extDelayUntilRecursion] = entry.DelayUntilRecursion
+	ext[extScanDepth] = entry.ScanDepth
+	ext[extUseGroupScoring] = entry.UseGroupScoring
+	ext[extAutomationID] = entry.AutomationID
+	ext[extRole] = entry.Role
+
+	uid, depth := entry.UID, entry.Depth
+	caseSensitive := entry.CaseSensitive != nil && *entry.CaseSensitive
+	converted := models.LorebookEntry{
+		Keys:             lorebookKeys(entry.Key, useRegex, caseSensitive),
+		Content:          entry.Content,
+		Enabled:          !entry.Disable,
+		InsertionOrder:   entry.Order,
+		Priority:         priority,
+		Comment:          entry.Comment,
+		SelectiveLogic:   nameAt(models.SelectiveLogics, entry.SelectiveLogic),
+		SecondaryKeys:    lorebookKeys(entry.KeySecondary, useRegex, caseSensitive),
+		Constant:         entry.Constant,
+		CaseSensitive:    caseSensitive,
+		Probability:      entry.Probability,
+		UseRegex:         useRegex,
+		ID:               &uid,
+		Name:             name,
+		Position:         nameAt(models.LorebookPositions, entry.Position),
+		Depth:            &depth,
+		Group:            entry.Group,
+		GroupWeight:      entry.GroupWeight,
+		Sticky:           derefInt(entry.Sticky),
+		Cooldown:         derefInt(entry.Cooldown),
+		Delay:            derefInt(entry.Delay),
+		ExcludeRecursion: entry.ExcludeRecursion,
+		PreventRecursion: entry.PreventRecursion,
+		MatchWholeWords:  entry.MatchWholeWords != nil && *entry.MatchWholeWords,
+		Vectorized:       entry.Vectorized,
+		Extensions:       ext,
+		Unknown:          unknown,
+	}
+	if converted.Keys == nil {
+		converted.Keys = []string{}
+	}
+	return converted
+}
+
+// worldPosition returns the World Info position of an entry. The position extension wins over
+// the V2 spec's names, which can't tell the positions after the character definitions apart.
+func worldPosition(position string, ext models.Extensions) int {
+	named := slices.Index(models.LorebookPositions, position)
+	if fromExt := extIntPtr(ext, extPosition); fromExt != nil {
+		if named < 0 || named == min(*fromExt, models.WorldInfoAfterChar) {
+			return *fromExt
+		}
+	}
+	return max(named, models.WorldInfoBeforeChar)
+}
+
+// nameAt returns names[i], or "" if i is out of range.
+func nameAt(names []string, i int) string {
+	if i < 0 || i >= len(names) {
+		return ""
+	}
+	return names[i]
+}
+
+// positiveOr returns a pointer to n if it is positive, else fallback.
+func positiveOr(n int, fallback *int) *int {
+	if n > 0 {
+		return &n
+	}
+	return fallback
+}
+
+func derefInt(n *int) int {
+	if n == nil {
+		return 0
+	}
+	return *n
+}
+
+// worldKeys returns keys as World Info keys: regular expressions are written as "/pattern/".
+func worldKeys(keys []string, regex, caseSensitive bool) []string {
+	if !regex || keys == nil {
+		return slices.Clone(keys)
+	}
+	flags := "i"
+	if caseSensitive {
+		flags = ""
+	}
+	converted := make([]string, len(keys))
+	for i, key := range keys {
+		if IsRegexKey(key) {
+			converted[i] = key
+			continue
+		}
+		converted[i] = "/" + strings.ReplaceAll(key, "/", `\/`) + "/" + flags
+	}
+	return converted
+}
+
+// lorebookKeys undoes worldKeys: if regex is set, keys written as "/pattern/" with the flags
+// worldKeys adds are returned as the pattern. A key that was already written that way in the
+// lorebook comes back as its pattern too, which matches the same text.
+func lorebookKeys(keys []string, regex, caseSensitive bool) []string {
+	if !regex || keys == nil {
+		return slices.Clone(keys)
+	}
+	suffix := "/i"
+	if caseSensitive {
+		suffix = "/"
+	}
+	converted := make([]string, len(keys))
+	for i, key := range keys {
+		converted[i] = key
+		if pattern, ok := strings.CutSuffix(key, suffix); ok && IsRegexKey(key) {
+			converted[i] = strings.ReplaceAll(pattern[1:], `\/`, "/")
+		}
+	}
+	return converted
+}
+
+// IsRegexKey reports whether a World Info key is a regular expression, written "/pattern/flags".
+func IsRegexKey(key string) bool {
+	end := strings.LastIndex(key, "/")
+	if !strings.HasPrefix(key, "/") || end < 2 {
+		return false
+	}
+	return strings.Trim(key[end+1:], "dgimsuvy") == ""
+}
+
+// takeUnknown decodes the unknown member name into target and removes it, reporting whether
+// it was there and decoded.
+func takeUnknown(unknown models.UnknownFields, name string, target any) bool {
+	raw, ok := unknown[name]
+	if !ok || json.Unmarshal(raw, target) != nil {
+		return false
+	}
+	delete(unknown, name)
+	return true
+}
+
+// putUnknown sets the unknown member name to value, creating the map if need be.
+func putUnknown(unknown models.UnknownFields, name string, value any) models.UnknownFields {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		panic(fmt.Sprintf("worldinfo: encoding %s: %v", name, err)) // Only plain values are put
+	}
+	if unknown == nil {
+		unknown = make(models.UnknownFields)
+	}
+	unknown[name] = raw
+	return unknown
+}
+
+// Extensions decoded from JSON hold float64 numbers, and those set in Go hold ints; both are read.
+
+func extInt(ext models.Extensions, key string, fallback int) int {
+	if n := extIntPtr(ext, key); n != nil {
+		return *n
+	}
+	return fallback
+}
+
+func extIntPtr(ext models.Extensions, key string) *int {
+	var n int
+	switch v := ext[key].(type) {
+	case int:
+		n = v
+	case float64:
+		n = int(v)
+	case json.Number:
+		i, err := v.Int64()
+		if err != nil {
+			return nil
+		}
+		n = int(i)
+	case *int:
+		if v == nil {
+			return nil
+		}
+		n = *v
+	default:
+		return nil
+	}
+	return &n
+}
+
+func extBool(ext models.Extensions, key string, fallback bool) bool {
+	if b := extBoolPtr(ext, key); b != nil {
+		return *b
+	}
+	return fallback
+}
+
+func extBoolPtr(ext models.Extensions, key string) *bool {
+	switch v := ext[key].(type) {
+	case bool:
+		return &v
+	case *bool:
+		return v
+	}
+	return nil
+}
+
+func extString(ext models.Extensions, key string) string {
+	s, _ := ext[key].(string)
+	return s
+}
@@ -0,0 +1,153 @@
+package worldinfo
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+
+	"workspace/FictionGeminiRewritten/internal/models"
+)
+
+// roundTripJSON encodes v and decodes it into out, as saving and loading a file would.
+func roundTripJSON[T any](t *testing.T, v T) T {
+	t.Helper()
+	var out T
+	data, err := json.Marshal(v)
+	if err == nil {
+		err = json.Unmarshal(data, &out)
+	}
+	if err != nil {
+		t.Fatalf("round trip: %v", err)
+	}
+	return out
+}
+
+// jsonOf renders v as it would be saved, to compare worlds and lorebooks by what a file holds.
+func jsonOf(t *testing.T, v any) string {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	return string(data)
+}
+
+const world = `{"entries":{
+"3":{"uid":3,"key":["spice","/mel(ange)?/i"],"keysecondary":["Arrakis"],"comment":"Spice","content":"The spice extends life.","constant":false,"vectorized":false,"selective":true,"selectiveLogic":3,"addMemo":true,"order":90,"position":4,"disable":false,"excludeRecursion":true,"preventRecursion":false,"delayUntilRecursion":2,"probability":75,"useProbability":true,"depth":2,"group":"resources","groupOverride":false,"groupWeight":40,"scanDepth":null,"caseSensitive":true,"matchWholeWords":null,"useGroupScoring":null,"automationId":"","role":1,"sticky":2,"cooldown":null,"delay":null,"displayIndex":0,"triggers":["normal"]},
+"7":{"uid":7,"key":["Fremen"],"keysecondary":[],"comment":"","content":"Desert people.","constant":true,"vectorized":false,"selective":false,"selectiveLogic":0,"addMemo":false,"order":100,"position":1,"disable":true,"excludeRecursion":false,"preventRecursion":true,"delayUntilRecursion":false,"probability":100,"useProbability":false,"depth":4,"group":"","groupOverride":false,"groupWeight":100,"scanDepth":3,"caseSensitive":null,"matchWholeWords":true,"useGroupScoring":null,"automationId":"","role":null,"sticky":null,"cooldown":5,"delay":1,"displayIndex":1}
+}}`
+
+func TestWorldToLorebookAndBack(t *testing.T) {
+	var original models.WorldInfo
+	if err := json.Unmarshal([]byte(world), &original); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	book := roundTripJSON(t, ToLorebook(original, "Arrakis"))
+	if book.Name != "Arrakis" || len(book.Entries) != 2 {
+		t.Fatalf("lorebook = %s", jsonOf(t, book))
+	}
+	spice := book.Entries[0]
+	if spice.InsertionOrder != 90 || spice.SelectiveLogic != models.SelectiveLogicAndAll || !spice.CaseSensitive || spice.Probability != 75 {
+		t.Errorf("spice entry = %s", jsonOf(t, spice))
+	}
+	if book.Entries[1].Enabled || !book.Entries[1].Constant {
+		t.Errorf("Fremen entry = %s", jsonOf(t, book.Entries[1]))
+	}
+
+	back := FromLorebook(book)
+	if got, want := jsonOf(t, back.Entries), jsonOf(t, original.Entries); got != want {
+		t.Errorf("world -> lorebook -> world changed the entries:\n got %s\nwant %s", got, want)
+	}
+}
+
+func TestClearedSettingsStayCleared(t *testing.T) {
+	var original models.WorldInfo
+	if err := json.Unmarshal([]byte(world), &original); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	book := roundTripJSON(t, ToLorebook(original, ""))
+	spice := &book.Entries[0]
+	if !spice.ExcludeRecursion || spice.Sticky != 2 {
+		t.Fatalf("spice entry = %s, want exclude_recursion and sticky 2", jsonOf(t, spice))
+	}
+	spice.ExcludeRecursion = false
+	spice.Sticky = 0
+	spice.Group = ""
+
+	back := FromLorebook(book).Entries["3"]
+	if back.ExcludeRecursion || back.Sticky != nil || back.Group != "" {
+		t.Errorf("cleared settings came back: %s", jsonOf(t, back))
+	}
+}
+
+func TestLorebookToWorldAndBack(t *testing.T) {
+	depth := 0
+	book := models.Lorebook{
+		Name:        "Arrakis",
+		Description: "Desert planet.",
+		ScanDepth:   10,
+		TokenBudget: 2048,
+		Enabled:     true,
+		Entries: []models.LorebookEntry{
+			{Keys: []string{"spice"}, Content: "The spice.", Enabled: true, InsertionOrder: 1, Priority: 90, Comment: "Spice",
+				SelectiveLogic: models.SelectiveLogicNotAny, SecondaryKeys: []string{"sand"}, Probability: 50,
+				Extensions: models.Extensions{"chara_card_v3": map[string]interface{}{"decorators": []interface{}{"@@depth 4"}}}},
+			{Keys: []string{"^Fremen$", "sietch/tabr"}, Content: "Desert people.", Enabled: false, InsertionOrder: 2, UseRegex: true,
+				Name: "fremen", Position: models.LorebookPositionAtDepth, Depth: &depth, Group: "peoples", GroupWeight: 30,
+				Sticky: 2, Cooldown: 3, PreventRecursion: true, MatchWholeWords: true},
+			{Keys: []string{"sandworm"}, Content: "Shai-Hulud.", Enabled: true, InsertionOrder: 3, Position: models.LorebookPositionAfterChar,
+				Extensions: models.Extensions{"position": 5.0, "sticky": 4.0}},
+		},
+	}
+	world := roundTripJSON(t, FromLorebook(book))
+	if len(world.Entries) != 3 || world.Entries["1"].Key[0] != "/^Fremen$/i" || world.Entries["0"].SelectiveLogic != models.WorldInfoNotAny {
+		t.Fatalf("world = %s", jsonOf(t, world))
+	}
+	fremen := world.Entries["1"]
+	if fremen.Position != models.WorldInfoAtDepth || fremen.Depth != 0 || fremen.Group != "peoples" || fremen.GroupWeight != 30 ||
+		*fremen.Sticky != 2 || *fremen.Cooldown != 3 || fremen.Delay != nil || !fremen.PreventRecursion || !*fremen.MatchWholeWords {
+		t.Errorf("Fremen entry = %s", jsonOf(t, fremen))
+	}
+	// SillyTavern writes after_char for every position after the character definitions.
+	if worm := world.Entries["2"]; worm.Position != models.WorldInfoEMTop || *worm.Sticky != 4 {
+		t.Errorf("sandworm entry = %s", jsonOf(t, worm))
+	}
+
+	back := ToLorebook(world, "")
+	if back.Name != "Arrakis" || back.ScanDepth != 10 || back.TokenBudget != 2048 {
+		t.Errorf("lorebook settings lost: %s", jsonOf(t, back))
+	}
+	spice := back.Entries[0]
+	if spice.Priority != 90 || spice.Probability != 50 || spice.Comment != "Spice" || spice.SecondaryKeys[0] != "sand" ||
+		jsonOf(t, spice.Extensions["chara_card_v3"]) != `{"decorators":["@@depth 4"]}` {
+		t.Errorf("spice entry = %s", jsonOf(t, spice))
+	}
+	if fremen := back.Entries[1]; fremen.Enabled || fremen.Name != "fremen" || fremen.Position != models.LorebookPositionAtDepth || *fremen.Depth != 0 || *fremen.ID != 1 ||
+		!fremen.UseRegex || !slices.Equal(fremen.Keys, []string{"^Fremen$", "sietch/tabr"}) {
+		t.Errorf("Fremen entry = %s", jsonOf(t, fremen))
+	}
+	if worm := back.Entries[2]; worm.UseRegex || !slices.Equal(worm.Keys, []string{"sandworm"}) {
+		t.Errorf("sandworm entry = %s", jsonOf(t, worm))
+	}
+}
+
+func TestFromLorebookAssignsUIDs(t *testing.T) {
+	id := 1
+	entries := make([]models.LorebookEntry, 3)
+	entries[0].ID = &id
+	entries[2].ID = &id
+	world := FromLorebook(models.Lorebook{Entries: entries})
+	for key, want := range map[string]int{"1": 0, "0": 1, "2": 2} {
+		if entry, ok := world.Entries[key]; !ok || entry.DisplayIndex != want {
+			t.Errorf("entry with UID %s = %s, want display index %d", key, jsonOf(t, entry), want)
+		}
+	}
+}
+
+func TestIsRegexKey(t *testing.T) {
+	for key, want := range map[string]bool{"/a/": true, "/mel(ange)?/gi": true, "/": false, "//": false, "/a/x": false, "spice": false} {
+		if got := IsRegexKey(key); got != want {
+			t.Errorf("IsRegexKey(%q) = %v, want %v", key, got, want)
+		}
+	}
+}